@@ -0,0 +1,158 @@
+// Package calendar writes iCalendar feeds (RFC 5545) of upcoming episodes and of replay expirations.
+package calendar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/simulot/aspiratv/metadata/nfo"
+)
+
+// Event of the calendar
+type Event struct {
+	UID         string // Unique identifier, kept between feeds so calendars update the event
+	Summary     string
+	Description string
+	URL         string
+	Start       time.Time
+	End         time.Time // Zero for events without duration
+	AllDay      bool      // The event lasts the day of Start
+}
+
+// Calendar is a list of events
+type Calendar struct {
+	Name   string
+	Events []Event
+}
+
+// New returns an empty calendar
+func New(name string) *Calendar {
+	return &Calendar{Name: name}
+}
+
+// Add events to the calendar
+func (c *Calendar) Add(events ...Event) {
+	c.Events = append(c.Events, events...)
+}
+
+// AddMedia adds the events of the media: its upcoming availability or broadcast, and the
+// expiry of its replay. Past dates are ignored.
+func (c *Calendar) AddMedia(provider, id string, info *nfo.MediaInfo, now time.Time) {
+	title := info.Title
+	if info.Showtitle != "" && info.Showtitle != info.Title {
+		title = info.Showtitle + " - " + info.Title
+	}
+	uid := provider + "-" + id
+	switch {
+	case info.AvailableFrom.After(now):
+		c.Add(Event{
+			UID:         uid + "-available@aspiratv",
+			Summary:     "New: " + title,
+			Description: info.Plot,
+			URL:         info.PageURL,
+			Start:       info.AvailableFrom,
+		})
+	case info.AvailableFrom.IsZero() && info.Aired.Time().After(now):
+		c.Add(Event{
+			UID:         uid + "-aired@aspiratv",
+			Summary:     "New: " + title,
+			Description: info.Plot,
+			URL:         info.PageURL,
+			Start:       info.Aired.Time(),
+			AllDay:      true,
+		})
+	}
+	if info.AvailableUntil.After(now) {
+		c.Add(Event{
+			UID:         uid + "-expires@aspiratv",
+			Summary:     "Expires: " + title,
+			Description: info.Plot,
+			URL:         info.PageURL,
+			Start:       info.AvailableUntil,
+		})
+	}
+}
+
+// Write the calendar in iCalendar format. Events are sorted by date.
+func (c *Calendar) Write(w io.Writer, now time.Time) error {
+	events := append([]Event{}, c.Events...)
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].Start.Equal(events[j].Start) {
+			return events[i].Start.Before(events[j].Start)
+		}
+		return events[i].UID < events[j].UID
+	})
+
+	bw := bufio.NewWriter(w)
+	p := func(name, value string) {
+		writeLine(bw, name+":"+value)
+	}
+	p("BEGIN", "VCALENDAR")
+	p("VERSION", "2.0")
+	p("PRODID", "-//aspiratv//calendar//EN")
+	p("CALSCALE", "GREGORIAN")
+	if c.Name != "" {
+		p("X-WR-CALNAME", escape(c.Name))
+	}
+	stamp := formatTime(now)
+	for _, e := range events {
+		p("BEGIN", "VEVENT")
+		p("UID", escape(e.UID))
+		p("DTSTAMP", stamp)
+		if e.AllDay {
+			p("DTSTART;VALUE=DATE", e.Start.Format("20060102"))
+		} else {
+			p("DTSTART", formatTime(e.Start))
+			if !e.End.IsZero() {
+				p("DTEND", formatTime(e.End))
+			}
+		}
+		p("SUMMARY", escape(e.Summary))
+		if e.Description != "" {
+			p("DESCRIPTION", escape(e.Description))
+		}
+		if e.URL != "" {
+			p("URL", e.URL)
+		}
+		p("TRANSP", "TRANSPARENT")
+		p("END", "VEVENT")
+	}
+	p("END", "VCALENDAR")
+	return bw.Flush()
+}
+
+// formatTime returns the time in UTC
+func formatTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// escape a text value
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// writeLine writes a content line, folded at 75 octets without splitting UTF-8 characters
+func writeLine(w *bufio.Writer, line string) {
+	max := 75
+	for len(line) > max {
+		i := max
+		for i > 0 && !isRuneStart(line[i]) {
+			i--
+		}
+		fmt.Fprintf(w, "%s\r\n ", line[:i])
+		line = line[i:]
+		max = 74 // Continuation lines start with a space
+	}
+	fmt.Fprintf(w, "%s\r\n", line)
+}
+
+// isRuneStart tells if the byte starts an UTF-8 character
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
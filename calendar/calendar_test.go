@@ -0,0 +1,62 @@
+package calendar
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simulot/aspiratv/metadata/nfo"
+)
+
+func TestWrite(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := New("aspiratv")
+	c.AddMedia("artetv", "1", &nfo.MediaInfo{
+		Showtitle:      "Karambolage",
+		Title:          "Le mot, la chose",
+		Plot:           "Un magazine franco-allemand; très court, et drôle. Une description assez longue pour être repliée sur plusieurs lignes.",
+		AvailableFrom:  now.Add(24 * time.Hour),
+		AvailableUntil: now.Add(30 * 24 * time.Hour),
+	}, now)
+	c.AddMedia("francetv", "2", &nfo.MediaInfo{
+		Showtitle: "Les Lapins Crétins",
+		Title:     "Les Lapins Crétins",
+		Aired:     nfo.Aired(now.Add(48 * time.Hour)),
+	}, now)
+	c.AddMedia("francetv", "3", &nfo.MediaInfo{
+		Title:          "Expired",
+		AvailableUntil: now.Add(-time.Hour),
+	}, now)
+
+	b := bytes.Buffer{}
+	err := c.Write(&b, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := b.String()
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"UID:artetv-1-available@aspiratv\r\nDTSTAMP:20210301T120000Z\r\nDTSTART:20210302T120000Z\r\nSUMMARY:New: Karambolage - Le mot\\, la chose\r\n",
+		"UID:francetv-2-aired@aspiratv\r\nDTSTAMP:20210301T120000Z\r\nDTSTART;VALUE=DATE:20210303\r\nSUMMARY:New: Les Lapins Crétins\r\n",
+		"UID:artetv-1-expires@aspiratv\r\nDTSTAMP:20210301T120000Z\r\nDTSTART:20210331T120000Z\r\n",
+		"DESCRIPTION:Un magazine franco-allemand\\; très court\\, et drôle. Une desc\r\n ription",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("Expecting %q in\n%s", want, s)
+		}
+	}
+	if strings.Contains(s, "francetv-3") {
+		t.Errorf("Expired media must not be in the calendar")
+	}
+	for _, l := range strings.Split(s, "\r\n") {
+		if len(l) > 75 {
+			t.Errorf("Line too long: %q", l)
+		}
+	}
+	// Events are sorted by date
+	if strings.Index(s, "artetv-1-available") > strings.Index(s, "francetv-2-aired") || strings.Index(s, "francetv-2-aired") > strings.Index(s, "artetv-1-expires") {
+		t.Errorf("Events aren't sorted by date:\n%s", s)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/simulot/aspiratv/providers"
+	"github.com/simulot/aspiratv/providers/cache"
+)
+
+// openCache opens the cache of providers' pages, kept in the user's cache directory by default.
+// It returns nil when the cache is disabled.
+func (a *app) openCache() (*cache.Store, error) {
+	if a.NoCache {
+		return nil, nil
+	}
+	dir := a.CacheDir
+	if dir == "" {
+		d, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("Can't locate cache directory: %w", err)
+		}
+		dir = filepath.Join(d, "aspiratv", "http")
+	}
+	dir, err := providers.ExpandPath(dir)
+	if err != nil {
+		return nil, err
+	}
+	return cache.Open(dir), nil
+}
+
+// Cache command
+func (a *app) Cache(args []string) {
+	if a.NoCache {
+		a.Exit("The cache is disabled by --no-cache")
+	}
+	store, err := a.openCache()
+	if err != nil {
+		a.logger.Fatal().Printf("[Cache] %s", err)
+	}
+
+	switch a.CacheCommand {
+	case "stats":
+		stats, err := store.Stats()
+		if err != nil {
+			a.logger.Fatal().Printf("[Cache] %s", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "PROVIDER\tPAGES\tFRESH\tSIZE")
+		for _, s := range stats {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", s.Provider, s.Entries, s.Fresh, s.Size)
+		}
+		w.Flush()
+	case "clear":
+		provider := ""
+		if len(args) > 0 {
+			provider = args[0]
+		}
+		n, err := store.Clear(provider)
+		if err != nil {
+			a.logger.Fatal().Printf("[Cache] %s", err)
+		}
+		fmt.Printf("%d pages removed from %s\n", n, store.Dir())
+	default:
+		a.Exit(fmt.Sprintf("Unknown cache command %q", a.CacheCommand))
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/simulot/aspiratv/calendar"
+	"github.com/simulot/aspiratv/matcher"
+	"github.com/simulot/aspiratv/providers"
+	"github.com/simulot/aspiratv/queue"
+)
+
+const calendarName = "aspiratv"
+
+// Calendar command writes the iCalendar feed of upcoming episodes and of replay expirations
+// of watched shows. Providers are queried, and pending medias of the queue are added.
+func (a *app) Calendar(ctx context.Context) {
+	err := a.ReadConfig(a.ConfigFile)
+	if err != nil {
+		a.logger.Fatal().Printf("[Calendar] %s", err)
+	}
+	a.network, err = a.openNetwork()
+	if err != nil {
+		a.logger.Fatal().Printf("[Calendar] %s", err)
+	}
+	defer a.saveCookies()
+	a.cache, err = a.openCache()
+	if err != nil {
+		a.logger.Fatal().Printf("[Calendar] %s", err)
+	}
+	q, err := a.openQueue()
+	if err != nil {
+		a.logger.Fatal().Printf("[Calendar] %s", err)
+	}
+
+	now := time.Now()
+	cal := calendar.New(calendarName)
+	seen := map[string]bool{}
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, p := range providers.List() {
+		if !a.Settings.Providers[p.Name()].Enabled {
+			continue
+		}
+		mrs := []*matcher.MatchRequest{}
+		for _, mr := range a.Settings.WatchList {
+			if mr.Provider == p.Name() && !mr.Disabled {
+				mrs = append(mrs, mr)
+			}
+		}
+		if len(mrs) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(p providers.Provider, mrs []*matcher.MatchRequest) {
+			defer wg.Done()
+			r := a.newRunner(ctx, p)
+			defer r.WaitUntilCompletion(ctx)
+			for m := range r.GetNewMediasList(ctx, mrs) {
+				mu.Lock()
+				seen[p.Name()+"/"+m.ID] = true
+				cal.AddMedia(p.Name(), m.ID, m.Metadata.GetMediaInfo(), now)
+				mu.Unlock()
+			}
+		}(p, mrs)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+	addQueueEvents(cal, q, seen, now)
+
+	var w io.Writer = os.Stdout
+	if a.CalendarFile != "" {
+		path, err := providers.ExpandPath(a.CalendarFile)
+		if err != nil {
+			a.logger.Fatal().Printf("[Calendar] %s", err)
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			a.logger.Fatal().Printf("[Calendar] Can't create calendar file: %s", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	err = cal.Write(w, now)
+	if err != nil {
+		a.logger.Fatal().Printf("[Calendar] Can't write calendar: %s", err)
+	}
+}
+
+// addQueueEvents adds events of pending medias of the queue, except those already seen
+func addQueueEvents(cal *calendar.Calendar, q *queue.Store, seen map[string]bool, now time.Time) {
+	for _, i := range q.Items(queue.StatusPending) {
+		if seen[i.Key()] || i.Info == nil {
+			continue
+		}
+		cal.AddMedia(i.Provider, i.ID, i.Info, now)
+	}
+}
+
+// calendarHandler serves the iCalendar feed of pending medias of the queue, updated at each run:
+//
+//	GET /calendar.ics
+func (a *app) calendarHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.queue == nil {
+		http.Error(w, "No download queue", http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	cal := calendar.New(calendarName)
+	addQueueEvents(cal, a.queue, nil, now)
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	cal.Write(w, now)
+}
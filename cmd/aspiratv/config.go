@@ -1,19 +1,27 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
 	"runtime"
+	"sort"
 	"strings"
 
+	"github.com/simulot/aspiratv/config"
 	"github.com/simulot/aspiratv/matcher"
+	"github.com/simulot/aspiratv/network"
 	"github.com/simulot/aspiratv/providers"
 )
 
 func (a *app) Initialize(cmd string) {
+	if cmd == "config" || cmd == "watch" || cmd == "queue" || cmd == "cache" || cmd == "calendar" || cmd == "library" {
+		// ffmpeg isn't needed to handle the configuration file, the queue, the cache, the calendar and the library
+		return
+	}
 
 	// Check ffmpeg presence
 	var c *exec.Cmd
@@ -33,56 +41,180 @@ func (a *app) Initialize(cmd string) {
 	c = exec.Command(a.ffmpeg, "-version")
 	b, err = c.Output()
 	a.logger.Debug().Printf("[Initialize] FFMPEG version: %q", string(b))
+
+	// ffprobe comes with ffmpeg, downloads are checked only when it is present
+	if !a.NoVerify {
+		_, err = exec.LookPath("ffprobe")
+		if err != nil {
+			a.logger.Error().Printf("[Initialize] Can't find ffprobe, downloaded files won't be checked: %s", err)
+			a.NoVerify = true
+		}
+	}
+}
+
+// ReadConfig read the JSON configuration file
+func (a *app) ReadConfig(configFile string) error {
+	s, err := a.loadSettings(configFile)
+	if err != nil {
+		return err
+	}
+	a.Settings = *s
+	return nil
 }
 
-// ProviderConfig hold configuration for providers in config.json file
-type ProviderConfig struct {
-	Enabled  bool
-	Settings map[string]string
+// loadSettings reads and checks the configuration file, without changing current settings
+func (a *app) loadSettings(configFile string) (*providers.Settings, error) {
+	a.logger.Trace().Printf("[ReadConfig] opening '%s'", configFile)
+	s, err := config.Read(configFile)
+	if err != nil {
+		return nil, err
+	}
+	err = s.CheckPath()
+	if err != nil {
+		return nil, err
+	}
+	for name, ps := range s.Providers {
+		if ps.Proxy == "" {
+			continue
+		}
+		err = network.CheckProxy(ps.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Provider %q: %w", name, err)
+		}
+	}
+	return s, nil
 }
 
-// Almost empty configuration for testing purpose
-var defaultConfig = &providers.Settings{
-	WatchList: []*matcher.MatchRequest{
-		{
-			Provider:    "francetv",
-			Show:        "Les Lapins Crétins",
-			Destination: "Jeunesse",
-		},
-	},
-	Destinations: map[string]string{
-		"Jeunesse": "${HOME}/Videos/Jeunesse",
-	},
+// Config command
+func (a *app) Config(ctx context.Context) {
+	switch a.ConfigCommand {
+	case "validate":
+		a.ConfigValidate()
+	case "show":
+		a.ConfigShow()
+	case "init":
+		a.ConfigInit()
+	default:
+		a.Exit(fmt.Sprintf("Unknown config command %q", a.ConfigCommand))
+	}
 }
 
-// WriteConfig create a JSON file with the current configuration
-func WriteConfig() {
-	f, err := os.Create("config.json")
+// ConfigValidate prints problems found in the configuration file
+func (a *app) ConfigValidate() {
+	problems, err := config.Validate(a.ConfigFile)
 	if err != nil {
-		log.Fatalf("Can't write configuration file: %v", err)
+		a.logger.Fatal().Printf("[ConfigValidate] %s", err)
 	}
-	e := json.NewEncoder(f)
-	e.SetIndent("", "  ")
-	e.Encode(defaultConfig)
-	f.Close()
-	os.Exit(0)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "%d problem(s) found in %q\n", len(problems), a.ConfigFile)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "%q is valid\n", a.ConfigFile)
 }
 
-// ReadConfig read the JSON configuration file
-func (a *app) ReadConfig(configFile string) error {
-	a.logger.Trace().Printf("[ReadConfig] opening '%s'", configFile)
-	f, err := os.Open(configFile)
+// ConfigShow prints the effective configuration: expanded paths and default values
+func (a *app) ConfigShow() {
+	s, err := config.Read(a.ConfigFile)
 	if err != nil {
-		return fmt.Errorf("Can't open configuration file: %v", err)
+		a.logger.Fatal().Printf("[ConfigShow] %s", err)
 	}
-	defer f.Close()
-	err = json.NewDecoder(f).Decode(&a.Settings)
+	e, err := s.Expand()
 	if err != nil {
-		return fmt.Errorf("Can't decode configuration file: %v", err)
+		a.logger.Fatal().Printf("[ConfigShow] %s", err)
 	}
-	err = a.Settings.CheckPath()
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	err = enc.Encode(e)
 	if err != nil {
-		return err
+		a.logger.Fatal().Printf("[ConfigShow] %s", err)
+	}
+}
+
+// ConfigInit writes a new configuration file from flags, or by asking questions
+func (a *app) ConfigInit() {
+	s := &providers.Settings{
+		Providers:    map[string]providers.ProviderSettings{},
+		Destinations: map[string]string{},
+		WatchList:    []*matcher.MatchRequest{},
+	}
+	for name, path := range a.InitDestinations {
+		s.Destinations[name] = path
+	}
+	enabled := map[string]bool{}
+	for _, name := range a.InitProviders {
+		if _, ok := providers.List()[name]; !ok {
+			a.Exit(fmt.Sprintf("Unknown provider %q", name))
+		}
+		enabled[name] = true
+	}
+
+	if a.InitInteractive {
+		a.askConfig(s, enabled)
+	}
+
+	if len(s.Destinations) == 0 {
+		s.Destinations["Jeunesse"] = "${HOME}/Videos/Jeunesse"
+	}
+	names := []string{}
+	for name := range providers.List() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s.Providers[name] = providers.ProviderSettings{
+			Enabled: len(enabled) == 0 || enabled[name],
+		}
+	}
+
+	err := config.Write(a.ConfigFile, s, a.InitForce)
+	if err != nil {
+		a.logger.Fatal().Printf("[ConfigInit] %s", err)
+	}
+	fmt.Fprintf(os.Stderr, "Configuration written in %q\n", a.ConfigFile)
+}
+
+// askConfig asks destinations and providers on the console
+func (a *app) askConfig(s *providers.Settings, enabled map[string]bool) {
+	in := bufio.NewScanner(os.Stdin)
+	ask := func(question string, def string) string {
+		if def != "" {
+			fmt.Printf("%s [%s]: ", question, def)
+		} else {
+			fmt.Printf("%s: ", question)
+		}
+		if !in.Scan() {
+			a.logger.Fatal().Printf("[ConfigInit] No more input")
+		}
+		answer := strings.TrimSpace(in.Text())
+		if answer == "" {
+			return def
+		}
+		return answer
+	}
+
+	fmt.Println("Destinations, leave the name empty to continue")
+	for {
+		name := ask("Destination name", "")
+		if name == "" {
+			break
+		}
+		s.Destinations[name] = ask("Path for "+name, "${HOME}/Videos/"+name)
+	}
+
+	names := []string{}
+	for name := range providers.List() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		def := "y"
+		if len(enabled) > 0 && !enabled[name] {
+			def = "n"
+		}
+		enabled[name] = strings.HasPrefix(strings.ToLower(ask("Enable provider "+name+" (y/n)", def)), "y")
 	}
-	return nil
 }
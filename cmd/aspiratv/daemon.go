@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/simulot/aspiratv/config"
+)
+
+// Interval between checks of configuration files modification
+const configPollInterval = 5 * time.Second
+
+// Daemon runs cycles until the context is cancelled. Between cycles, the configuration
+// is reloaded when its files change, or when SIGHUP is received.
+// An invalid configuration is rejected, and the previous one is kept.
+func (a *app) Daemon(ctx context.Context) {
+	log := a.logger.Subsystem("daemon")
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	files := []string{a.ConfigFile}
+	stamp := configStamp(a.ConfigFile, &files)
+	poll := time.NewTicker(configPollInterval)
+	defer poll.Stop()
+
+	for {
+		log.Info().Printf("Start of cycle")
+		a.RunCycle(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		next := time.Now().Add(a.Every)
+		log.Info().Printf("Next cycle at %s", next.Format("15:04:05"))
+
+		wait := time.NewTimer(a.Every)
+	waiting:
+		for {
+			select {
+			case <-ctx.Done():
+				wait.Stop()
+				return
+			case <-wait.C:
+				break waiting
+			case <-hup:
+				log.Info().Printf("SIGHUP received")
+				stamp = configStamp(a.ConfigFile, &files)
+				a.reloadConfig()
+			case <-poll.C:
+				if s := configStamp(a.ConfigFile, &files); s != stamp {
+					log.Info().Printf("Configuration files have changed")
+					stamp = s
+					a.reloadConfig()
+				}
+			}
+		}
+	}
+}
+
+// reloadConfig replaces current settings when the configuration is valid. The network is opened
+// again with the new proxies, timeouts and certificates. Cookies are saved before, so they are kept.
+func (a *app) reloadConfig() {
+	log := a.logger.Subsystem("daemon")
+	s, err := a.loadSettings(a.ConfigFile)
+	if err != nil {
+		log.Error().Printf("Configuration rejected, the previous one is kept: %s", err)
+		return
+	}
+	previous := a.Settings
+	a.Settings = *s
+	a.saveCookies()
+	n, err := a.openNetwork()
+	if err != nil {
+		a.Settings = previous
+		log.Error().Printf("Configuration rejected, the previous one is kept: %s", err)
+		return
+	}
+	a.network = n
+	log.Info().Printf("Configuration reloaded: %d show(s) in the watch list", len(s.WatchList))
+}
+
+// configStamp summarizes size and modification time of configuration files.
+// The list of files is updated when files can be decoded, otherwise the previous
+// list is watched for a correction.
+func configStamp(path string, files *[]string) string {
+	if f, err := config.Files(path); err == nil {
+		*files = f
+	}
+	b := strings.Builder{}
+	for _, f := range append(*files, filepath.Join(filepath.Dir(path), config.WatchListDir)) {
+		st, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", f, st.Size(), st.ModTime().UnixNano())
+	}
+	return b.String()
+}
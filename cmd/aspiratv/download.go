@@ -31,6 +31,10 @@ func (a *app) Download(ctx context.Context, show string) {
 	}
 	a.Matcher.ShowRootPath = v
 	a.Matcher.Show = strings.ToLower(show)
+	err = a.Matcher.CheckTemplates()
+	if err != nil {
+		a.Exit(err.Error())
+	}
 
 	p, ok := providers.List()[a.Matcher.Provider]
 	if !ok {
@@ -38,12 +42,24 @@ func (a *app) Download(ctx context.Context, show string) {
 		os.Exit(1)
 	}
 
-	if !a.Headless {
-		a.BarContainer = NewBarContainer(ctx)
-		defer func() {
-			a.BarContainer.Done()
-		}()
+	a.network, err = a.openNetwork()
+	if err != nil {
+		a.logger.Fatal().Printf("[Initialize] %s", err)
+	}
+	defer a.saveCookies()
+	a.cache, err = a.openCache()
+	if err != nil {
+		a.logger.Fatal().Printf("[Initialize] %s", err)
+	}
+	// The download command has no configuration file, the history is used only when given
+	if a.HistoryFile != "" {
+		a.history, err = a.openHistory()
+		if err != nil {
+			a.logger.Fatal().Printf("[Initialize] %s", err)
+		}
 	}
+	defer a.startFeedback(ctx)()
+	defer a.pool.Stop(ctx)
 
 	mrs := make([]*matcher.MatchRequest, 0)
 	mrs = append(mrs, &a.Matcher)
@@ -55,7 +71,7 @@ func (a *app) Download(ctx context.Context, show string) {
 			defer func() {
 				wg.Done()
 			}()
-			a.GetMediasOfProvider(ctx, p, 0, mrs)
+			a.GetMediasOfProvider(ctx, p, 0, mrs, nil)
 		}(p, mrs)
 		wg.Wait()
 	}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/simulot/aspiratv/events"
+)
+
+// logEvent writes events into the log
+func (a *app) logEvent(e events.Event) {
+	m := e.Subject()
+	log := a.logger.Subsystem("events").With("provider", m.Provider, "media", m.ID)
+	switch e := e.(type) {
+	case events.MediaDiscovered:
+		log.Info().Printf("New media %q", m.Path)
+	case events.MediaSkipped:
+		log.Trace().Printf("Media %q skipped: %s", m.Path, e.Reason)
+	case events.MediaRenamed:
+		log.Info().Printf("Media %q renamed into %q", e.From, m.Path)
+	case events.DownloadStarted:
+		log.Trace().Printf("Job %d: start downloading %q", e.JobID, m.Path)
+	case events.StageChanged:
+		log.Trace().Printf("%q: %s", m.Path, e.Stage)
+	case events.DownloadFailed:
+		log.Error().Printf("Can't download %q: %s", m.Path, e.Err)
+	case events.DownloadCompleted:
+		log.Info().Printf("File %q downloaded", m.Path)
+	case events.NFOWritten:
+		log.Trace().Printf("Metadata file %q written", e.NFOPath)
+	}
+}
+
+// printEvent gives a minimal feedback on the console in headless mode
+func (a *app) printEvent(e events.Event) {
+	if e, ok := e.(events.DownloadCompleted); ok {
+		fmt.Printf("[%s] File %q downloaded\n", e.Provider, e.Path)
+	}
+}
+
+// startFeedback subscribes the console feedback to the event bus: progression bars, JSON lines,
+// or minimal messages in headless mode.
+// The returned function must be called at the end of the command.
+func (a *app) startFeedback(ctx context.Context) func() {
+	if a.Output == "jsonl" {
+		return a.bus.Subscribe(newJSONLWriter(os.Stdout, a.ProgressEvery).HandleEvent)
+	}
+	if a.Headless {
+		return a.bus.Subscribe(a.printEvent)
+	}
+	a.BarContainer = NewBarContainer(ctx)
+	unsubscribe := a.bus.Subscribe(a.BarContainer.HandleEvent)
+	return func() {
+		unsubscribe()
+		a.BarContainer.Done()
+	}
+}
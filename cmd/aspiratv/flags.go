@@ -5,18 +5,32 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 
+	"github.com/simulot/aspiratv/network"
+	"github.com/simulot/aspiratv/queue"
 	flag "github.com/spf13/pflag"
 )
 
 func (a *app) SetFlags() {
 	a.SetRunFlags()
 	a.SetDownloadFlags()
+	a.SetConfigFlags()
+	a.SetWatchFlags()
+	a.SetQueueFlags()
+	a.SetCacheFlags()
+	a.SetCalendarFlags()
+	a.SetLibraryFlags()
 }
 
 func (a *app) SetRunFlags() {
 	a.fsRun = flag.NewFlagSet("run", flag.ExitOnError)
-	a.fsRun.StringVar(&a.ConfigFile, "config", "config.json", "Configuration file name.")
+	a.fsRun.StringVar(&a.ConfigFile, "config", "config.json", "Configuration file name (.json, .yaml, .yml or .toml).")
+	a.fsRun.StringVar(&a.QueueFile, "queue", "", "File of the download queue. Default: queue.json next to the configuration file.")
+	a.fsRun.StringVar(&a.HistoryFile, "history", "", "File of the download history. Default: history.json next to the configuration file.")
+	a.fsRun.IntVar(&a.MaxAttempts, "max-attempts", queue.DefaultMaxAttempts, "Number of attempts before a failed download is parked in the failed list.")
+	a.fsRun.DurationVar(&a.Every, "every", 0, "Daemon mode: run again after this duration (ex: 2h). The configuration is reloaded when it changes, or on SIGHUP.")
+	a.fsRun.BoolVar(&a.NoVerify, "no-verify", false, "Don't check downloaded files with ffprobe.")
 	a.fsRun.Usage = func() {
 		fmt.Println("Command run: downloawd new shows listed into configuration file in the watchlist")
 		fmt.Println()
@@ -35,17 +49,9 @@ func (a *app) SetRunFlags() {
 
 func (a *app) SetDownloadFlags() {
 	a.fsDownload = flag.NewFlagSet("download", flag.ExitOnError)
-	a.fsDownload.StringVarP(&a.Matcher.Provider, "provider", "p", "", "Provider to be used with download command. Possible values : artetv, francetv, gulli (mandatory).")
-	a.fsDownload.StringVarP(&a.Matcher.ShowRootPath, "show-path", "s", "", "Show's path (mandatory).")
-	a.fsDownload.BoolVar(&a.Matcher.Force, "force", false, "Force media download even when present on the show-path.")
-	// a.fsDownload.StringVarP(&a.Matcher.Destination, "destination", "d", "", "Destination path for all shows.")
-	a.fsDownload.IntVar(&a.Matcher.RetentionDays, "retention", 0, "Delete media older than retention days for the downloaded show.")
-	a.fsDownload.BoolVarP(&a.Matcher.KeepBonus, "keep-bonuses", "b", false, "Download bonuses when true")
-	a.fsDownload.IntVarP(&a.Matcher.MaxAgedDays, "max-aged", "a", 0, "Retrieve media younger than MaxAgedDays.")
-	a.fsDownload.VarP(&a.Matcher.TitleFilter, "title-filter", "f", "Showtitle or Episode title must satisfy regexp filter")
-	a.fsDownload.VarP(&a.Matcher.TitleExclude, "title-exclude", "e", "Showtitle and Episode title must not satisfy regexp filter")
-	a.fsDownload.Var(&a.Matcher.ShowNameTemplate, "name-template", "Show name file template")
-	a.fsDownload.Var(&a.Matcher.SeasonPathTemplate, "season-template", "Season directory template")
+	a.addMatcherFlags(a.fsDownload)
+	a.fsDownload.BoolVar(&a.NoVerify, "no-verify", false, "Don't check downloaded files with ffprobe.")
+	a.fsDownload.StringVar(&a.HistoryFile, "history", "", "File of the download history. Medias found in the history aren't downloaded again.")
 
 	a.fsDownload.Usage = func() {
 		fmt.Println("Command download: download show with given options")
@@ -60,11 +66,165 @@ func (a *app) SetDownloadFlags() {
 	a.addCommonFlags(a.fsDownload)
 }
 
+// addMatcherFlags adds flags describing a show, shared by download and watch commands
+func (a *app) addMatcherFlags(fs *flag.FlagSet) {
+	fs.StringVarP(&a.Matcher.Provider, "provider", "p", "", "Provider to be used with download command. Possible values : artetv, francetv, gulli (mandatory).")
+	fs.StringVarP(&a.Matcher.ShowRootPath, "show-path", "s", "", "Show's path (mandatory).")
+	fs.BoolVar(&a.Matcher.Force, "force", false, "Force media download even when present on the show-path.")
+	fs.IntVar(&a.Matcher.RetentionDays, "retention", 0, "Delete media older than retention days for the downloaded show.")
+	fs.BoolVarP(&a.Matcher.KeepBonus, "keep-bonuses", "b", false, "Download bonuses when true")
+	fs.IntVarP(&a.Matcher.MaxAgedDays, "max-aged", "a", 0, "Retrieve media younger than MaxAgedDays.")
+	fs.VarP(&a.Matcher.TitleFilter, "title-filter", "f", "Showtitle or Episode title must satisfy regexp filter")
+	fs.VarP(&a.Matcher.TitleExclude, "title-exclude", "e", "Showtitle and Episode title must not satisfy regexp filter")
+	fs.Var(&a.Matcher.ShowNameTemplate, "name-template", "Show name file template, with functions slug, lower, upper, ascii, truncate, pad, date, default, replace and provider")
+	fs.Var(&a.Matcher.SeasonPathTemplate, "season-template", "Season directory template, with the same functions as --name-template")
+}
+
+func (a *app) SetWatchFlags() {
+	a.fsWatch = flag.NewFlagSet("watch", flag.ExitOnError)
+	a.fsWatch.StringVar(&a.ConfigFile, "config", "config.json", "Configuration file name (.json, .yaml, .yml or .toml).")
+	a.addMatcherFlags(a.fsWatch)
+	a.fsWatch.StringVarP(&a.Matcher.Destination, "destination", "d", "", "Destination code defined in the configuration file, used when --show-path is missing.")
+	a.fsWatch.Usage = func() {
+		fmt.Println("Command watch: manage the watch list of the configuration file")
+		fmt.Println()
+		fmt.Println(filepath.Base(os.Args[0]), " watch list [ options... ]                     list watched shows")
+		fmt.Println(filepath.Base(os.Args[0]), " watch add [ options... ] \"show name\"         add a show to the watch list")
+		fmt.Println(filepath.Base(os.Args[0]), " watch remove [ options... ] \"show name\"      remove a show from the watch list")
+		fmt.Println(filepath.Base(os.Args[0]), " watch enable [ options... ] \"show name\"      pull again a disabled show")
+		fmt.Println(filepath.Base(os.Args[0]), " watch disable [ options... ] \"show name\"     keep the show in the watch list without pulling it")
+		fmt.Println(filepath.Base(os.Args[0]), " watch test [ options... ] \"show name\"        list medias that would be downloaded for the show")
+		fmt.Println()
+		fmt.Println("  example:  ", filepath.Base(os.Args[0]), "watch add", "--provider francetv --destination Jeunesse \"lapins crétins\"")
+		fmt.Println()
+		fmt.Println("  options:")
+		a.fsWatch.PrintDefaults()
+	}
+	a.addCommonFlags(a.fsWatch)
+}
+
+func (a *app) SetQueueFlags() {
+	a.fsQueue = flag.NewFlagSet("queue", flag.ExitOnError)
+	a.fsQueue.StringVar(&a.ConfigFile, "config", "config.json", "Configuration file name (.json, .yaml, .yml or .toml).")
+	a.fsQueue.StringVar(&a.QueueFile, "queue", "", "File of the download queue. Default: queue.json next to the configuration file.")
+	a.fsQueue.Usage = func() {
+		fmt.Println("Command queue: show medias waiting for a download, and failed ones")
+		fmt.Println()
+		fmt.Println(filepath.Base(os.Args[0]), " queue list [ options... ]                     list pending and failed medias")
+		fmt.Println(filepath.Base(os.Args[0]), " queue failed [ options... ]                   list failed medias")
+		fmt.Println(filepath.Base(os.Args[0]), " queue retry [ options... ] PROVIDER ID        retry a failed media at next run")
+		fmt.Println(filepath.Base(os.Args[0]), " queue remove [ options... ] PROVIDER ID       remove a media from the queue")
+		fmt.Println()
+		fmt.Println("  example:  ", filepath.Base(os.Args[0]), "queue retry", "francetv 3c3f6a4e-7e0b-4d45-9c1a-4fd1e1a2a0b2")
+		fmt.Println()
+		fmt.Println("  options:")
+		a.fsQueue.PrintDefaults()
+	}
+	a.addCommonFlags(a.fsQueue)
+}
+
+func (a *app) SetCacheFlags() {
+	a.fsCache = flag.NewFlagSet("cache", flag.ExitOnError)
+	a.fsCache.Usage = func() {
+		fmt.Println("Command cache: show or clear the cache of providers' pages")
+		fmt.Println()
+		fmt.Println(filepath.Base(os.Args[0]), " cache stats [ options... ]               number and size of cached pages per provider")
+		fmt.Println(filepath.Base(os.Args[0]), " cache clear [ options... ] [PROVIDER]    remove cached pages, of all providers when PROVIDER is missing")
+		fmt.Println()
+		fmt.Println("  example:  ", filepath.Base(os.Args[0]), "cache clear", "gulli")
+		fmt.Println()
+		fmt.Println("  options:")
+		a.fsCache.PrintDefaults()
+	}
+	a.addCommonFlags(a.fsCache)
+}
+
+func (a *app) SetCalendarFlags() {
+	a.fsCalendar = flag.NewFlagSet("calendar", flag.ExitOnError)
+	a.fsCalendar.StringVar(&a.ConfigFile, "config", "config.json", "Configuration file name (.json, .yaml, .yml or .toml).")
+	a.fsCalendar.StringVar(&a.QueueFile, "queue", "", "File of the download queue. Default: queue.json next to the configuration file.")
+	a.fsCalendar.StringVar(&a.CalendarFile, "ics", "", "iCalendar file to write. Default: standard output.")
+	a.fsCalendar.Usage = func() {
+		fmt.Println("Command calendar: write the iCalendar feed of upcoming episodes and replay expirations of watched shows")
+		fmt.Println()
+		fmt.Println(filepath.Base(os.Args[0]), " calendar [ options... ]")
+		fmt.Println()
+		fmt.Println("  example:  ", filepath.Base(os.Args[0]), "calendar", "--ics ~/www/aspiratv.ics")
+		fmt.Println()
+		fmt.Println("  options:")
+		a.fsCalendar.PrintDefaults()
+	}
+	a.addCommonFlags(a.fsCalendar)
+}
+
+func (a *app) SetLibraryFlags() {
+	a.fsLibrary = flag.NewFlagSet("library", flag.ExitOnError)
+	a.fsLibrary.StringVar(&a.ConfigFile, "config", "config.json", "Configuration file name (.json, .yaml, .yml or .toml).")
+	a.fsLibrary.StringVar(&a.FeedDir, "feed-dir", "", "Directory where feeds are written.")
+	a.fsLibrary.StringVar(&a.FeedFormat, "feed-format", "rss", "Format of feeds: rss or atom.")
+	a.fsLibrary.StringVar(&a.BaseURL, "base-url", "", "URL of the library server used in feeds. Default: file:// URLs, or the server's address.")
+	a.fsLibrary.StringVar(&a.LibraryAddr, "addr", ":8080", "Address of the library server.")
+	a.fsLibrary.BoolVar(&a.LibraryFix, "fix", false, "Fix problems found by library check.")
+	a.fsLibrary.BoolVar(&a.LibraryOverwrite, "overwrite", false, "Replace existing metadata files and thumbnails with library refresh-metadata.")
+	a.fsLibrary.StringVar(&a.HistoryFile, "history", "", "File of the download history used by library import and refresh-metadata. Default: history.json next to the configuration file.")
+	a.fsLibrary.Usage = func() {
+		fmt.Println("Command library: use downloaded medias")
+		fmt.Println()
+		fmt.Println(filepath.Base(os.Args[0]), " library feed [ options... ]      write a RSS or Atom feed per destination and per show")
+		fmt.Println(filepath.Base(os.Args[0]), " library serve [ options... ]     serve feeds and medias over HTTP")
+		fmt.Println(filepath.Base(os.Args[0]), " library check [ options... ]     report orphan, broken and leftover files, fix them with --fix")
+		fmt.Println(filepath.Base(os.Args[0]), " library import [ options... ] PATH   add medias of an existing library to the download history")
+		fmt.Println(filepath.Base(os.Args[0]), " library refresh-metadata [ options... ]   write again metadata files and thumbnails of medias in the history")
+		fmt.Println()
+		fmt.Println("  example:  ", filepath.Base(os.Args[0]), "library serve", "--addr :8080")
+		fmt.Println()
+		fmt.Println("  options:")
+		a.fsLibrary.PrintDefaults()
+	}
+	a.addCommonFlags(a.fsLibrary)
+}
+
+func (a *app) SetConfigFlags() {
+	a.fsConfig = flag.NewFlagSet("config", flag.ExitOnError)
+	a.fsConfig.StringVar(&a.ConfigFile, "config", "config.json", "Configuration file name (.json, .yaml, .yml or .toml).")
+	a.fsConfig.StringToStringVarP(&a.InitDestinations, "destination", "d", nil, "init: destination NAME=PATH, can be repeated.")
+	a.fsConfig.StringSliceVarP(&a.InitProviders, "provider", "p", nil, "init: provider to be enabled, can be repeated. All providers are enabled when missing.")
+	a.fsConfig.BoolVar(&a.InitForce, "force", false, "init: overwrite the existing configuration file.")
+	a.fsConfig.BoolVarP(&a.InitInteractive, "interactive", "i", false, "init: ask destinations and providers.")
+	a.fsConfig.Usage = func() {
+		fmt.Println("Command config: check, display or create the configuration file")
+		fmt.Println()
+		fmt.Println(filepath.Base(os.Args[0]), " config validate [ options... ]   check the configuration file")
+		fmt.Println(filepath.Base(os.Args[0]), " config show [ options... ]       print the effective configuration")
+		fmt.Println(filepath.Base(os.Args[0]), " config init [ options... ]       create a configuration file")
+		fmt.Println()
+		fmt.Println("  example:  ", filepath.Base(os.Args[0]), "config init", "--destination Jeunesse=${HOME}/Videos/Jeunesse --provider francetv")
+		fmt.Println()
+		fmt.Println("  options:")
+		a.fsConfig.PrintDefaults()
+	}
+	a.addCommonFlags(a.fsConfig)
+}
+
 func (a *app) addCommonFlags(fs *flag.FlagSet) {
-	fs.StringVarP(&a.LogLevel, "log-level", "l", "ERROR", "Log level (INFO,TRACE,ERROR,DEBUG)")
+	fs.StringVarP(&a.LogLevel, "log-level", "l", "ERROR", "Log level (INFO,TRACE,ERROR,DEBUG), optionally followed by levels per subsystem (ex: ERROR,dash=DEBUG)")
 	fs.BoolVar(&a.Headless, "headless", false, "Headless mode. Progression bars are not displayed.")
+	fs.StringVar(&a.Output, "output", "text", "Output format (text,jsonl). jsonl writes one JSON object per event on stdout and implies headless mode.")
+	fs.DurationVar(&a.ProgressEvery, "progress-interval", 10*time.Second, "Interval between progress events in jsonl output.")
 	fs.IntVarP(&a.ConcurrentTasks, "max-tasks", "m", runtime.NumCPU(), "Maximum concurrent downloads at a time.")
 	fs.StringVar(&a.LogFile, "log", "", "Give the log file name.")
+	fs.StringVar(&a.LogFormat, "log-format", "text", "Log format (text,json).")
+	fs.IntVar(&a.LogMaxSize, "log-max-size", 0, "Rotate the log file when its size exceeds this number of MB. 0 to disable.")
+	fs.DurationVar(&a.LogMaxAge, "log-max-age", 0, "Rotate the log file when it is older than this duration (ex: 24h). 0 to disable.")
+	fs.IntVar(&a.LogMaxBackups, "log-max-backups", 5, "Number of rotated log files to keep.")
+	fs.StringVar(&a.MetricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address (ex: :9100) at /metrics.")
+	fs.StringVar(&a.RecordFixtures, "record-fixtures", "", "Record HTTP exchanges with providers into this directory, for offline tests.")
+	fs.StringVar(&a.Network.Proxy, "proxy", "", "HTTP or SOCKS5 proxy (ex: socks5://localhost:1080), overriding the configuration.")
+	fs.IntVar(&a.Network.Timeout, "http-timeout", 0, fmt.Sprintf("Seconds allowed to a query to providers. Default: %d.", network.DefaultTimeout))
+	fs.StringVar(&a.Network.CookieFile, "cookie-file", "", "File where cookies are kept between runs.")
+	fs.StringVar(&a.Network.CAFile, "ca-file", "", "PEM file of certificate authorities to trust.")
+	fs.StringVar(&a.CacheDir, "cache-dir", "", "Directory of the cache of providers' pages. Default: aspiratv in the user's cache directory.")
+	fs.BoolVar(&a.NoCache, "no-cache", false, "Don't use the cache of providers' pages.")
 	fs.BoolVar(&a.WaitDebugger, "debugger", false, "Wait for debugger")
 	fs.MarkHidden("debugger")
 }
@@ -73,5 +233,17 @@ func (a *app) Usage() {
 	fmt.Printf("Usage of %s:\n\n", os.Args[0])
 	a.fsRun.Usage()
 	a.fsDownload.Usage()
+	fmt.Println()
+	a.fsConfig.Usage()
+	fmt.Println()
+	a.fsWatch.Usage()
+	fmt.Println()
+	a.fsQueue.Usage()
+	fmt.Println()
+	a.fsCache.Usage()
+	fmt.Println()
+	a.fsCalendar.Usage()
+	fmt.Println()
+	a.fsLibrary.Usage()
 	os.Exit(1)
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/simulot/aspiratv/workers"
+)
+
+// jobInfo is the JSON representation of a job
+type jobInfo struct {
+	ID       int        `json:"id"`
+	Name     string     `json:"name"`
+	Provider string     `json:"provider"`
+	Priority int        `json:"priority"`
+	Deadline *time.Time `json:"deadline,omitempty"`
+	Status   string     `json:"status"`
+}
+
+func newJobInfo(j *workers.Job) jobInfo {
+	ji := jobInfo{
+		ID:       j.ID,
+		Name:     j.Name,
+		Provider: j.Group,
+		Priority: j.Priority,
+		Status:   j.Status().String(),
+	}
+	if !j.Deadline.IsZero() {
+		ji.Deadline = &j.Deadline
+	}
+	return ji
+}
+
+// jobsHandler serves the job list and job controls:
+//
+//	GET  /jobs              lists running and waiting jobs
+//	GET  /jobs/<id>         gives the job's status
+//	POST /jobs/<id>/cancel  cancels the job
+//	POST /jobs/<id>/pause   pauses the job
+//	POST /jobs/<id>/resume  resumes the job
+func (a *app) jobsHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/"), "/")
+	if parts[0] == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		list := []jobInfo{}
+		for _, j := range a.pool.Jobs() {
+			list = append(list, newJobInfo(j))
+		}
+		writeJSON(w, list)
+		return
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+	j := a.pool.Job(id)
+	if j == nil {
+		http.Error(w, fmt.Sprintf("Job %d not found", id), http.StatusNotFound)
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, newJobInfo(j))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch parts[1] {
+	case "cancel":
+		j.Cancel()
+	case "pause":
+		if err := j.Pause(); err != nil {
+			http.Error(w, fmt.Sprintf("Job %d: %s", id, err), http.StatusConflict)
+			return
+		}
+	case "resume":
+		j.Resume()
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	a.logger.Info().Printf("Job %d (%s): %s requested", j.ID, j.Name, parts[1])
+	writeJSON(w, newJobInfo(j))
+}
+
+// workersHandler gives the number of workers on GET, and changes it on POST /workers?size=n
+func (a *app) workersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		size, err := strconv.Atoi(r.URL.Query().Get("size"))
+		if err != nil || size < 1 {
+			http.Error(w, "size must be a positive number", http.StatusBadRequest)
+			return
+		}
+		a.pool.Resize(size)
+		a.logger.Info().Printf("Number of workers set to %d", size)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, struct {
+		Size int `json:"size"`
+	}{a.pool.Size()})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	e.Encode(v)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/simulot/aspiratv/events"
+	"github.com/simulot/aspiratv/providers"
+)
+
+// jsonlRecord is the JSON object written for each event
+type jsonlRecord struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Provider string    `json:"provider"`
+	ID       string    `json:"id"`
+	Show     string    `json:"show,omitempty"`
+	Title    string    `json:"title,omitempty"`
+	Path     string    `json:"path,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	From     string    `json:"from,omitempty"`
+	JobID    int       `json:"job,omitempty"`
+	Stage    string    `json:"stage,omitempty"`
+	Current  int64     `json:"current,omitempty"`
+	Total    int64     `json:"total,omitempty"`
+	NFOPath  string    `json:"nfo,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	ErrKind  string    `json:"error_kind,omitempty"`
+}
+
+// jsonlWriter writes one JSON object per event. Progress events are written at given interval for each media.
+type jsonlWriter struct {
+	sync.Mutex
+	enc          *json.Encoder
+	interval     time.Duration
+	lastProgress map[string]time.Time
+}
+
+func newJSONLWriter(w io.Writer, interval time.Duration) *jsonlWriter {
+	return &jsonlWriter{
+		enc:          json.NewEncoder(w),
+		interval:     interval,
+		lastProgress: map[string]time.Time{},
+	}
+}
+
+// HandleEvent is the event bus handler
+func (w *jsonlWriter) HandleEvent(e events.Event) {
+	w.Lock()
+	defer w.Unlock()
+
+	m := e.Subject()
+	k := m.Provider + "/" + m.ID
+	now := time.Now()
+	r := jsonlRecord{
+		Time:     now,
+		Provider: m.Provider,
+		ID:       m.ID,
+		Show:     m.Show,
+		Title:    m.Title,
+		Path:     m.Path,
+	}
+
+	switch e := e.(type) {
+	case events.MediaDiscovered:
+		r.Event = "discovered"
+	case events.MediaSkipped:
+		r.Event = "skipped"
+		r.Reason = e.Reason
+	case events.MediaRenamed:
+		r.Event = "renamed"
+		r.From = e.From
+	case events.DownloadStarted:
+		r.Event = "started"
+		r.JobID = e.JobID
+	case events.StageChanged:
+		r.Event = "stage"
+		r.Stage = e.Stage
+	case events.Progress:
+		if now.Sub(w.lastProgress[k]) < w.interval {
+			return
+		}
+		w.lastProgress[k] = now
+		r.Event = "progress"
+		r.Current = e.Current
+		r.Total = e.Total
+	case events.NFOWritten:
+		r.Event = "nfo"
+		r.NFOPath = e.NFOPath
+	case events.DownloadFailed:
+		r.Event = "failed"
+		if e.Err != nil {
+			r.Error = e.Err.Error()
+			r.ErrKind = providers.ErrorKind(e.Err)
+		}
+		delete(w.lastProgress, k)
+	case events.DownloadCompleted:
+		r.Event = "finished"
+		delete(w.lastProgress, k)
+	default:
+		return
+	}
+	w.enc.Encode(r)
+}
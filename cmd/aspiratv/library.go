@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/simulot/aspiratv/download"
+	"github.com/simulot/aspiratv/history"
+	"github.com/simulot/aspiratv/library"
+	"github.com/simulot/aspiratv/metadata/nfo"
+	"github.com/simulot/aspiratv/providers"
+)
+
+// libraryRoots returns destinations, and paths of watched shows outside destinations
+func (a *app) libraryRoots() []library.Root {
+	roots := []library.Root{}
+	for name, p := range a.Settings.Destinations {
+		roots = append(roots, library.Root{Name: name, Path: p})
+	}
+	for _, mr := range a.Settings.WatchList {
+		if mr.ShowRootPath == "" {
+			continue
+		}
+		inside := false
+		for _, r := range roots {
+			if rel, err := filepath.Rel(r.Path, mr.ShowRootPath); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			roots = append(roots, library.Root{Name: mr.Show, Path: mr.ShowRootPath, Show: true})
+		}
+	}
+	return roots
+}
+
+// Library command
+func (a *app) Library(ctx context.Context, args []string) {
+	err := a.ReadConfig(a.ConfigFile)
+	if err != nil {
+		a.logger.Fatal().Printf("[Library] %s", err)
+	}
+
+	switch a.LibraryCommand {
+	case "feed":
+		err = a.LibraryFeed()
+	case "serve":
+		err = a.LibraryServe(ctx)
+	case "check":
+		err = a.LibraryCheck(ctx)
+	case "import":
+		err = a.LibraryImport(args)
+	case "refresh-metadata":
+		err = a.LibraryRefreshMetadata(ctx)
+	default:
+		a.Exit(fmt.Sprintf("Unknown library command %q", a.LibraryCommand))
+	}
+	if err != nil {
+		a.logger.Fatal().Printf("[Library] %s", err)
+	}
+}
+
+// LibraryFeed writes a feed per destination and per show into the feed directory
+func (a *app) LibraryFeed() error {
+	if a.FeedDir == "" {
+		a.Exit("Missing --feed-dir")
+	}
+	ext := "." + a.FeedFormat
+	if ext != ".rss" && ext != ".atom" {
+		a.Exit(fmt.Sprintf("Unknown feed format %q", a.FeedFormat))
+	}
+	roots := a.libraryRoots()
+	url := library.FileURL
+	if a.BaseURL != "" {
+		url = library.NewServer(roots, a.BaseURL).URL
+	}
+	items, err := library.Scan(roots)
+	if err != nil {
+		return err
+	}
+	feeds := append(library.RootFeeds(items), library.ShowFeeds(items)...)
+	for _, f := range feeds {
+		p := filepath.Join(a.FeedDir, filepath.FromSlash(f.ID)+ext)
+		err = writeFeed(p, f, ext, url)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%d feeds written in %s\n", len(feeds), a.FeedDir)
+	return nil
+}
+
+func writeFeed(p string, f *library.Feed, ext string, url library.URLFunc) error {
+	err := os.MkdirAll(filepath.Dir(p), 0777)
+	if err != nil {
+		return fmt.Errorf("Can't write feed: %w", err)
+	}
+	w, err := os.Create(p)
+	if err != nil {
+		return fmt.Errorf("Can't write feed: %w", err)
+	}
+	if ext == ".atom" {
+		err = f.WriteAtom(w, url)
+	} else {
+		err = f.WriteRSS(w, url)
+	}
+	if err1 := w.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return fmt.Errorf("Can't write feed %s: %w", p, err)
+	}
+	return nil
+}
+
+// LibraryServe serves feeds and files of the library until the context is cancelled
+func (a *app) LibraryServe(ctx context.Context) error {
+	baseURL := a.BaseURL
+	if baseURL == "" {
+		host := a.LibraryAddr
+		if strings.HasPrefix(host, ":") {
+			h, err := os.Hostname()
+			if err != nil {
+				h = "localhost"
+			}
+			host = h + host
+		}
+		baseURL = "http://" + host
+	}
+	srv := &http.Server{
+		Addr:    a.LibraryAddr,
+		Handler: library.NewServer(a.libraryRoots(), baseURL),
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	a.logger.Info().Printf("Serving library feeds on %s/feeds/", baseURL)
+	err := srv.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("Can't serve library: %w", err)
+	}
+	return nil
+}
+
+// LibraryCheck reports problems of the library, and fixes them with --fix
+func (a *app) LibraryCheck(ctx context.Context) error {
+	opts := []library.CheckOption{}
+	if _, err := exec.LookPath("ffprobe"); err == nil {
+		opts = append(opts, library.WithProbe(probeVideo))
+	} else {
+		a.logger.Error().Printf("[Library] Can't find ffprobe, videos won't be probed: %s", err)
+	}
+	problems, err := library.Check(ctx, a.libraryRoots(), opts...)
+	if err != nil {
+		return err
+	}
+	fixed := 0
+	for _, p := range problems {
+		if a.LibraryFix && p.Fixable() {
+			err = p.Fix()
+			if err != nil {
+				a.logger.Error().Printf("[Library] %s", err)
+				fmt.Printf("%s (not fixed)\n", p)
+				continue
+			}
+			fixed++
+			fmt.Printf("%s (fixed)\n", p)
+			continue
+		}
+		fmt.Println(p)
+	}
+	fmt.Printf("%d problems found, %d fixed\n", len(problems), fixed)
+	return nil
+}
+
+// probeVideo returns an error when the video can't be read, or has no stream
+func probeVideo(ctx context.Context, path string) error {
+	p, err := download.ProbeFile(ctx, path)
+	if err != nil {
+		return err
+	}
+	if p.Video == 0 && p.Audio == 0 {
+		return fmt.Errorf("no audio or video stream")
+	}
+	return nil
+}
+
+// LibraryImport adds medias of an existing library to the download history, so they aren't downloaded again.
+// Medias are identified by their metadata file, or by their file name when it follows a name template.
+func (a *app) LibraryImport(args []string) error {
+	if len(args) != 1 {
+		a.Exit("Missing the path of the library to import")
+	}
+	path, err := providers.ExpandPath(args[0])
+	if err != nil {
+		return err
+	}
+	h, err := a.openHistory()
+	if err != nil {
+		return err
+	}
+	items, err := library.Scan([]library.Root{{Name: filepath.Base(path), Path: path}})
+	if err != nil {
+		return err
+	}
+	matchers := a.nameMatchers()
+	entries := []history.Entry{}
+	unknown := 0
+	for _, i := range items {
+		info := i.Info
+		if i.NFOPath == "" {
+			info = parseMediaName(matchers, filepath.Base(i.Path))
+			if info == nil {
+				a.logger.Info().Printf("[Library] Can't identify %s", i.Path)
+				unknown++
+				continue
+			}
+		}
+		entries = append(entries, history.NewEntry(info, i.Path, history.SourceImport))
+	}
+	n, err := h.Add(entries...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d medias imported into %s, %d already known, %d not identified\n", n, h.Path(), len(entries)-n, unknown)
+	return nil
+}
+
+// nameMatchers returns matchers of file names given by name templates of the watch list, then by default templates
+func (a *app) nameMatchers() []*regexp.Regexp {
+	matchers := []*regexp.Regexp{}
+	seen := map[string]bool{}
+	add := func(re *regexp.Regexp, err error) {
+		if err != nil {
+			a.logger.Error().Printf("[Library] %s", err)
+			return
+		}
+		if !seen[re.String()] {
+			seen[re.String()] = true
+			matchers = append(matchers, re)
+		}
+	}
+	for _, mr := range a.Settings.WatchList {
+		if mr.ShowNameTemplate.T != nil {
+			add(download.MediaNameMatcher(mr, mr.Type))
+		}
+	}
+	for _, t := range []nfo.ShowType{nfo.TypeSeries, nfo.TypeShow, nfo.TypeMovie} {
+		add(download.MediaNameMatcher(nil, t))
+	}
+	return matchers
+}
+
+// parseMediaName returns metadata found in the file name by the first matching template, nil when none matches
+func parseMediaName(matchers []*regexp.Regexp, name string) *nfo.MediaInfo {
+	for _, re := range matchers {
+		if info := download.ParseMediaName(re, name); info != nil {
+			return info
+		}
+	}
+	return nil
+}
+
+// LibraryRefreshMetadata pulls providers for shows of the history, and writes again metadata files
+// and thumbnails of medias found in the history. Existing files are replaced with --overwrite.
+func (a *app) LibraryRefreshMetadata(ctx context.Context) error {
+	var err error
+	a.network, err = a.openNetwork()
+	if err != nil {
+		return err
+	}
+	defer a.saveCookies()
+	a.cache, err = a.openCache()
+	if err != nil {
+		return err
+	}
+	a.history, err = a.openHistory()
+	if err != nil {
+		return err
+	}
+	// Shows of the history are looked up on every enabled provider, watched or not
+	_, requests := a.watchedProviders()
+	refreshed := 0
+	for _, p := range providers.List() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !a.Settings.Providers[p.Name()].Enabled {
+			continue
+		}
+		refreshed += a.newRunner(ctx, p).RefreshMetadata(ctx, requests[p.Name()], a.LibraryOverwrite)
+	}
+	fmt.Printf("Metadata of %d medias refreshed\n", refreshed)
+	return nil
+}
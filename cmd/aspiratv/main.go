@@ -4,15 +4,23 @@ import (
 	"context"
 
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"time"
 
 	flag "github.com/spf13/pflag"
 
+	"github.com/simulot/aspiratv/events"
+	"github.com/simulot/aspiratv/history"
 	"github.com/simulot/aspiratv/mylog"
+	"github.com/simulot/aspiratv/network"
 	"github.com/simulot/aspiratv/providers"
+	"github.com/simulot/aspiratv/providers/cache"
+	"github.com/simulot/aspiratv/queue"
+	"github.com/simulot/aspiratv/workers"
 
 	"github.com/simulot/aspiratv/matcher"
 	_ "github.com/simulot/aspiratv/providers/artetv"
@@ -32,11 +40,45 @@ type app struct {
 	Matcher         matcher.MatchRequest // Matcher for the command line
 	ConfigFile      string               // Name of configuration file
 	Headless        bool                 // When true, no progression bar
+	Output          string               // Output format: text or jsonl
+	Every           time.Duration        // Interval between runs in daemon mode, 0 for a single run
+	QueueFile       string               // File of the download queue, next to the configuration file when empty
+	HistoryFile     string               // File of the download history, next to the configuration file when empty
+	MaxAttempts     int                  // Number of attempts before a media is parked in the failed list
+	ProgressEvery   time.Duration        // Interval between progress events in jsonl output
+	MetricsAddr     string               // Address of the metrics endpoint, empty to disable it
+	RecordFixtures  string               // Directory where providers' HTTP exchanges are recorded, empty to disable it
+	Network         network.Config       // Network flags, overriding the configuration
+	CacheDir        string               // Directory of the HTTP cache, in the user's cache directory when empty
+	NoCache         bool                 // Disable the HTTP cache
+	NoVerify        bool                 // Don't probe downloaded files
 	ConcurrentTasks int                  // Number of concurrent downloads
 	LogLevel        string               // ERROR,WARN,INFO,TRACE,DEBUG
 	LogFile         string               // Log file
+	LogFormat       string               // text or json
+	LogMaxSize      int                  // Rotate the log file when bigger than LogMaxSize MB
+	LogMaxAge       time.Duration        // Rotate the log file when older than LogMaxAge
+	LogMaxBackups   int                  // Number of rotated log files to keep
 	WaitDebugger    bool                 // When true, the PID is displayed, and wait for ENTER key
 
+	// config and watch commands
+	ConfigCommand    string            // validate, show or init
+	WatchCommand     string            // list, add, remove, enable, disable or test
+	QueueCommand     string            // list, failed, retry or remove
+	CacheCommand     string            // stats or clear
+	CalendarFile     string            // iCalendar file written by the calendar command, stdout when empty
+	LibraryCommand   string            // feed, serve, check, import or refresh-metadata
+	FeedDir          string            // Directory of feeds written by library feed
+	FeedFormat       string            // rss or atom
+	BaseURL          string            // URL of the library server, used in feeds
+	LibraryAddr      string            // Address of the library server
+	LibraryFix       bool              // Fix problems found by library check
+	LibraryOverwrite bool              // Replace existing metadata files and thumbnails by library refresh-metadata
+	InitDestinations map[string]string // Destinations given to config init
+	InitProviders    []string          // Providers enabled by config init, all when empty
+	InitForce        bool              // Overwrite an existing configuration file
+	InitInteractive  bool              // Ask destinations and providers on the console
+
 	// State
 	Stop   chan bool
 	ffmpeg string
@@ -44,8 +86,20 @@ type app struct {
 	// worker     *workers.WorkerPool
 	// getter     getter
 	logger     *mylog.MyLog
+	bus        *events.Bus      // Events published by runners
+	pool       *workers.Worker  // Download slots shared by all providers
+	queue      *queue.Store     // Medias waiting for a download
+	history    *history.Store   // Medias already downloaded
+	network    *network.Network // Transports and cookies shared by providers and downloads
+	cache      *cache.Store     // Providers' pages, nil when the cache is disabled
 	fsRun      *flag.FlagSet
 	fsDownload *flag.FlagSet
+	fsConfig   *flag.FlagSet
+	fsWatch    *flag.FlagSet
+	fsQueue    *flag.FlagSet
+	fsCache    *flag.FlagSet
+	fsCalendar *flag.FlagSet
+	fsLibrary  *flag.FlagSet
 
 	// Progression bars
 	BarContainer *barContainer
@@ -59,13 +113,20 @@ type logger interface {
 	Printf(string, ...interface{})
 }
 
+// logWriter is implemented by log files
+type logWriter interface {
+	io.Writer
+	Sync() error
+	Close() error
+}
+
 func main() {
 	var err error
 	var command string
 
-	fmt.Printf("%s: %v, commit %v, built at %v\n", filepath.Base(os.Args[0]), version, commit, date)
 	a := &app{
 		Stop: make(chan bool),
+		bus:  events.NewBus(),
 	}
 
 	// trap Ctrl+C and call cancel on the context
@@ -82,6 +143,44 @@ func main() {
 	case len(os.Args) > 1 && os.Args[1] == "run":
 		command = "run"
 		err = a.fsRun.Parse(os.Args[2:])
+	case len(os.Args) > 1 && os.Args[1] == "config":
+		command = "config"
+		if len(os.Args) < 3 {
+			a.Exit("Missing config command")
+		}
+		a.ConfigCommand = os.Args[2]
+		err = a.fsConfig.Parse(os.Args[3:])
+	case len(os.Args) > 1 && os.Args[1] == "watch":
+		command = "watch"
+		if len(os.Args) < 3 {
+			a.Exit("Missing watch command")
+		}
+		a.WatchCommand = os.Args[2]
+		err = a.fsWatch.Parse(os.Args[3:])
+	case len(os.Args) > 1 && os.Args[1] == "queue":
+		command = "queue"
+		if len(os.Args) < 3 {
+			a.Exit("Missing queue command")
+		}
+		a.QueueCommand = os.Args[2]
+		err = a.fsQueue.Parse(os.Args[3:])
+	case len(os.Args) > 1 && os.Args[1] == "cache":
+		command = "cache"
+		if len(os.Args) < 3 {
+			a.Exit("Missing cache command")
+		}
+		a.CacheCommand = os.Args[2]
+		err = a.fsCache.Parse(os.Args[3:])
+	case len(os.Args) > 1 && os.Args[1] == "calendar":
+		command = "calendar"
+		err = a.fsCalendar.Parse(os.Args[2:])
+	case len(os.Args) > 1 && os.Args[1] == "library":
+		command = "library"
+		if len(os.Args) < 3 {
+			a.Exit("Missing library command")
+		}
+		a.LibraryCommand = os.Args[2]
+		err = a.fsLibrary.Parse(os.Args[3:])
 	case len(os.Args) > 1 && os.Args[1] == "help":
 		command = "help"
 		err = a.fsRun.Parse(os.Args[2:])
@@ -95,16 +194,36 @@ func main() {
 		a.Usage()
 	}
 
+	// In JSON-lines mode, stdout is reserved to events
+	console := os.Stdout
+	if command == "config" || command == "watch" || command == "queue" || command == "cache" || command == "calendar" || command == "library" {
+		// Keep stdout for the configuration
+		console = os.Stderr
+	}
+	switch a.Output {
+	case "text":
+	case "jsonl":
+		a.Headless = true
+		console = os.Stderr
+	default:
+		a.Exit(fmt.Sprintf("Unknown output format %q", a.Output))
+	}
+	fmt.Fprintf(console, "%s: %v, commit %v, built at %v\n", filepath.Base(os.Args[0]), version, commit, date)
+
 	if a.WaitDebugger {
 		fmt.Println("PID:", os.Getpid())
 		fmt.Print("Press Enter to continue ")
 		fmt.Scanln()
 	}
 
-	logFile := os.Stdout
+	var logFile logWriter = console
 	if len(a.LogFile) > 0 {
 		var err error
-		logFile, err = os.Create(a.LogFile)
+		if a.LogMaxSize > 0 || a.LogMaxAge > 0 {
+			logFile, err = mylog.NewRotatingFile(a.LogFile, int64(a.LogMaxSize)*1024*1024, a.LogMaxAge, a.LogMaxBackups)
+		} else {
+			logFile, err = os.Create(a.LogFile)
+		}
 		if err != nil {
 			log.Printf("Can't create log file: %q", err)
 			os.Exit(1)
@@ -115,13 +234,23 @@ func main() {
 		}()
 	}
 
-	mylogger, err := mylog.NewLog(a.LogLevel, log.New(logFile, "", log.LstdFlags))
+	logOptions := []mylog.Option{}
+	switch a.LogFormat {
+	case "text":
+	case "json":
+		logOptions = append(logOptions, mylog.WithJSON(logFile))
+	default:
+		a.Exit(fmt.Sprintf("Unknown log format %q", a.LogFormat))
+	}
+
+	mylogger, err := mylog.NewLog(a.LogLevel, log.New(logFile, "", log.LstdFlags), logOptions...)
 
 	if err != nil {
 		log.Println(err)
 		os.Exit(1)
 	}
 	a.logger = mylogger
+	a.bus.Subscribe(a.logEvent)
 	a.logger.Info().Printf("Command line paramters: %v", os.Args[1:])
 	a.logger.Debug().Printf("Process PID: %d", os.Getpid())
 
@@ -144,6 +273,13 @@ func main() {
 
 	a.Initialize(command)
 
+	// Download slots are shared by all providers
+	a.pool = workers.New(ctx, a.ConcurrentTasks, a.logger)
+
+	if a.MetricsAddr != "" {
+		a.startMetricsServer(ctx)
+	}
+
 	switch command {
 	case "download":
 		if len(a.fsDownload.Args()) > 0 {
@@ -153,6 +289,18 @@ func main() {
 		}
 	case "run":
 		a.Run(ctx)
+	case "config":
+		a.Config(ctx)
+	case "watch":
+		a.Watch(ctx, a.fsWatch.Args())
+	case "queue":
+		a.Queue(a.fsQueue.Args())
+	case "cache":
+		a.Cache(a.fsCache.Args())
+	case "calendar":
+		a.Calendar(ctx)
+	case "library":
+		a.Library(ctx, a.fsLibrary.Args())
 	case "help":
 		a.Usage()
 	}
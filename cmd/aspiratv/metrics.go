@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/simulot/aspiratv/events"
+	"github.com/simulot/aspiratv/metrics"
+)
+
+var (
+	mediaDiscovered = metrics.NewCounter("aspiratv_media_discovered_total", "Number of new medias discovered.", "provider")
+	mediaSkipped    = metrics.NewCounter("aspiratv_media_skipped_total", "Number of medias skipped.", "provider")
+	mediaDownloaded = metrics.NewCounter("aspiratv_media_downloaded_total", "Number of medias successfully downloaded.", "provider")
+	mediaFailed     = metrics.NewCounter("aspiratv_media_failed_total", "Number of failed downloads.", "provider")
+	bytesDownloaded = metrics.NewCounter("aspiratv_downloaded_bytes_total", "Number of bytes downloaded.", "provider")
+)
+
+// metricsCollector updates counters from events
+type metricsCollector struct {
+	sync.Mutex
+	lastSize map[string]int64 // Last known size of medias being downloaded
+}
+
+func newMetricsCollector() *metricsCollector {
+	return &metricsCollector{
+		lastSize: map[string]int64{},
+	}
+}
+
+// HandleEvent is the event bus handler
+func (c *metricsCollector) HandleEvent(e events.Event) {
+	m := e.Subject()
+	k := m.Provider + "/" + m.ID
+	switch e := e.(type) {
+	case events.MediaDiscovered:
+		mediaDiscovered.Inc(m.Provider)
+	case events.MediaSkipped:
+		mediaSkipped.Inc(m.Provider)
+	case events.Progress:
+		c.Lock()
+		bytesDownloaded.Add(float64(e.Current-c.lastSize[k]), m.Provider)
+		c.lastSize[k] = e.Current
+		c.Unlock()
+	case events.DownloadCompleted:
+		mediaDownloaded.Inc(m.Provider)
+		c.Lock()
+		delete(c.lastSize, k)
+		c.Unlock()
+	case events.DownloadFailed:
+		mediaFailed.Inc(m.Provider)
+		c.Lock()
+		delete(c.lastSize, k)
+		c.Unlock()
+	}
+}
+
+// startMetricsServer serves the /metrics and the job control endpoints until the context is cancelled
+func (a *app) startMetricsServer(ctx context.Context) {
+	a.bus.Subscribe(newMetricsCollector().HandleEvent)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/jobs", a.jobsHandler)
+	mux.HandleFunc("/jobs/", a.jobsHandler)
+	mux.HandleFunc("/workers", a.workersHandler)
+	mux.HandleFunc("/calendar.ics", a.calendarHandler)
+	srv := &http.Server{
+		Addr:    a.MetricsAddr,
+		Handler: mux,
+	}
+
+	go func() {
+		a.logger.Subsystem("metrics").Info().Printf("Serving metrics on %q", a.MetricsAddr)
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			a.logger.Subsystem("metrics").Error().Printf("Can't serve metrics: %s", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/simulot/aspiratv/network"
+	"github.com/simulot/aspiratv/providers"
+)
+
+// openNetwork creates the network from the configuration, overridden by flags.
+// Proxies of providers are checked.
+func (a *app) openNetwork() (*network.Network, error) {
+	c := a.Settings.Network
+	if a.Network.Proxy != "" {
+		c.Proxy = a.Network.Proxy
+	}
+	if a.Network.Timeout != 0 {
+		c.Timeout = a.Network.Timeout
+	}
+	if a.Network.CookieFile != "" {
+		c.CookieFile = a.Network.CookieFile
+	}
+	if a.Network.CAFile != "" {
+		c.CAFile = a.Network.CAFile
+	}
+	var err error
+	for _, p := range []*string{&c.CookieFile, &c.CAFile} {
+		if *p != "" {
+			*p, err = providers.ExpandPath(*p)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	n, err := network.New(c)
+	if err != nil {
+		return nil, err
+	}
+	for _, ps := range a.Settings.Providers {
+		_, err = n.Transport(ps.Proxy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// saveCookies writes the cookie file
+func (a *app) saveCookies() {
+	err := a.network.Jar().Save()
+	if err != nil {
+		a.logger.Error().Printf("%s", err)
+	}
+}
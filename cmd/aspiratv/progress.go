@@ -2,30 +2,21 @@ package main
 
 import (
 	"context"
+	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/simulot/aspiratv/events"
 	"github.com/vbauerster/mpb/v6"
 	"github.com/vbauerster/mpb/v6/decor"
 )
 
-// ProgressBarNoop is a no-op progress bar for headless mod
-type ProgressBarNoop struct {
-}
-
-// Stage set the name of the bar
-func (ProgressBarNoop) Stage(string) {}
-
-// Total set the total of the task
-func (ProgressBarNoop) Total(int) {}
-
-// Update set the curent value of the task
-func (ProgressBarNoop) Update(int) {}
-
-// Done to terminate
-func (ProgressBarNoop) Done() {}
-
 type barContainer struct {
 	*mpb.Progress
+	sync.Mutex
+	providerBars map[string]*bar         // Bars per provider
+	downloadBars map[string]*downloadBar // Bars per downloaded media
+	nextID       int
 }
 
 // NewBarContainer create a mpb containter for other bars
@@ -35,6 +26,8 @@ func NewBarContainer(ctx context.Context) *barContainer {
 			ctx,
 			mpb.WithWidth(64),
 		),
+		providerBars: map[string]*bar{},
+		downloadBars: map[string]*downloadBar{},
 	}
 	return p
 }
@@ -43,15 +36,51 @@ func (c *barContainer) Done() {
 	c.Wait()
 }
 
+// HandleEvent updates bars according to events published by runners
+func (c *barContainer) HandleEvent(e events.Event) {
+	c.Lock()
+	defer c.Unlock()
+
+	m := e.Subject()
+	k := m.Provider + "/" + m.ID
+	switch e := e.(type) {
+	case events.MediaDiscovered:
+		if b, ok := c.providerBars[m.Provider]; ok {
+			b.total++
+			b.SetTotal(int64(b.total), false)
+		}
+	case events.DownloadStarted:
+		c.nextID++
+		c.downloadBars[k] = c.newDownloadBar(filepath.Base(m.Path), c.nextID)
+	case events.Progress:
+		if b, ok := c.downloadBars[k]; ok {
+			b.update(e.Current, e.Total)
+		}
+	case events.DownloadCompleted, events.DownloadFailed:
+		if b, ok := c.downloadBars[k]; ok {
+			b.Abort(true)
+			delete(c.downloadBars, k)
+		}
+		if b, ok := c.providerBars[m.Provider]; ok {
+			b.done++
+			b.SetCurrent(int64(b.done))
+		}
+	}
+}
+
 type bar struct {
 	*mpb.Bar
-	name string
+	name  string
+	total int // Number of discovered medias
+	done  int // Number of processed medias
 }
 
 // NewProviderBar create a bar for following downloaded items per provider
-func (b barContainer) NewProviderBar(name string, id int) *bar {
+func (c *barContainer) NewProviderBar(name string, id int) *bar {
+	c.Lock()
+	defer c.Unlock()
 	newB := &bar{
-		Bar: b.AddBar(0,
+		Bar: c.AddBar(0,
 			mpb.BarWidth(12),
 			mpb.AppendDecorators(
 				decor.OnComplete(decor.Counters(0, "  %3d/%3d"), "completed"),
@@ -61,32 +90,26 @@ func (b barContainer) NewProviderBar(name string, id int) *bar {
 		name: name,
 	}
 	newB.SetPriority(id)
+	c.providerBars[name] = newB
 	return newB
 }
 
-func (b *bar) Stage(s string) {}
-func (b *bar) Total(total int) {
-	b.SetTotal(int64(total), false)
-}
-func (b *bar) Update(current int) {
-	b.SetCurrent(int64(current))
-}
+// Done terminates the bar when the provider is done
 func (b *bar) Done() {
 	b.Abort(true)
-
 }
 
 type downloadBar struct {
 	*mpb.Bar
 	name      string
 	startTime time.Time
-	lastSize  int
+	lastSize  int64
 }
 
-// NewDownloadBar create a progression bar attached to the container
-func (b barContainer) NewDownloadBar(name string, id int) *downloadBar {
+// newDownloadBar create a progression bar attached to the container
+func (c *barContainer) newDownloadBar(name string, id int) *downloadBar {
 	newB := &downloadBar{
-		Bar: b.AddBar(100*1024*1024*1024,
+		Bar: c.AddBar(100*1024*1024*1024,
 			mpb.BarWidth(12),
 			mpb.AppendDecorators(
 				decor.AverageSpeed(decor.UnitKB, " %.1f", decor.WC{W: 15, C: decor.DidentRight}),
@@ -101,17 +124,9 @@ func (b barContainer) NewDownloadBar(name string, id int) *downloadBar {
 	return newB
 }
 
-func (b *downloadBar) Stage(s string) {}
-func (b *downloadBar) Total(total int) {
-	b.SetTotal(int64(total), false)
-}
-func (b *downloadBar) Update(current int) {
-
-	b.IncrBy(current - b.lastSize)
+func (b *downloadBar) update(current, total int64) {
+	b.SetTotal(total, false)
+	b.IncrBy(int(current - b.lastSize))
 	b.DecoratorEwmaUpdate(time.Since(b.startTime))
 	b.lastSize = current
 }
-func (b *downloadBar) Done() {
-	b.Abort(true)
-
-}
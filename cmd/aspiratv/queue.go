@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/simulot/aspiratv/events"
+	"github.com/simulot/aspiratv/history"
+	"github.com/simulot/aspiratv/providers"
+	"github.com/simulot/aspiratv/queue"
+)
+
+// openQueue opens the download queue, stored next to the configuration file by default
+func (a *app) openQueue() (*queue.Store, error) {
+	path := a.QueueFile
+	if path == "" {
+		path = filepath.Join(filepath.Dir(a.ConfigFile), "queue.json")
+	}
+	return queue.Open(path)
+}
+
+// openHistory opens the download history, stored next to the configuration file by default
+func (a *app) openHistory() (*history.Store, error) {
+	path := a.HistoryFile
+	if path == "" {
+		path = filepath.Join(filepath.Dir(a.ConfigFile), "history.json")
+	}
+	return history.Open(path)
+}
+
+// queueEvent keeps the queue up to date with download results.
+// Cancelled downloads stay pending without counting an attempt. Medias that are missing,
+// geo-blocked or expired are parked in the failed list at once.
+func (a *app) queueEvent(e events.Event) {
+	m := e.Subject()
+	var err error
+	switch e := e.(type) {
+	case events.DownloadCompleted:
+		err = a.queue.Done(m.Provider, m.ID)
+	case events.DownloadFailed:
+		if errors.Is(e.Err, context.Canceled) {
+			return
+		}
+		maxAttempts := a.MaxAttempts
+		if providers.Permanent(e.Err) {
+			maxAttempts = 1
+		}
+		err = a.queue.Failed(m.Provider, m.ID, e.Err, maxAttempts)
+	}
+	if err != nil {
+		a.logger.Error().Printf("[Queue] %s", err)
+	}
+}
+
+// Queue command
+func (a *app) Queue(args []string) {
+	q, err := a.openQueue()
+	if err != nil {
+		a.logger.Fatal().Printf("[Queue] %s", err)
+	}
+
+	switch a.QueueCommand {
+	case "list":
+		a.QueueList(q, "")
+		return
+	case "failed":
+		a.QueueList(q, queue.StatusFailed)
+		return
+	case "retry", "remove":
+	default:
+		a.Exit(fmt.Sprintf("Unknown queue command %q", a.QueueCommand))
+	}
+
+	if len(args) < 2 {
+		a.Exit("Missing provider and media ID")
+	}
+	if a.QueueCommand == "retry" {
+		err = q.Retry(args[0], args[1])
+	} else {
+		err = q.Remove(args[0], args[1])
+	}
+	if err != nil {
+		a.logger.Fatal().Printf("[Queue] %s", err)
+	}
+}
+
+// QueueList prints medias of the queue having the status, all when status is empty
+func (a *app) QueueList(q *queue.Store, status queue.Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PROVIDER\tID\tSHOW\tTITLE\tSTATUS\tATTEMPTS\tAVAILABLE UNTIL\tLAST ERROR")
+	for _, i := range q.Items(status) {
+		until := ""
+		if !i.Info.AvailableUntil.IsZero() {
+			until = i.Info.AvailableUntil.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", i.Provider, i.ID, i.Info.Showtitle, i.Info.Title, i.Status, i.Attempts, until, i.LastError)
+	}
+	w.Flush()
+}
@@ -2,14 +2,16 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"path/filepath"
 	"sync"
-	"sync/atomic"
+	"time"
 
-	"github.com/simulot/aspiratv/download"
 	"github.com/simulot/aspiratv/matcher"
+	"github.com/simulot/aspiratv/media"
 	"github.com/simulot/aspiratv/providers"
+	"github.com/simulot/aspiratv/providers/cache"
+	"github.com/simulot/aspiratv/providers/fixtures"
+	"github.com/simulot/aspiratv/queue"
 	"golang.org/x/time/rate"
 )
 
@@ -20,54 +22,95 @@ func (a *app) Run(ctx context.Context) {
 	defer func() {
 		a.logger.Trace().Printf("[RUN] Exit RUN command")
 	}()
-	if !a.Headless {
-		a.BarContainer = NewBarContainer(ctx)
-		defer func() {
-			a.BarContainer.Done()
-		}()
-	}
+	defer a.startFeedback(ctx)()
 
 	err := a.ReadConfig(a.ConfigFile)
 	if err != nil {
 		a.logger.Fatal().Printf("[Initialize] %s", err)
 	}
+	a.network, err = a.openNetwork()
+	if err != nil {
+		a.logger.Fatal().Printf("[Initialize] %s", err)
+	}
+	defer a.saveCookies()
+	a.cache, err = a.openCache()
+	if err != nil {
+		a.logger.Fatal().Printf("[Initialize] %s", err)
+	}
+	a.queue, err = a.openQueue()
+	if err != nil {
+		a.logger.Fatal().Printf("[Initialize] %s", err)
+	}
+	a.history, err = a.openHistory()
+	if err != nil {
+		a.logger.Fatal().Printf("[Initialize] %s", err)
+	}
+	defer a.bus.Subscribe(a.queueEvent)()
+	defer a.pool.Stop(ctx)
+	if a.Every > 0 {
+		a.Daemon(ctx)
+		return
+	}
+	a.RunCycle(ctx)
+}
+
+// RunCycle pulls all enabled providers for shows of the watch list, and downloads new medias
+func (a *app) RunCycle(ctx context.Context) {
+	for name, ps := range a.Settings.Providers {
+		a.pool.SetGroupLimit(name, ps.MaxTasks)
+	}
+	pulled, requests := a.watchedProviders()
+
+	// Medias available on several providers are downloaded once
+	var dedup *providers.Dedup
+	if len(pulled) > 1 {
+		dedup = providers.NewDedup(len(pulled))
+	}
 	wg := sync.WaitGroup{}
-	idx := 0
+	for idx, p := range pulled {
+		wg.Add(1)
+		go func(p providers.Provider, mrs []*matcher.MatchRequest, idx int) {
+			defer func() {
+				wg.Done()
+			}()
+			a.GetMediasOfProvider(ctx, p, idx, mrs, dedup)
+		}(p, requests[p.Name()], idx)
+	}
+	wg.Wait()
+	a.saveCookies()
+}
+
+// watchedProviders returns enabled providers having shows in the watch list, and their enabled watch list entries
+func (a *app) watchedProviders() ([]providers.Provider, map[string][]*matcher.MatchRequest) {
+	pulled := []providers.Provider{}
+	requests := map[string][]*matcher.MatchRequest{}
 	for _, p := range providers.List() {
 		if !a.Settings.Providers[p.Name()].Enabled {
 			continue
 		}
 		mrs := []*matcher.MatchRequest{}
 		for _, mr := range a.Settings.WatchList {
-			if mr.Provider == p.Name() {
+			if mr.Provider == p.Name() && !mr.Disabled {
 				mrs = append(mrs, mr)
 			}
 		}
 		if len(mrs) > 0 {
-			wg.Add(1)
-			go func(p providers.Provider, mrs []*matcher.MatchRequest, idx int) {
-				defer func() {
-					wg.Done()
-				}()
-				a.GetMediasOfProvider(ctx, p, idx, mrs)
-			}(p, mrs, idx)
-			idx++
+			pulled = append(pulled, p)
+			requests[p.Name()] = mrs
 		}
 	}
-	wg.Wait()
+	return pulled, requests
 }
 
-type nextID int64
-
-func (n *nextID) Next() int { return int(atomic.AddInt64((*int64)(n), 1)) }
-
-func (a *app) GetMediasOfProvider(ctx context.Context, p providers.Provider, idx int, mrs []*matcher.MatchRequest) {
+// GetMediasOfProvider pulls the provider and downloads new medias. When dedup isn't nil, new medias
+// are downloaded once all providers sharing the stage have been pulled, and only when not found
+// on a preferred provider.
+func (a *app) GetMediasOfProvider(ctx context.Context, p providers.Provider, idx int, mrs []*matcher.MatchRequest, dedup *providers.Dedup) {
 	a.logger.Trace().Printf("[RUN] Start GetMediasOfProvider(%s)", p.Name())
 	defer func() {
 		a.logger.Trace().Printf("[RUN] Exit GetMediasOfProvider(%s)", p.Name())
 	}()
 	var pBar *bar
-	next := nextID(idx * 10000)
 
 	if !a.Headless {
 		pBar = a.BarContainer.NewProviderBar(p.Name(), idx)
@@ -76,51 +119,151 @@ func (a *app) GetMediasOfProvider(ctx context.Context, p providers.Provider, idx
 		}()
 	}
 
-	hitsPerSecond := a.Settings.Providers[p.Name()].HitsRate
-	if hitsPerSecond == 0 {
-		hitsPerSecond = 5
-	}
-	mediaCount := 0
-	mediaDone := 0
-	p.Configure(
-		providers.ProviderLog(a.logger),
-		providers.ProviderHitsPerSecond(rate.NewLimiter(rate.Limit(hitsPerSecond), 2*hitsPerSecond)),
-	)
-
-	r := providers.NewRunner(ctx, &a.Settings, p,
-		providers.RunnerWithLogger(a.logger),
-		providers.RunnerWithConcurentLimit(a.ConcurrentTasks),
-	)
+	r := a.newRunner(ctx, p)
 	defer func() {
 		a.logger.Trace().Printf("[RUN] GetMediasOfProvider(%s): WaitUntilCompletion", p.Name())
 		r.WaitUntilCompletion(ctx)
 		a.logger.Trace().Printf("[RUN] GetMediasOfProvider(%s): completed", p.Name())
 	}()
 
+	// Medias left by previous runs are downloaded first. The download command has no queue.
+	var pending []*media.Media
+	if a.queue != nil {
+		pending = a.submitPending(ctx, r, p.Name(), dedup)
+		a.parkFailed(r, p.Name())
+	}
+
 	for m := range r.GetNewMediasList(ctx, mrs) {
 		select {
 		case <-ctx.Done():
 			a.logger.Trace().Printf("[RUN] GetMediasOfProvider(%s): Cancellation received", p.Name())
 			return
 		default:
-			mediaCount++
-			if !a.Headless {
-				pBar.Total(mediaCount)
+			if dedup != nil {
+				dedup.Add(r, m)
+				continue
 			}
-			m := m
-			mediaPath, _ := download.MediaPath(m.ShowRootPath, m.Match, m.Metadata.GetMediaInfo())
-			dlBar := a.BarContainer.NewDownloadBar(filepath.Base(mediaPath), next.Next())
-			r.SubmitDownload(ctx, m, dlBar, func() {
-
-				mediaDone++
-				if !a.Headless {
-					pBar.Update(mediaDone)
-					dlBar.Done()
-				} else {
-					fmt.Printf("[%s] File %q downloaded\n", p.Name(), mediaPath)
-
+			a.submitDownload(ctx, r, p.Name(), m)
+		}
+	}
+	// Medias downloaded under another name are renamed once the discovery is done
+	r.RenameMedias()
+	if dedup != nil {
+		kept := dedup.Resolve(ctx, r)
+		if ctx.Err() != nil {
+			return
+		}
+		for _, m := range kept {
+			a.submitDownload(ctx, r, p.Name(), m)
+		}
+		// Pending medias downloaded from another provider leave the queue
+		for _, m := range pending {
+			if !containsMedia(kept, m) {
+				err := a.queue.Remove(p.Name(), m.ID)
+				if err != nil {
+					a.logger.Error().Printf("[RUN] %s", err)
 				}
-			})
+			}
+		}
+	}
+}
+
+func containsMedia(l []*media.Media, m *media.Media) bool {
+	for _, o := range l {
+		if o == m {
+			return true
+		}
+	}
+	return false
+}
+
+// submitDownload queues the media, and submits it to the runner
+func (a *app) submitDownload(ctx context.Context, r *providers.Runner, provider string, m *media.Media) {
+	if a.queue != nil {
+		err := a.queue.Add(provider, m)
+		if err != nil {
+			a.logger.Error().Printf("[RUN] %s", err)
+		}
+	}
+	r.SubmitDownload(ctx, m)
+}
+
+// submitPending submits pending medias of the provider's queue. When dedup isn't nil, they are added
+// to the stage like newly discovered medias, and returned.
+func (a *app) submitPending(ctx context.Context, r *providers.Runner, provider string, dedup *providers.Dedup) []*media.Media {
+	added := []*media.Media{}
+	for _, i := range a.queue.Items(queue.StatusPending) {
+		if i.Provider != provider {
+			continue
+		}
+		m, err := i.Media()
+		if err != nil {
+			a.logger.Error().Printf("[RUN] %s", err)
+			continue
+		}
+		var ok bool
+		if dedup != nil {
+			ok = r.AddPending(m)
+			if ok {
+				dedup.Add(r, m)
+				added = append(added, m)
+			}
+		} else {
+			ok = r.SubmitPending(ctx, m)
+		}
+		if !ok {
+			err = a.queue.Done(i.Provider, i.ID)
+			if err != nil {
+				a.logger.Error().Printf("[RUN] %s", err)
+			}
 		}
 	}
+	return added
+}
+
+// parkFailed makes the runner skip medias of the provider's failed list, until they are retried
+func (a *app) parkFailed(r *providers.Runner, provider string) {
+	for _, i := range a.queue.Items(queue.StatusFailed) {
+		if i.Provider == provider {
+			r.Park(i.ID)
+		}
+	}
+}
+
+// newRunner configures the provider and returns its runner
+func (a *app) newRunner(ctx context.Context, p providers.Provider) *providers.Runner {
+	ps := a.Settings.Providers[p.Name()]
+	hitsPerSecond := ps.HitsRate
+	if hitsPerSecond == 0 {
+		hitsPerSecond = providers.DefaultHitsRate
+	}
+	// Proxies are checked when the network is opened
+	client, _ := a.network.Client(ps.Proxy)
+	transport := client.Transport
+	if a.RecordFixtures != "" {
+		transport = fixtures.NewRecorder(filepath.Join(a.RecordFixtures, p.Name()), transport)
+	}
+	// Recorded fixtures must hold real answers, so the cache isn't used while recording
+	// Only catalog and search pages are cached, players and tokens are always queried
+	if cp, ok := p.(providers.CatalogProvider); ok && a.cache != nil && ps.CacheTTL >= 0 && a.RecordFixtures == "" {
+		transport = cache.NewTransport(a.cache, p.Name(), transport, cache.WithCatalog(cp.IsCatalogQuery), cache.WithTTL(time.Duration(ps.CacheTTL)*time.Second))
+	}
+	p.Configure(
+		providers.ProviderName(p.Name()),
+		providers.ProviderLog(a.logger),
+		providers.ProviderHitsPerSecond(rate.NewLimiter(rate.Limit(hitsPerSecond), 2*hitsPerSecond)),
+		providers.ProviderTransport(transport),
+		providers.ProviderCookieJar(client.Jar),
+		providers.ProviderTimeout(a.network.Config().TimeoutDuration()),
+	)
+
+	return providers.NewRunner(ctx, &a.Settings, p,
+		providers.RunnerWithLogger(a.logger),
+		providers.RunnerWithEvents(a.bus),
+		providers.RunnerWithPool(a.pool),
+		providers.RunnerWithClient(client),
+		providers.RunnerWithConcurentLimit(a.ConcurrentTasks),
+		providers.RunnerWithVerification(!a.NoVerify),
+		providers.RunnerWithHistory(a.history),
+	)
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/simulot/aspiratv/config"
+	"github.com/simulot/aspiratv/download"
+	"github.com/simulot/aspiratv/events"
+	"github.com/simulot/aspiratv/matcher"
+	"github.com/simulot/aspiratv/providers"
+)
+
+// Watch command
+func (a *app) Watch(ctx context.Context, args []string) {
+	show := ""
+	if a.WatchCommand != "list" {
+		if len(args) == 0 {
+			a.Exit("Missing show name")
+		}
+		show = args[0]
+	}
+
+	var err error
+	switch a.WatchCommand {
+	case "list":
+		a.WatchList()
+	case "add":
+		a.Matcher.Show = show
+		err = config.AddWatch(a.ConfigFile, &a.Matcher)
+	case "remove":
+		err = config.RemoveWatch(a.ConfigFile, a.Matcher.Provider, show)
+	case "enable":
+		err = config.EnableWatch(a.ConfigFile, a.Matcher.Provider, show, true)
+	case "disable":
+		err = config.EnableWatch(a.ConfigFile, a.Matcher.Provider, show, false)
+	case "test":
+		a.WatchTest(ctx, show)
+	default:
+		a.Exit(fmt.Sprintf("Unknown watch command %q", a.WatchCommand))
+	}
+	if err != nil {
+		a.logger.Fatal().Printf("[Watch] %s", err)
+	}
+}
+
+// WatchList prints the watch list
+func (a *app) WatchList() {
+	entries, err := config.WatchList(a.ConfigFile)
+	if err != nil {
+		a.logger.Fatal().Printf("[WatchList] %s", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PROVIDER\tSHOW\tDESTINATION\tSTATUS\tFILE")
+	for _, e := range entries {
+		dest := e.Destination
+		if e.ShowRootPath != "" {
+			dest = e.ShowRootPath
+		}
+		status := "enabled"
+		if e.Disabled {
+			status = "disabled"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Provider, e.Show, dest, status, e.File)
+	}
+	w.Flush()
+}
+
+// WatchTest runs the discovery for one entry of the watch list, and prints medias
+// that would be downloaded
+func (a *app) WatchTest(ctx context.Context, show string) {
+	err := a.ReadConfig(a.ConfigFile)
+	if err != nil {
+		a.logger.Fatal().Printf("[WatchTest] %s", err)
+	}
+	var mr *matcher.MatchRequest
+	for _, m := range a.Settings.WatchList {
+		if strings.EqualFold(m.Show, show) && (a.Matcher.Provider == "" || m.Provider == a.Matcher.Provider) {
+			if mr != nil {
+				a.logger.Fatal().Printf("[WatchTest] Show %q is watched on several providers, give the provider", show)
+			}
+			mr = m
+		}
+	}
+	if mr == nil {
+		a.logger.Fatal().Printf("[WatchTest] Show %q isn't in the watch list", show)
+	}
+	a.network, err = a.openNetwork()
+	if err != nil {
+		a.logger.Fatal().Printf("[WatchTest] %s", err)
+	}
+	defer a.saveCookies()
+
+	unsubscribe := a.bus.Subscribe(func(e events.Event) {
+		if e, ok := e.(events.MediaSkipped); ok {
+			fmt.Printf("skipped  %s (%s)\n", e.Path, e.Reason)
+		}
+	})
+	defer unsubscribe()
+
+	p := providers.List()[mr.Provider]
+	r := a.newRunner(ctx, p)
+	defer r.WaitUntilCompletion(ctx)
+	n := 0
+	for m := range r.GetNewMediasList(ctx, []*matcher.MatchRequest{mr}) {
+		info := m.Metadata.GetMediaInfo()
+		mediaPath, _ := download.MediaPath(m.ShowRootPath, m.Match, info)
+		if info.NotYetAvailable(time.Now()) {
+			fmt.Printf("upcoming %s (available from %s)\n", mediaPath, info.AvailableFrom.Local().Format("2006-01-02 15:04"))
+		} else {
+			fmt.Printf("new      %s\n", mediaPath)
+		}
+		n++
+	}
+	fmt.Printf("%d new media(s) for %q on %s\n", n, mr.Show, mr.Provider)
+}
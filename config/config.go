@@ -0,0 +1,148 @@
+// Package config reads, checks and writes aspiratv configuration files.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/simulot/aspiratv/network"
+	"github.com/simulot/aspiratv/providers"
+)
+
+// Read decodes the configuration file, the files it includes and the files of the
+// watchlist.d directory, then applies environment overrides. Paths are left untouched.
+// The format of each file is given by its extension: .json, .yaml, .yml or .toml.
+// Decoding errors of JSON files give the line where the error occurs.
+//
+// Destinations, providers and network settings defined in the main file take precedence over included ones.
+// Watch lists are concatenated.
+func Read(path string) (*providers.Settings, error) {
+	s, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+	err = applyEnv(s, os.Environ())
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// read merges configuration files without environment overrides
+func read(path string) (*providers.Settings, error) {
+	docs, err := loadDocuments(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &providers.Settings{
+		Providers:    map[string]providers.ProviderSettings{},
+		Destinations: map[string]string{},
+	}
+	for _, d := range docs {
+		ds, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range ds.Destinations {
+			if _, ok := s.Destinations[k]; !ok {
+				s.Destinations[k] = v
+			}
+		}
+		for k, v := range ds.Providers {
+			if _, ok := s.Providers[k]; !ok {
+				s.Providers[k] = v
+			}
+		}
+		s.WatchList = append(s.WatchList, ds.WatchList...)
+		mergeNetwork(&s.Network, ds.Network)
+	}
+	return s, nil
+}
+
+// mergeNetwork sets network settings that aren't already set
+func mergeNetwork(n *network.Config, from network.Config) {
+	if n.Proxy == "" {
+		n.Proxy = from.Proxy
+	}
+	if n.Timeout == 0 {
+		n.Timeout = from.Timeout
+	}
+	if n.ConnectTimeout == 0 {
+		n.ConnectTimeout = from.ConnectTimeout
+	}
+	if n.CAFile == "" {
+		n.CAFile = from.CAFile
+	}
+	if n.CookieFile == "" {
+		n.CookieFile = from.CookieFile
+	}
+}
+
+// decode the document's settings
+func (d document) decode() (*providers.Settings, error) {
+	s := &providers.Settings{}
+	var err error
+	if isObject(d.b) {
+		err = json.Unmarshal(d.b, s)
+	} else {
+		err = json.Unmarshal(d.b, &s.WatchList)
+	}
+	if err != nil {
+		if line := errorLine(d.b, err); line > 0 && d.isJSON {
+			return nil, fmt.Errorf("Can't decode configuration file %s:%d: %w", d.path, line, err)
+		}
+		return nil, fmt.Errorf("Can't decode configuration file %s: %w", d.path, err)
+	}
+	return s, nil
+}
+
+// Write encodes settings as indented JSON. An existing file is overwritten only when force is true.
+func Write(path string, s *providers.Settings, force bool) error {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if !force {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("Configuration file %q already exists", path)
+		}
+		return fmt.Errorf("Can't write configuration file: %w", err)
+	}
+	e := json.NewEncoder(f)
+	e.SetIndent("", "  ")
+	e.SetEscapeHTML(false)
+	err = e.Encode(s)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("Can't write configuration file: %w", err)
+	}
+	return f.Close()
+}
+
+// errorLine returns the line of a JSON decoding error, 0 when unknown
+func errorLine(b []byte, err error) int {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return lineOf(b, int(syntaxErr.Offset))
+	case errors.As(err, &typeErr):
+		return lineOf(b, int(typeErr.Offset))
+	}
+	return 0
+}
+
+// lineOf returns the line number of the byte at the given offset
+func lineOf(b []byte, offset int) int {
+	if offset > len(b) {
+		offset = len(b)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return bytes.Count(b[:offset], []byte("\n")) + 1
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/simulot/aspiratv/matcher"
+
+	_ "github.com/simulot/aspiratv/providers/francetv"
+	_ "github.com/simulot/aspiratv/providers/gulli"
+)
+
+func TestValidate(t *testing.T) {
+	tcs := []struct {
+		file string
+		want []string
+	}{
+		{
+			file: "testdata/syntax.json",
+			want: []string{
+				"testdata/syntax.json:4: invalid character '}'",
+			},
+		},
+		{
+			file: "testdata/invalid.json",
+			want: []string{
+				`testdata/invalid.json:3: destination Jeunesse: `,
+				`testdata/invalid.json:6: Providers: unknown provider "tf1"`,
+				`testdata/invalid.json:11: Providers.gulli: HitsRate: json: cannot unmarshal string`,
+				`testdata/invalid.json:19: WatchList[0]: TitleFilter: error parsing regexp`,
+				`testdata/invalid.json:18: WatchList[0] (Pokémon): destination "Séries" isn't defined in Destinations`,
+				`testdata/invalid.json:26: WatchList[1]: unknown field "Colour"`,
+				`testdata/invalid.json:25: WatchList[1] (Doctor Who): Can't use the name template of show "Doctor Who"`,
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.file, func(t *testing.T) {
+			problems, err := Validate(tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(problems) != len(tc.want) {
+				t.Errorf("expecting %d problems, got %d: %v", len(tc.want), len(problems), problems)
+				return
+			}
+			for i, p := range problems {
+				if !strings.HasPrefix(p.Error(), tc.want[i]) {
+					t.Errorf("problem %d: got %q, want %q", i, p.Error(), tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRead(t *testing.T) {
+	t.Setenv("ASPIRATV_DESTINATIONS_DOCUMENTAIRES", "/tmp/docs")
+	t.Setenv("ASPIRATV_PROVIDERS_GULLI_ENABLED", "false")
+	t.Setenv("ASPIRATV_PROVIDERS_GULLI_PROXY", "socks5://localhost:1080")
+	t.Setenv("ASPIRATV_NETWORK_TIMEOUT", "30")
+
+	s, err := Read("testdata/include/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shows := []string{}
+	for _, m := range s.WatchList {
+		shows = append(shows, m.Show)
+	}
+	if got, want := strings.Join(shows, ","), "Les Lapins Crétins,Pokémon,Les routes de l'impossible"; got != want {
+		t.Errorf("WatchList: got %q, want %q", got, want)
+	}
+	if s.WatchList[1].TitleFilter.Regexp == nil || s.WatchList[2].SeasonPathTemplate.T == nil {
+		t.Errorf("filters and templates of included files aren't decoded")
+	}
+	if got, want := s.Destinations["Jeunesse"], "${HOME}/Videos/Jeunesse"; got != want {
+		t.Errorf("Destinations[Jeunesse]: got %q, want %q", got, want)
+	}
+	if got, want := s.Destinations["Documentaires"], "/tmp/docs"; got != want {
+		t.Errorf("Destinations[Documentaires]: got %q, want %q", got, want)
+	}
+	if !s.Providers["francetv"].Enabled || s.Providers["gulli"].Enabled {
+		t.Errorf("unexpected providers settings %v", s.Providers)
+	}
+	if s.Providers["gulli"].Proxy != "socks5://localhost:1080" || s.Network.Timeout != 30 {
+		t.Errorf("unexpected network settings %v, %v", s.Providers["gulli"], s.Network)
+	}
+
+	t.Setenv("ASPIRATV_PROVIDERS_TF1_ENABLED", "true")
+	_, err = Read("testdata/include/config.yaml")
+	if err == nil {
+		t.Errorf("expecting an error for an unknown provider")
+	}
+}
+
+func TestWatchEdit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	err := ioutil.WriteFile(path, []byte(`{
+  "Destinations": {
+    "Jeunesse": "`+dir+`"
+  },
+  "Unknown": "kept",
+  "WatchList": [
+    {
+      "Show": "Pokémon",
+      "Provider": "gulli",
+      "Destination": "Jeunesse",
+      "ShowNameTemplate": "{{.Showtitle}} <{{.Title}}>.mp4"
+    }
+  ]
+}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &matcher.MatchRequest{Show: "Les Lapins Crétins", Provider: "francetv", Destination: "Jeunesse"}
+	err = AddWatch(path, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddWatch(path, m)
+	if err == nil {
+		t.Errorf("expecting an error when adding twice the same show")
+	}
+	err = AddWatch(path, &matcher.MatchRequest{Show: "Oggy", Provider: "gulli", Destination: "Séries"})
+	if err == nil {
+		t.Errorf("expecting an error for an unknown destination")
+	}
+	err = EnableWatch(path, "", "pokémon", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{
+  "Destinations": {
+    "Jeunesse": "` + dir + `"
+  },
+  "Unknown": "kept",
+  "WatchList": [
+    {
+      "Show": "Pokémon",
+      "Provider": "gulli",
+      "Destination": "Jeunesse",
+      "ShowNameTemplate": "{{.Showtitle}} <{{.Title}}>.mp4",
+      "Disabled": true
+    },
+    {
+      "Provider": "francetv",
+      "Show": "Les Lapins Crétins",
+      "Destination": "Jeunesse"
+    }
+  ]
+}
+`
+	b, _ := ioutil.ReadFile(path)
+	if string(b) != want {
+		t.Errorf("got\n%s\nwant\n%s", b, want)
+	}
+
+	err = RemoveWatch(path, "gulli", "Pokémon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := WatchList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Show != "Les Lapins Crétins" {
+		t.Errorf("unexpected watch list %v", entries)
+	}
+}
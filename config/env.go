@@ -0,0 +1,138 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/simulot/aspiratv/network"
+	"github.com/simulot/aspiratv/providers"
+)
+
+// EnvPrefix is the prefix of environment variables overriding global settings:
+//   - ASPIRATV_DESTINATIONS_<NAME>=path
+//   - ASPIRATV_PROVIDERS_<NAME>_ENABLED=true|false
+//   - ASPIRATV_PROVIDERS_<NAME>_HITSRATE=number
+//   - ASPIRATV_PROVIDERS_<NAME>_MAXTASKS=number
+//   - ASPIRATV_PROVIDERS_<NAME>_PROXY=url
+//   - ASPIRATV_PROVIDERS_<NAME>_CACHETTL=seconds
+//   - ASPIRATV_NETWORK_PROXY=url
+//   - ASPIRATV_NETWORK_TIMEOUT=seconds
+//   - ASPIRATV_NETWORK_CONNECTTIMEOUT=seconds
+//   - ASPIRATV_NETWORK_CAFILE=path
+//   - ASPIRATV_NETWORK_COOKIEFILE=path
+//
+// Names are matched without case, characters other than letters and digits are matched by "_".
+const EnvPrefix = "ASPIRATV_"
+
+// applyEnv applies environment overrides given as KEY=VALUE strings
+func applyEnv(s *providers.Settings, environ []string) error {
+	for _, kv := range environ {
+		i := strings.Index(kv, "=")
+		if i < 0 || !strings.HasPrefix(strings.ToUpper(kv[:i]), EnvPrefix) {
+			continue
+		}
+		key, value := strings.ToUpper(kv[len(EnvPrefix):i]), kv[i+1:]
+
+		switch {
+		case strings.HasPrefix(key, "DESTINATIONS_"):
+			name := key[len("DESTINATIONS_"):]
+			s.Destinations[lookupName(s.Destinations, name)] = value
+
+		case strings.HasPrefix(key, "PROVIDERS_"):
+			j := strings.LastIndex(key, "_")
+			if j < len("PROVIDERS_") {
+				return fmt.Errorf("%s: missing provider setting", kv[:i])
+			}
+			name, setting := strings.ToLower(key[len("PROVIDERS_"):j]), key[j+1:]
+			if _, ok := providers.List()[name]; !ok {
+				return fmt.Errorf("%s: unknown provider %q", kv[:i], name)
+			}
+			ps := s.Providers[name]
+			switch setting {
+			case "ENABLED":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return fmt.Errorf("%s: %q isn't a boolean", kv[:i], value)
+				}
+				ps.Enabled = b
+			case "HITSRATE":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					return fmt.Errorf("%s: %q isn't a positive number", kv[:i], value)
+				}
+				ps.HitsRate = n
+			case "MAXTASKS":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					return fmt.Errorf("%s: %q isn't a positive number", kv[:i], value)
+				}
+				ps.MaxTasks = n
+			case "PROXY":
+				err := network.CheckProxy(value)
+				if err != nil {
+					return fmt.Errorf("%s: %s", kv[:i], err)
+				}
+				ps.Proxy = value
+			case "CACHETTL":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < -1 {
+					return fmt.Errorf("%s: %q isn't a number of seconds, or -1", kv[:i], value)
+				}
+				ps.CacheTTL = n
+			default:
+				return fmt.Errorf("%s: unknown provider setting %q", kv[:i], setting)
+			}
+			s.Providers[name] = ps
+
+		case strings.HasPrefix(key, "NETWORK_"):
+			switch setting := key[len("NETWORK_"):]; setting {
+			case "PROXY":
+				err := network.CheckProxy(value)
+				if err != nil {
+					return fmt.Errorf("%s: %s", kv[:i], err)
+				}
+				s.Network.Proxy = value
+			case "TIMEOUT", "CONNECTTIMEOUT":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					return fmt.Errorf("%s: %q isn't a positive number", kv[:i], value)
+				}
+				if setting == "TIMEOUT" {
+					s.Network.Timeout = n
+				} else {
+					s.Network.ConnectTimeout = n
+				}
+			case "CAFILE":
+				s.Network.CAFile = value
+			case "COOKIEFILE":
+				s.Network.CookieFile = value
+			default:
+				return fmt.Errorf("%s: unknown network setting %q", kv[:i], setting)
+			}
+		}
+	}
+	return nil
+}
+
+// lookupName returns the key of m matching the name found in an environment variable,
+// or the name itself when there is no match
+func lookupName(m map[string]string, name string) string {
+	for k := range m {
+		if envName(k) == name {
+			return k
+		}
+	}
+	return name
+}
+
+// envName returns the name as it appears in environment variables
+func envName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, s)
+}
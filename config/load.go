@@ -0,0 +1,253 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// WatchListDir is the directory, next to the main configuration file, where
+// files containing additional watch list entries are read.
+const WatchListDir = "watchlist.d"
+
+// document is a configuration file converted into JSON
+type document struct {
+	path   string
+	b      []byte // JSON content
+	isJSON bool   // True when b is the content of the file, and offsets give lines in the file
+}
+
+// format returns the format of the file given by its extension
+func format(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	default:
+		return "json"
+	}
+}
+
+// isConfigFile returns true for files having a supported extension
+func isConfigFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".yaml", ".yml", ".toml":
+		return true
+	}
+	return false
+}
+
+// readDocument reads a configuration file and converts it into JSON
+func readDocument(path string) (document, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return document{}, fmt.Errorf("Can't open configuration file: %w", err)
+	}
+	d := document{path: path}
+
+	var v interface{}
+	switch format(path) {
+	case "yaml":
+		// Decode mappings as MapSlice to keep the order of fields
+		ms := yaml.MapSlice{}
+		err = yaml.Unmarshal(b, &ms)
+		v = ms
+		if err != nil {
+			// The file is a list of watch list entries
+			list := []yaml.MapSlice{}
+			err = yaml.Unmarshal(b, &list)
+			v = list
+		}
+		if err != nil {
+			return d, fmt.Errorf("Can't decode configuration file %s: %w", path, err)
+		}
+		v, err = yamlToJSON(v)
+	case "toml":
+		m := map[string]interface{}{}
+		_, err = toml.Decode(string(b), &m)
+		v = m
+	default:
+		d.b, d.isJSON = b, true
+		return d, nil
+	}
+	if err != nil {
+		return d, fmt.Errorf("Can't decode configuration file %s: %w", path, err)
+	}
+	d.b, err = json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return d, fmt.Errorf("Can't decode configuration file %s: %w", path, err)
+	}
+	return d, nil
+}
+
+// yamlToJSON converts mappings decoded by yaml into objects with string keys
+func yamlToJSON(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		o := newObject()
+		for _, item := range v {
+			e, err := yamlToJSON(item.Value)
+			if err != nil {
+				return nil, err
+			}
+			o.Set(fmt.Sprint(item.Key), e)
+		}
+		return o, nil
+	case []yaml.MapSlice:
+		a := make([]interface{}, len(v))
+		for i := range v {
+			e, err := yamlToJSON(v[i])
+			if err != nil {
+				return nil, err
+			}
+			a[i] = e
+		}
+		return a, nil
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, e := range v {
+			e, err := yamlToJSON(e)
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(k)] = e
+		}
+		return m, nil
+	case []interface{}:
+		for i, e := range v {
+			e, err := yamlToJSON(e)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = e
+		}
+		return v, nil
+	}
+	return v, nil
+}
+
+// loadDocuments reads the configuration file, the files it includes and the files
+// of the watchlist.d directory. The main file comes first.
+func loadDocuments(path string) ([]document, error) {
+	docs := []document{}
+	seen := map[string]bool{}
+	err := loadDocument(path, seen, &docs)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(filepath.Dir(path), WatchListDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Can't read %s directory: %w", WatchListDir, err)
+	}
+	for _, f := range files {
+		if f.IsDir() || !isConfigFile(f.Name()) {
+			continue
+		}
+		err = loadDocument(filepath.Join(filepath.Dir(path), WatchListDir, f.Name()), seen, &docs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+// Files returns the configuration file, the files it includes and the files of the watchlist.d directory
+func Files(path string) ([]string, error) {
+	docs, err := loadDocuments(path)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, len(docs))
+	for i, d := range docs {
+		files[i] = d.path
+	}
+	return files, nil
+}
+
+// loadDocument reads a file and the files it includes
+func loadDocument(path string, seen map[string]bool, docs *[]document) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if seen[abs] {
+		return nil
+	}
+	seen[abs] = true
+
+	d, err := readDocument(path)
+	if err != nil {
+		return err
+	}
+	*docs = append(*docs, d)
+
+	includes, err := d.includes()
+	if err != nil {
+		return err
+	}
+	for _, pattern := range includes {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("Can't include %q from %s: %w", pattern, path, err)
+		}
+		if len(files) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return fmt.Errorf("Can't include %q from %s: file not found", pattern, path)
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			err = loadDocument(f, seen, docs)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// includes returns the files listed in the Include field of the document
+func (d document) includes() ([]string, error) {
+	if !isObject(d.b) {
+		return nil, nil
+	}
+	fields := map[string]json.RawMessage{}
+	err := json.Unmarshal(d.b, &fields)
+	if err != nil {
+		// Decoding errors are reported when reading settings
+		return nil, nil
+	}
+	for k, raw := range fields {
+		if !strings.EqualFold(k, "Include") {
+			continue
+		}
+		var one string
+		if json.Unmarshal(raw, &one) == nil {
+			return []string{one}, nil
+		}
+		var list []string
+		err = json.Unmarshal(raw, &list)
+		if err != nil {
+			return nil, fmt.Errorf("Include in %s must be a file name or a list of file names", d.path)
+		}
+		return list, nil
+	}
+	return nil, nil
+}
+
+// isObject returns true when the JSON document is an object. Otherwise, the document is
+// a list of watch list entries.
+func isObject(b []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(b), []byte("{"))
+}
@@ -0,0 +1,233 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// object is a JSON object keeping the order of its members, so a configuration file
+// can be edited and written back without shuffling it.
+type object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newObject() *object {
+	return &object{values: map[string]interface{}{}}
+}
+
+// key returns the actual key matching k without case, like encoding/json does
+func (o *object) key(k string) (string, bool) {
+	if _, ok := o.values[k]; ok {
+		return k, true
+	}
+	for _, ak := range o.keys {
+		if strings.EqualFold(ak, k) {
+			return ak, true
+		}
+	}
+	return "", false
+}
+
+// Get returns the value of the member k
+func (o *object) Get(k string) (interface{}, bool) {
+	k, ok := o.key(k)
+	if !ok {
+		return nil, false
+	}
+	return o.values[k], true
+}
+
+// Set changes the value of the member k, or appends it at the end of the object
+func (o *object) Set(k string, v interface{}) {
+	if ok, found := o.key(k); found {
+		k = ok
+	} else {
+		o.keys = append(o.keys, k)
+	}
+	o.values[k] = v
+}
+
+// Delete removes the member k
+func (o *object) Delete(k string) {
+	k, ok := o.key(k)
+	if !ok {
+		return
+	}
+	delete(o.values, k)
+	for i := range o.keys {
+		if o.keys[i] == k {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// MarshalJSON writes members in their order
+func (o *object) MarshalJSON() ([]byte, error) {
+	b := &bytes.Buffer{}
+	b.WriteString("{")
+	for i, k := range o.keys {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		err := encodeJSON(b, k)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(":")
+		err = encodeJSON(b, o.values[k])
+		if err != nil {
+			return nil, err
+		}
+	}
+	b.WriteString("}")
+	return b.Bytes(), nil
+}
+
+// MarshalYAML writes members in their order
+func (o *object) MarshalYAML() (interface{}, error) {
+	s := yaml.MapSlice{}
+	for _, k := range o.keys {
+		s = append(s, yaml.MapItem{Key: k, Value: o.values[k]})
+	}
+	return s, nil
+}
+
+// encodeJSON writes v without escaping HTML characters, frequent in templates
+func encodeJSON(b *bytes.Buffer, v interface{}) error {
+	e := json.NewEncoder(b)
+	e.SetEscapeHTML(false)
+	err := e.Encode(v)
+	if err != nil {
+		return err
+	}
+	b.Truncate(b.Len() - 1) // Encode adds a new line
+	return nil
+}
+
+// decodeOrdered decodes a JSON document into objects, slices, strings, booleans, int64 and float64 values
+func decodeOrdered(b []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return decodeValue(dec)
+}
+
+func decodeValue(dec *json.Decoder) (interface{}, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := t.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			o := newObject()
+			for dec.More() {
+				k, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				v, err := decodeValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				o.Set(k.(string), v)
+			}
+			_, err = dec.Token()
+			return o, err
+		case '[':
+			a := []interface{}{}
+			for dec.More() {
+				v, err := decodeValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				a = append(a, v)
+			}
+			_, err = dec.Token()
+			return a, err
+		}
+		return nil, fmt.Errorf("unexpected %v", t)
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i, nil
+		}
+		return t.Float64()
+	}
+	return t, nil
+}
+
+// plain converts objects into maps
+func plain(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *object:
+		m := map[string]interface{}{}
+		for _, k := range v.keys {
+			m[k] = plain(v.values[k])
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i := range v {
+			a[i] = plain(v[i])
+		}
+		return a
+	}
+	return v
+}
+
+// writeDocument writes the document in the format given by the file's extension.
+// The file is replaced only once the new content is completely written.
+func writeDocument(path string, v interface{}) error {
+	b := &bytes.Buffer{}
+	var err error
+	switch format(path) {
+	case "yaml":
+		var y []byte
+		y, err = yaml.Marshal(v)
+		b.Write(y)
+	case "toml":
+		err = toml.NewEncoder(b).Encode(plain(v))
+	default:
+		e := json.NewEncoder(b)
+		e.SetEscapeHTML(false)
+		e.SetIndent("", "  ")
+		err = e.Encode(v)
+	}
+	if err != nil {
+		return fmt.Errorf("Can't encode configuration file %s: %w", path, err)
+	}
+
+	mode := os.FileMode(0644)
+	if st, err := os.Stat(path); err == nil {
+		mode = st.Mode()
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return fmt.Errorf("Can't write configuration file: %w", err)
+	}
+	_, err = f.Write(b.Bytes())
+	if err == nil {
+		err = f.Chmod(mode)
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("Can't write configuration file: %w", err)
+	}
+	return nil
+}
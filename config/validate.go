@@ -0,0 +1,403 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/simulot/aspiratv/matcher"
+	"github.com/simulot/aspiratv/network"
+	"github.com/simulot/aspiratv/providers"
+)
+
+// Problem is an error found in a configuration file
+type Problem struct {
+	File    string
+	Line    int // 0 when the line is unknown
+	Message string
+}
+
+func (p Problem) Error() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.File, p.Message)
+}
+
+// Validate checks the configuration file, the files it includes, and environment overrides.
+// Configuration files and destinations are left untouched: only a temporary file is written,
+// and removed at once, in each destination to check that it is writable.
+// It reports:
+//   - syntax and type errors
+//   - unknown fields and unknown providers
+//   - invalid regular expressions and templates
+//   - undefined or unreachable destinations
+//   - invalid proxies and missing certificate files
+//
+// Line numbers are given for JSON files.
+// The error is not nil when the file can't be read.
+func Validate(path string) ([]Problem, error) {
+	_, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("Can't open configuration file: %w", err)
+	}
+	docs, err := loadDocuments(path)
+	if err != nil {
+		return []Problem{{File: path, Message: err.Error()}}, nil
+	}
+
+	// Destinations can be defined in any file
+	destinations := map[string]string{}
+	for _, d := range docs {
+		s := struct{ Destinations map[string]string }{}
+		if isObject(d.b) && json.Unmarshal(d.b, &s) == nil {
+			for k, v := range s.Destinations {
+				destinations[k] = v
+			}
+		}
+	}
+
+	problems := []Problem{}
+	for _, d := range docs {
+		c := &checker{file: d.path, b: d.b, lines: d.isJSON, destinations: destinations}
+		c.check()
+		problems = append(problems, c.problems...)
+	}
+
+	// Decoding errors are already reported
+	if s, err := read(path); err == nil {
+		err = applyEnv(s, os.Environ())
+		if err != nil {
+			problems = append(problems, Problem{File: "environment", Message: err.Error()})
+		}
+	}
+	return problems, nil
+}
+
+type checker struct {
+	file         string
+	b            []byte
+	lines        bool              // True when offsets give lines in the file
+	destinations map[string]string // Destinations defined in all files
+	problems     []Problem
+}
+
+// field is a member of a JSON object with its position in the file
+type field struct {
+	name   string
+	raw    json.RawMessage
+	offset int
+}
+
+func (c *checker) addf(offset int, format string, args ...interface{}) {
+	p := Problem{
+		File:    c.file,
+		Message: fmt.Sprintf(format, args...),
+	}
+	if c.lines {
+		p.Line = lineOf(c.b, offset)
+	}
+	c.problems = append(c.problems, p)
+}
+
+func (c *checker) check() {
+	var v interface{}
+	err := json.Unmarshal(c.b, &v)
+	if err != nil {
+		c.problems = append(c.problems, Problem{File: c.file, Line: errorLine(c.b, err), Message: err.Error()})
+		return
+	}
+
+	if !isObject(c.b) {
+		// A list of watch list entries
+		c.checkWatchList(field{name: "WatchList", raw: c.b})
+		return
+	}
+
+	fields, ok := c.fields(c.b, 0, "configuration")
+	if !ok {
+		return
+	}
+
+	for _, f := range fields {
+		switch strings.ToLower(f.name) {
+		case "destinations":
+			c.checkDestinations(f)
+		case "providers":
+			c.checkProviders(f)
+		case "network":
+			c.checkNetwork(f)
+		case "watchlist":
+			c.checkWatchList(f)
+		case "include":
+			// Already handled when loading files
+		default:
+			c.addf(f.offset, "unknown field %q", f.name)
+		}
+	}
+}
+
+// fields returns the members of the JSON object raw, located at offset base in the file
+func (c *checker) fields(raw json.RawMessage, base int, what string) ([]field, bool) {
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	t, err := dec.Token()
+	if d, ok := t.(json.Delim); err != nil || !ok || d != '{' {
+		c.addf(base, "%s must be an object", what)
+		return nil, false
+	}
+	fields := []field{}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			c.addf(base+int(dec.InputOffset()), "%s", err)
+			return fields, false
+		}
+		f := field{name: t.(string)}
+		err = dec.Decode(&f.raw)
+		if err != nil {
+			c.addf(base+int(dec.InputOffset()), "%s", err)
+			return fields, false
+		}
+		f.offset = base + int(dec.InputOffset()) - len(f.raw)
+		fields = append(fields, f)
+	}
+	return fields, true
+}
+
+// elements returns the items of the JSON array raw, located at offset base in the file
+func (c *checker) elements(raw json.RawMessage, base int, what string) ([]field, bool) {
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	t, err := dec.Token()
+	if d, ok := t.(json.Delim); err != nil || !ok || d != '[' {
+		c.addf(base, "%s must be an array", what)
+		return nil, false
+	}
+	items := []field{}
+	for i := 0; dec.More(); i++ {
+		f := field{name: fmt.Sprintf("%s[%d]", what, i)}
+		err = dec.Decode(&f.raw)
+		if err != nil {
+			c.addf(base+int(dec.InputOffset()), "%s", err)
+			return items, false
+		}
+		f.offset = base + int(dec.InputOffset()) - len(f.raw)
+		items = append(items, f)
+	}
+	return items, true
+}
+
+// decodeStruct decodes the JSON object field by field into the structure pointed by v
+// to report each error at the right line.
+// It returns the fields of the object indexed by the name of the structure's fields.
+func (c *checker) decodeStruct(f field, v interface{}) map[string]field {
+	decoded := map[string]field{}
+	fields, ok := c.fields(f.raw, f.offset, f.name)
+	if !ok {
+		return decoded
+	}
+	s := reflect.ValueOf(v).Elem()
+	for _, jf := range fields {
+		sf, ok := structField(s.Type(), jf.name)
+		if !ok {
+			c.addf(jf.offset, "%s: unknown field %q", f.name, jf.name)
+			continue
+		}
+		err := json.Unmarshal(jf.raw, s.FieldByName(sf).Addr().Interface())
+		if err != nil {
+			c.addf(jf.offset, "%s: %s: %s", f.name, jf.name, err)
+			continue
+		}
+		decoded[sf] = jf
+	}
+	return decoded
+}
+
+// structField finds the structure's field matching the JSON name like encoding/json does
+func structField(t reflect.Type, name string) (string, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		jsonName := sf.Name
+		if tag := strings.Split(sf.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			jsonName = tag
+		}
+		if strings.EqualFold(jsonName, name) {
+			return sf.Name, true
+		}
+	}
+	return "", false
+}
+
+func (c *checker) checkDestinations(f field) {
+	fields, ok := c.fields(f.raw, f.offset, "Destinations")
+	if !ok {
+		return
+	}
+	for _, d := range fields {
+		var p string
+		err := json.Unmarshal(d.raw, &p)
+		if err != nil {
+			c.addf(d.offset, "Destinations: %q: %s", d.name, err)
+			continue
+		}
+		c.checkPath(d.offset, "destination "+d.name, p)
+	}
+}
+
+func (c *checker) checkProviders(f field) {
+	fields, ok := c.fields(f.raw, f.offset, "Providers")
+	if !ok {
+		return
+	}
+	for _, p := range fields {
+		if _, ok := providers.List()[p.name]; !ok {
+			c.addf(p.offset, "Providers: unknown provider %q", p.name)
+			continue
+		}
+		ps := providers.ProviderSettings{}
+		p.name = "Providers." + p.name
+		decoded := c.decodeStruct(p, &ps)
+		if ps.HitsRate < 0 {
+			c.addf(decoded["HitsRate"].offset, "%s: HitsRate can't be negative", p.name)
+		}
+		if ps.MaxTasks < 0 {
+			c.addf(decoded["MaxTasks"].offset, "%s: MaxTasks can't be negative", p.name)
+		}
+		if ps.CacheTTL < -1 {
+			c.addf(decoded["CacheTTL"].offset, "%s: CacheTTL must be a number of seconds, or -1 to disable the cache", p.name)
+		}
+		if ps.Proxy != "" {
+			if err := network.CheckProxy(ps.Proxy); err != nil {
+				c.addf(decoded["Proxy"].offset, "%s: %s", p.name, err)
+			}
+		}
+	}
+}
+
+func (c *checker) checkNetwork(f field) {
+	n := network.Config{}
+	f.name = "Network"
+	decoded := c.decodeStruct(f, &n)
+	if n.Proxy != "" {
+		if err := network.CheckProxy(n.Proxy); err != nil {
+			c.addf(decoded["Proxy"].offset, "Network: %s", err)
+		}
+	}
+	if n.Timeout < 0 {
+		c.addf(decoded["Timeout"].offset, "Network: Timeout can't be negative")
+	}
+	if n.ConnectTimeout < 0 {
+		c.addf(decoded["ConnectTimeout"].offset, "Network: ConnectTimeout can't be negative")
+	}
+	if n.CAFile != "" {
+		p, err := providers.ExpandPath(n.CAFile)
+		if err == nil {
+			_, err = os.Stat(p)
+		}
+		if err != nil {
+			c.addf(decoded["CAFile"].offset, "Network: CAFile: %s", err)
+		}
+	}
+}
+
+func (c *checker) checkWatchList(f field) {
+	items, ok := c.elements(f.raw, f.offset, "WatchList")
+	if !ok {
+		return
+	}
+	for _, item := range items {
+		m := matcher.MatchRequest{}
+		decoded := c.decodeStruct(item, &m)
+		if m.Show != "" {
+			item.name += fmt.Sprintf(" (%s)", m.Show)
+		}
+
+		err := m.Check()
+		if err != nil {
+			c.addf(item.offset, "%s: %s", item.name, err)
+		}
+		if m.Provider != "" {
+			if _, ok := providers.List()[m.Provider]; !ok {
+				c.addf(decoded["Provider"].offset, "%s: unknown provider %q", item.name, m.Provider)
+			}
+		}
+		if m.Destination != "" && m.ShowRootPath == "" {
+			if _, ok := c.destinations[m.Destination]; !ok {
+				c.addf(decoded["Destination"].offset, "%s: destination %q isn't defined in Destinations", item.name, m.Destination)
+			}
+		}
+		if m.ShowRootPath != "" {
+			c.checkPath(decoded["ShowRootPath"].offset, item.name+": show path", m.ShowRootPath)
+		}
+		c.checkTemplates(item.name, m, decoded)
+	}
+}
+
+// checkTemplates executes templates against a sample media. Each template is checked alone, so
+// the error is reported at its own field.
+func (c *checker) checkTemplates(name string, m matcher.MatchRequest, decoded map[string]field) {
+	if m.ShowNameTemplate.T != nil && m.ShowNameTemplate.S == "" {
+		c.addf(decoded["ShowNameTemplate"].offset, "%s: ShowNameTemplate can't be empty", name)
+		m.ShowNameTemplate = matcher.TemplateString{}
+	}
+	onlySeason := m
+	onlySeason.ShowNameTemplate = matcher.TemplateString{}
+	if err := onlySeason.CheckTemplates(); err != nil {
+		c.addf(decoded["SeasonPathTemplate"].offset, "%s: %s", name, err)
+	}
+	onlyName := m
+	onlyName.SeasonPathTemplate = matcher.TemplateString{}
+	if err := onlyName.CheckTemplates(); err != nil {
+		c.addf(decoded["ShowNameTemplate"].offset, "%s: %s", name, err)
+	}
+}
+
+// checkPath verifies that the path is a writable directory, or can be created
+func (c *checker) checkPath(offset int, what string, p string) {
+	p, err := providers.ExpandPath(p)
+	if err != nil {
+		c.addf(offset, "%s: %s", what, err)
+		return
+	}
+	dir := p
+	for {
+		st, err := os.Stat(dir)
+		if err == nil {
+			if !st.IsDir() {
+				c.addf(offset, "%s: %q isn't a directory", what, dir)
+				return
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			c.addf(offset, "%s: %s", what, err)
+			return
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			c.addf(offset, "%s: %q can't be reached", what, p)
+			return
+		}
+		dir = parent
+	}
+	// Writing a file is the only portable way to check the permissions
+	f, err := ioutil.TempFile(dir, ".aspiratv-")
+	if err != nil {
+		if dir == p {
+			c.addf(offset, "%s: %q isn't writable", what, p)
+		} else {
+			c.addf(offset, "%s: %q can't be created, %q isn't writable", what, p, dir)
+		}
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/simulot/aspiratv/matcher"
+	"github.com/simulot/aspiratv/providers"
+)
+
+// WatchEntry is a watch list entry with the file where it is defined
+type WatchEntry struct {
+	*matcher.MatchRequest
+	File  string // File where the entry is defined
+	Index int    // Position of the entry in the file's watch list
+}
+
+// WatchList returns watch list entries of the configuration file and the files it includes
+func WatchList(path string) ([]WatchEntry, error) {
+	docs, err := loadDocuments(path)
+	if err != nil {
+		return nil, err
+	}
+	entries := []WatchEntry{}
+	for _, d := range docs {
+		s, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		for i, m := range s.WatchList {
+			entries = append(entries, WatchEntry{MatchRequest: m, File: d.path, Index: i})
+		}
+	}
+	return entries, nil
+}
+
+// FindWatch returns the entry for the show. The provider can be omitted when
+// the show is watched on only one provider.
+func FindWatch(path string, provider, show string) (WatchEntry, error) {
+	entries, err := WatchList(path)
+	if err != nil {
+		return WatchEntry{}, err
+	}
+	found := []WatchEntry{}
+	for _, e := range entries {
+		if strings.EqualFold(e.Show, show) && (provider == "" || strings.EqualFold(e.Provider, provider)) {
+			found = append(found, e)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return WatchEntry{}, fmt.Errorf("Show %q isn't in the watch list", show)
+	case 1:
+		return found[0], nil
+	}
+	return WatchEntry{}, fmt.Errorf("Show %q is watched on several providers, give the provider", show)
+}
+
+// AddWatch appends the entry to the watch list of the main configuration file
+func AddWatch(path string, m *matcher.MatchRequest) error {
+	s, err := Read(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := providers.List()[m.Provider]; !ok {
+		return fmt.Errorf("Unknown provider %q", m.Provider)
+	}
+	for _, e := range s.WatchList {
+		if strings.EqualFold(e.Show, m.Show) && e.Provider == m.Provider {
+			return fmt.Errorf("Show %q is already watched on %s", m.Show, m.Provider)
+		}
+	}
+	expanded, err := s.Expand()
+	if err != nil {
+		return err
+	}
+	err = m.Validate(expanded.Destinations)
+	if err != nil {
+		return err
+	}
+
+	entry, err := entryObject(m)
+	if err != nil {
+		return err
+	}
+	return editWatchList(path, func(list []interface{}) ([]interface{}, error) {
+		return append(list, entry), nil
+	})
+}
+
+// RemoveWatch removes the entry from the file where it is defined
+func RemoveWatch(path string, provider, show string) error {
+	e, err := FindWatch(path, provider, show)
+	if err != nil {
+		return err
+	}
+	return editWatchList(e.File, func(list []interface{}) ([]interface{}, error) {
+		return append(list[:e.Index], list[e.Index+1:]...), nil
+	})
+}
+
+// EnableWatch enables or disables the entry in the file where it is defined
+func EnableWatch(path string, provider, show string, enabled bool) error {
+	e, err := FindWatch(path, provider, show)
+	if err != nil {
+		return err
+	}
+	return editWatchList(e.File, func(list []interface{}) ([]interface{}, error) {
+		o, ok := list[e.Index].(*object)
+		if !ok {
+			return nil, fmt.Errorf("Entry %d of %s isn't an object", e.Index, e.File)
+		}
+		if enabled {
+			o.Delete("Disabled")
+		} else {
+			o.Set("Disabled", true)
+		}
+		return list, nil
+	})
+}
+
+// editWatchList changes the watch list of one file, leaving other fields untouched
+func editWatchList(path string, edit func([]interface{}) ([]interface{}, error)) error {
+	d, err := readDocument(path)
+	if err != nil {
+		return err
+	}
+	root, err := decodeOrdered(d.b)
+	if err != nil {
+		return fmt.Errorf("Can't decode configuration file %s: %w", path, err)
+	}
+
+	var list []interface{}
+	switch r := root.(type) {
+	case *object:
+		if v, ok := r.Get("WatchList"); ok && v != nil {
+			list, ok = v.([]interface{})
+			if !ok {
+				return fmt.Errorf("WatchList of %s isn't a list", path)
+			}
+		}
+		list, err = edit(list)
+		if err != nil {
+			return err
+		}
+		r.Set("WatchList", list)
+	case []interface{}:
+		root, err = edit(r)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unexpected content in %s", path)
+	}
+	return writeDocument(path, root)
+}
+
+// entryObject returns the entry with only the fields that are set
+func entryObject(m *matcher.MatchRequest) (*object, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	v, err := decodeOrdered(b)
+	if err != nil {
+		return nil, err
+	}
+	full := v.(*object)
+	o := newObject()
+	for _, k := range full.keys {
+		switch full.values[k] {
+		case nil, "", int64(0), false:
+			// An empty season template is meaningful: it skips the season in path
+			if k != "SeasonPathTemplate" || m.SeasonPathTemplate.T == nil {
+				continue
+			}
+		}
+		o.Set(k, full.values[k])
+	}
+	return o, nil
+}
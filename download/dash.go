@@ -10,8 +10,10 @@ import (
 	"os/exec"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/simulot/aspiratv/metadata/nfo"
+	"github.com/simulot/aspiratv/metrics"
 	"github.com/simulot/aspiratv/parsers/mpdparser"
 	"github.com/simulot/aspiratv/parsers/ttml"
 )
@@ -49,14 +51,13 @@ func DASH(ctx context.Context, in, out string, info *nfo.MediaInfo, conf ...conf
 	for _, c := range conf {
 		c(d.conf)
 	}
+	d.conf.logger = d.conf.logger.Subsystem("dash")
+	d.conf.setPausable(true)
+	defer d.conf.setPausable(false)
 
-	defer func() {
-		if d.conf.fb != nil {
-			d.conf.fb.Done()
-		}
-	}()
-	d.mpd = mpdparser.NewMPDParser()
-	d.conf.logger.Trace().Printf("[DASH] Get manifest at %q", in)
+	d.conf.stage("getting manifest...")
+	d.mpd = mpdparser.NewMPDParser(mpdparser.WithClient(d.conf.client))
+	d.conf.logger.Trace().Printf("Get manifest at %q", in)
 	err := d.mpd.Get(ctx, in)
 	if err != nil {
 		return fmt.Errorf("[DASH] Can't get manifest: %s", err)
@@ -64,6 +65,14 @@ func DASH(ctx context.Context, in, out string, info *nfo.MediaInfo, conf ...conf
 
 	segmentIterators := []mpdparser.SegmentIterator{}
 	segmentFileName := []string{}
+	expected := Expected{}
+	pt := d.mpd.Period[0].Duration
+	if pt == "" {
+		pt = d.mpd.MediaPresentationDuration
+	}
+	if duration, err := mpdparser.GetPTasDuration(pt); err == nil {
+		expected.Duration, expected.Tolerance = duration, manifestTolerance(duration)
+	}
 
 	for _, as := range d.mpd.Period[0].AdaptationSet {
 
@@ -76,7 +85,18 @@ func DASH(ctx context.Context, in, out string, info *nfo.MediaInfo, conf ...conf
 		if err != nil {
 			return fmt.Errorf("Can't get segments list: %s", err)
 		}
-		d.conf.logger.Trace().Printf("[DASH] Found representation for type=%q, lang=%q, representation=%q", as.ContentType, as.Lang, best.ID)
+		d.conf.logger.Trace().Printf("Found representation for type=%q, lang=%q, representation=%q", as.ContentType, as.Lang, best.ID)
+		switch it.Content() {
+		case "video":
+			expected.Video++
+			if expected.Height == 0 {
+				expected.Height = best.Height
+			}
+		case "audio":
+			expected.Audio++
+		case "text":
+			expected.Subtitles++
+		}
 		segmentIterators = append(segmentIterators, it)
 		segmentFileName = append(segmentFileName, out+"."+it.Content()+"-"+it.Lang()+".mp4")
 	}
@@ -85,15 +105,16 @@ func DASH(ctx context.Context, in, out string, info *nfo.MediaInfo, conf ...conf
 
 	defer func() {
 		if returnedErr != nil {
-			d.conf.logger.Error().Printf("[DASH] %v", returnedErr)
+			d.conf.logger.Error().Printf("%v", returnedErr)
 		} else {
-			d.conf.logger.Trace().Printf("[DASH] successful download of %s", out)
+			d.conf.logger.Trace().Printf("successful download of %s", out)
 		}
 		for _, k := range segmentFileName {
 			os.Remove(k)
 		}
 	}()
 
+	d.conf.stage("downloading streams...")
 	wg := sync.WaitGroup{}
 	for k, it := range segmentIterators {
 		wg.Add(1)
@@ -180,7 +201,8 @@ func DASH(ctx context.Context, in, out string, info *nfo.MediaInfo, conf ...conf
 		out,
 	)
 
-	d.conf.logger.Trace().Printf("[DASH] ffmpeg %q", params)
+	d.conf.logger.Trace().Printf("ffmpeg %q", params)
+	d.conf.stage("combining streams...")
 
 	d.cmd = exec.CommandContext(ctx, "ffmpeg", params...)
 	stdOut, returnedErr := d.cmd.StderrPipe()
@@ -194,11 +216,15 @@ func DASH(ctx context.Context, in, out string, info *nfo.MediaInfo, conf ...conf
 		return fmt.Errorf("[DASH] %w", returnedErr)
 	}
 
+	start := time.Now()
 	returnedErr = d.cmd.Wait()
+	ffmpegDuration.Observe(time.Since(start).Seconds(), "dash")
 	if returnedErr != nil {
 		returnedErr = fmt.Errorf("[FFMPEG] Error %s,\n %w", d.lastFFMPGLine, returnedErr)
+		return returnedErr
 	}
 
+	returnedErr = d.conf.verify(ctx, out, expected)
 	return returnedErr
 }
 
@@ -267,23 +293,17 @@ func (p *progressionIterator) Next() <-chan mpdparser.SegmentItem {
 	c := make(chan mpdparser.SegmentItem)
 	go func() {
 		for s := range p.it.Next() {
-			if p.d.conf.fb != nil {
-				if s.Position.Duration > 0 && s.Position.Time > 0 {
-					read := int(atomic.LoadInt64(&p.d.bytesRead))
-					percent := float64(s.Position.Time) / float64(s.Position.Duration)
-					estimated := int(float64(read) / percent)
-					if estimated < read {
-						estimated = read + 1024
-					}
-					p.d.conf.fb.Total(estimated)
-					p.d.conf.fb.Update(int(read))
+			if s.Position.Duration > 0 && s.Position.Time > 0 {
+				read := atomic.LoadInt64(&p.d.bytesRead)
+				percent := float64(s.Position.Time) / float64(s.Position.Duration)
+				estimated := int64(float64(read) / percent)
+				if estimated < read {
+					estimated = read + 1024
 				}
+				p.d.conf.progress(read, estimated)
 			}
 			c <- s
 		}
-		if p.d.conf.fb != nil {
-			p.d.conf.fb.Done()
-		}
 		close(c)
 	}()
 	return c
@@ -318,6 +338,12 @@ func (d *dashConfig) downloadSegments(ctx context.Context, filename string, it m
 	}()
 
 	for s := range it.Next() {
+		err = d.conf.waitResumed(ctx)
+		if err != nil {
+			cancelled = true
+			it.Cancel()
+			return err
+		}
 		<-d.getTokens
 		select {
 		case <-ctx.Done():
@@ -330,20 +356,14 @@ func (d *dashConfig) downloadSegments(ctx context.Context, filename string, it m
 				cancelled = true
 				return fmt.Errorf("Can't get segment: %w", s.Err)
 			}
-			d.conf.logger.Debug().Printf("[DASH] Get segment %q", s.S)
-			r, err := http.Get(s.S)
+			d.conf.logger.Debug().Printf("Get segment %q", s.S)
+			r, err := d.getSegment(ctx, s.S)
 			if err != nil {
 				d.getTokens <- true
 				cancelled = true
 				it.Cancel()
 				return fmt.Errorf("Can't get segment: %w", err)
 			}
-			if r.StatusCode >= 400 {
-				it.Cancel()
-				d.getTokens <- true
-				cancelled = true
-				return fmt.Errorf("Can't get segment: %s", r.Status)
-			}
 			// n, err := io.CopyBuffer(f, r.Body, nil)
 			n, err := filter(f, r.Body)
 			if err != nil {
@@ -361,3 +381,41 @@ func (d *dashConfig) downloadSegments(ctx context.Context, filename string, it m
 	}
 	return nil
 }
+
+const segmentMaxAttempts = 3
+
+var segmentRetries = metrics.NewCounter("aspiratv_segment_retries_total", "Number of DASH segment downloads retried after an error.")
+
+// getSegment gets the segment at url. Network errors and server errors are retried.
+func (d *dashConfig) getSegment(ctx context.Context, url string) (*http.Response, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var r *http.Response
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		r, err = d.conf.client.Do(req)
+		if err == nil {
+			if r.StatusCode < 400 {
+				return r, nil
+			}
+			r.Body.Close()
+			err = fmt.Errorf("%s", r.Status)
+			if r.StatusCode < 500 {
+				return nil, err
+			}
+		}
+		if attempt >= segmentMaxAttempts {
+			return nil, err
+		}
+		segmentRetries.Inc()
+		d.conf.logger.Debug().Printf("Retry segment %q after error: %s", url, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+}
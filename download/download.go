@@ -8,27 +8,25 @@ import (
 	"path"
 	"strings"
 
+	"github.com/simulot/aspiratv/events"
 	"github.com/simulot/aspiratv/metadata/nfo"
 	"github.com/simulot/aspiratv/mylog"
+	"github.com/simulot/aspiratv/network"
 )
 
-// FeedBacker is an iterface for providing feed back on concurrent tasks
-// like a progression bar on the console
-type FeedBacker interface {
-	Stage(stage string) // Indicate current stage
-	Total(total int)    // Indicate the total number (could be bytes, percent )
-	Update(current int) // Indicate the current position
-	Done()              // Call when the task is done
-}
-
 type downloadConfiguration struct {
-	fb     FeedBacker
-	logger *mylog.MyLog
+	bus          *events.Bus  // Where progression events are published
+	subject      events.Media // The media being downloaded
+	logger       *mylog.MyLog
+	pauser       Pauser       // Suspends the download while paused
+	client       *http.Client // Client used for manifests and segments
+	verification bool         // Probe the downloaded file with ffprobe
 	// params map[string]string
 }
 
 func newDownloadConfiguration() *downloadConfiguration {
 	return &downloadConfiguration{
+		client: http.DefaultClient,
 		// params: map[string]string{},
 	}
 }
@@ -37,24 +35,34 @@ type configurationFunction func(*downloadConfiguration)
 
 // Download determine the type of media at given url and launch the appropriate download method
 func Download(ctx context.Context, log *mylog.MyLog, in, out string, info *nfo.MediaInfo, configfn ...configurationFunction) error {
-	req, err := http.NewRequest("HEAD", in, nil)
+	conf := newDownloadConfiguration()
+	for _, c := range configfn {
+		c(conf)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", in, nil)
 	if err != nil {
 		return fmt.Errorf("Download: can't get HEAD, %w", err)
 	}
 
-	transport := http.Transport{}
-	resp, err := transport.RoundTrip(req)
+	resp, err := network.NoRedirect(conf.client).Do(req)
 	if err != nil {
 		return fmt.Errorf("Download: can't get HEAD, %w", err)
 	}
+	resp.Body.Close()
 
 	if l := resp.Header.Get("Location"); l != "" {
 		// Substitute in URL by relocation address
 		in = l
-		resp, err = http.Head(in)
+		req, err = http.NewRequestWithContext(ctx, "HEAD", in, nil)
 		if err != nil {
 			return err
 		}
+		resp, err = conf.client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
 	}
 	if resp.StatusCode >= 300 {
 		return fmt.Errorf("[DOWNLOAD] HTTP Error: %s", err)
@@ -98,10 +106,11 @@ func Download(ctx context.Context, log *mylog.MyLog, in, out string, info *nfo.M
 	return nil
 }
 
-// WithProgress add a feedbacker to the configuration
-func WithProgress(fb FeedBacker) configurationFunction {
+// WithEvents publishes download's progression of the media on the bus
+func WithEvents(bus *events.Bus, subject events.Media) configurationFunction {
 	return func(c *downloadConfiguration) {
-		c.fb = fb
+		c.bus = bus
+		c.subject = subject
 	}
 }
 
@@ -111,3 +120,57 @@ func WithLogger(logger *mylog.MyLog) configurationFunction {
 		c.logger = logger
 	}
 }
+
+// WithClient gives the HTTP client used to get manifests and segments
+func WithClient(client *http.Client) configurationFunction {
+	return func(c *downloadConfiguration) {
+		c.client = client
+	}
+}
+
+// WithVerification probes the downloaded file with ffprobe. The download fails with ErrVerification
+// when the duration or the streams of the file aren't the expected ones.
+func WithVerification(verification bool) configurationFunction {
+	return func(c *downloadConfiguration) {
+		c.verification = verification
+	}
+}
+
+// Pauser suspends a download. WaitResumed blocks while the download is paused.
+// SetPausable tells when the download can be paused: only DASH downloads, while they get segments.
+type Pauser interface {
+	SetPausable(pausable bool)
+	WaitResumed(ctx context.Context) error
+}
+
+// WithPauser lets the download be paused between two DASH segments
+func WithPauser(p Pauser) configurationFunction {
+	return func(c *downloadConfiguration) {
+		c.pauser = p
+	}
+}
+
+// setPausable tells the pauser whether the download can be paused
+func (c *downloadConfiguration) setPausable(pausable bool) {
+	if c.pauser != nil {
+		c.pauser.SetPausable(pausable)
+	}
+}
+
+// waitResumed blocks while the download is paused
+func (c *downloadConfiguration) waitResumed(ctx context.Context) error {
+	if c.pauser == nil {
+		return ctx.Err()
+	}
+	return c.pauser.WaitResumed(ctx)
+}
+
+// stage publishes the current stage of the download
+func (c *downloadConfiguration) stage(stage string) {
+	c.bus.Publish(events.StageChanged{Media: c.subject, Stage: stage})
+}
+
+// progress publishes the current position of the download
+func (c *downloadConfiguration) progress(current, total int64) {
+	c.bus.Publish(events.Progress{Media: c.subject, Current: current, Total: total})
+}
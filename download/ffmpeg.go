@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/simulot/aspiratv/metadata/nfo"
+	"github.com/simulot/aspiratv/metrics"
 )
 
 func dropCR(data []byte) []byte {
@@ -39,7 +40,8 @@ func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	return 0, nil, nil
 }
 
-func (c *ffmpegConfig) watchProgress(r io.ReadCloser, fb FeedBacker) {
+func (c *ffmpegConfig) watchProgress(r io.ReadCloser) {
+	conf := c.conf
 	sc := bufio.NewScanner(r)
 	sc.Split(scanLines)
 	go func() {
@@ -97,10 +99,7 @@ func (c *ffmpegConfig) watchProgress(r io.ReadCloser, fb FeedBacker) {
 						estimatedSize = size + 1024
 					}
 				}
-				if fb != nil {
-					fb.Total(int(estimatedSize))
-					fb.Update(int(size))
-				}
+				conf.progress(size, estimatedSize)
 				continue
 			}
 
@@ -111,9 +110,7 @@ func (c *ffmpegConfig) watchProgress(r io.ReadCloser, fb FeedBacker) {
 					continue
 				}
 				total = float64(h*int64(time.Hour) + m*int64(time.Minute) + s*int64(time.Second) + c*int64(time.Millisecond)/10)
-				if fb != nil {
-					fb.Total(int(1 * 1024 * 1024))
-				}
+				conf.progress(0, 1*1024*1024)
 			}
 
 		}
@@ -121,6 +118,8 @@ func (c *ffmpegConfig) watchProgress(r io.ReadCloser, fb FeedBacker) {
 	}()
 }
 
+var ffmpegDuration = metrics.NewHistogram("aspiratv_ffmpeg_duration_seconds", "Duration of ffmpeg runs, by download method.", nil, "method")
+
 type ffmpegConfig struct {
 	conf     *downloadConfiguration
 	lastLine string
@@ -134,11 +133,9 @@ func ffmpeg(ctx context.Context, in, out string, info *nfo.MediaInfo, configurat
 	for _, c := range configurations {
 		c(cfg.conf)
 	}
+	cfg.conf.logger = cfg.conf.logger.Subsystem("ffmpeg")
 	defer func() {
-		if cfg.conf.fb != nil {
-			cfg.conf.fb.Done()
-		}
-		cfg.conf.logger.Trace().Printf("[FFMPEG] Exit, %s,%s", out, ctx.Err())
+		cfg.conf.logger.Trace().Printf("Exit, %s,%s", out, ctx.Err())
 	}()
 	params := []string{
 		"-loglevel", "info", // Give me feedback
@@ -156,7 +153,7 @@ func ffmpeg(ctx context.Context, in, out string, info *nfo.MediaInfo, configurat
 		"-f", "mp4", // Be sure that output
 		out, // output file
 	}
-	cfg.conf.logger.Trace().Printf("[FFMPEG] running ffmpeg %v", params)
+	cfg.conf.logger.Trace().Printf("running ffmpeg %v", params)
 
 	cfg.cmd = exec.CommandContext(ctx, "ffmpeg", params...)
 	stdOut, err := cfg.cmd.StderrPipe()
@@ -164,15 +161,23 @@ func ffmpeg(ctx context.Context, in, out string, info *nfo.MediaInfo, configurat
 		return fmt.Errorf("[FFMPEG] %w", err)
 	}
 
-	cfg.watchProgress(stdOut, cfg.conf.fb)
+	cfg.conf.stage("downloading media...")
+	cfg.watchProgress(stdOut)
 	err = cfg.cmd.Start()
 	if err != nil {
 		return err
 	}
+	start := time.Now()
 	err = cfg.cmd.Wait()
+	ffmpegDuration.Observe(time.Since(start).Seconds(), "ffmpeg")
 	if err != nil {
-		err = fmt.Errorf("[FFMPEG] Error %s,\n %w", cfg.lastLine, err)
+		return fmt.Errorf("[FFMPEG] Error %s,\n %w", cfg.lastLine, err)
 	}
 
-	return err
+	expected := Expected{Video: 1, Audio: 1}
+	if info != nil && info.Runtime > 0 {
+		expected.Duration = time.Duration(info.Runtime) * time.Minute
+		expected.Tolerance = runtimeTolerance(expected.Duration)
+	}
+	return cfg.conf.verify(ctx, out, expected)
 }
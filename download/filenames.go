@@ -3,8 +3,11 @@ package download
 import (
 	"fmt"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/simulot/aspiratv/matcher"
 	"github.com/simulot/aspiratv/metadata/nfo"
@@ -41,14 +44,14 @@ func Format2Digits(d string) string {
 
 var (
 	seasonTemplates = map[nfo.ShowType]*template.Template{
-		nfo.TypeShow:   template.Must(template.New("serieTVShow").Parse(`{{if not .IsBonus}}Season {{.Aired.Time.Year | printf "%04d" }}{{else}}Specials{{end}}`)),
-		nfo.TypeSeries: template.Must(template.New("serieSeason").Parse(`{{if not .IsBonus}}Season {{.Season | printf "%02d" }}{{else}}Specials{{end}}`)),
+		nfo.TypeShow:   template.Must(matcher.NewTemplate("serieTVShow", `{{if not .IsBonus}}Season {{.Aired.Time.Year | printf "%04d" }}{{else}}Specials{{end}}`)),
+		nfo.TypeSeries: template.Must(matcher.NewTemplate("serieSeason", `{{if not .IsBonus}}Season {{.Season | printf "%02d" }}{{else}}Specials{{end}}`)),
 		nfo.TypeMovie:  nil,
 	}
 	showNameTemplates = map[nfo.ShowType]*template.Template{
-		nfo.TypeShow:   template.Must(template.New("serieTVShow").Parse(`{{.Showtitle}} - {{.Aired.Time.Format "2006-01-02"}} - {{.Title}}.mp4`)),
-		nfo.TypeSeries: template.Must(template.New("serieShowName").Parse(`{{.Showtitle}} - s{{.Season | printf "%02d" }}e{{.Episode | printf "%02d" }} - {{.Title}}.mp4`)),
-		nfo.TypeMovie:  template.Must(template.New("movieName").Parse(`{{.Title}}.mp4`)),
+		nfo.TypeShow:   template.Must(matcher.NewTemplate("serieTVShow", `{{.Showtitle}} - {{.Aired.Time.Format "2006-01-02"}} - {{.Title}}.mp4`)),
+		nfo.TypeSeries: template.Must(matcher.NewTemplate("serieShowName", `{{.Showtitle}} - s{{.Season | printf "%02d" }}e{{.Episode | printf "%02d" }} - {{.Title}}.mp4`)),
+		nfo.TypeMovie:  template.Must(matcher.NewTemplate("movieName", `{{.Title}}.mp4`)),
 	}
 )
 
@@ -67,7 +70,7 @@ func SeasonPath(showPath string, m *matcher.MatchRequest, info *nfo.MediaInfo) (
 	}
 
 	if seasonTmpl != nil {
-		err = seasonTmpl.Execute(seasonPart, info)
+		err = matcher.ExecuteTemplate(seasonTmpl, seasonPart, m.Provider, info)
 		if err != nil {
 			return "", fmt.Errorf("Can't use this season template: %w", err)
 		}
@@ -95,10 +98,90 @@ func MediaPath(showPath string, m *matcher.MatchRequest, info *nfo.MediaInfo) (s
 		showTmpl = m.ShowNameTemplate.T
 	}
 
-	err = showTmpl.Execute(showPart, info)
+	err = matcher.ExecuteTemplate(showTmpl, showPart, m.Provider, info)
 	if err != nil {
 		return "", fmt.Errorf("Can't use this name template: %w", err)
 	}
 
 	return filepath.Join(seasonPart, FileNameCleaner(showPart.String())), nil
 }
+
+// Values given to templates to find where fields are placed in file names
+const (
+	showSentinel    = "SHOWXQZ"
+	titleSentinel   = "TITLEXQZ"
+	seasonSentinel  = 4111
+	episodeSentinel = 4222
+)
+
+var airedSentinel = time.Date(1903, 4, 5, 0, 0, 0, 0, time.UTC)
+
+// MediaNameMatcher returns a regular expression matching file names given by the name template of
+// the match request, or by the default template of the type of show. Named groups are show, title,
+// season, episode, aired and year.
+func MediaNameMatcher(m *matcher.MatchRequest, t nfo.ShowType) (*regexp.Regexp, error) {
+	showTmpl := showNameTemplates[t]
+	if m != nil && m.ShowNameTemplate.T != nil {
+		showTmpl = m.ShowNameTemplate.T
+	}
+	if showTmpl == nil {
+		return nil, fmt.Errorf("No name template for this type of show")
+	}
+	info := &nfo.MediaInfo{
+		Showtitle: showSentinel,
+		Title:     titleSentinel,
+		Season:    seasonSentinel,
+		Episode:   episodeSentinel,
+		Aired:     nfo.Aired(airedSentinel),
+		MediaType: t,
+	}
+	provider := ""
+	if m != nil {
+		provider = m.Provider
+	}
+	name := &strings.Builder{}
+	err := matcher.ExecuteTemplate(showTmpl, name, provider, info)
+	if err != nil {
+		return nil, fmt.Errorf("Can't use this name template: %w", err)
+	}
+	re := regexp.QuoteMeta(FileNameCleaner(name.String()))
+	// Sentinels may have changed case with template functions
+	for _, r := range []struct{ sentinel, group string }{
+		{showSentinel, `(?P<show>.+?)`},
+		{titleSentinel, `(?P<title>.+?)`},
+		{strconv.Itoa(seasonSentinel), `(?P<season>\d+)`},
+		{strconv.Itoa(episodeSentinel), `(?P<episode>\d+)`},
+		{airedSentinel.Format("2006-01-02"), `(?P<aired>\d{4}-\d{2}-\d{2})`},
+		{airedSentinel.Format("2006"), `(?P<year>\d{4})`},
+	} {
+		re = regexp.MustCompile("(?i)"+r.sentinel).ReplaceAllLiteralString(re, r.group)
+	}
+	return regexp.Compile("^" + re + "$")
+}
+
+// ParseMediaName returns metadata found in the file name of a media named by the template re.
+// It returns nil when the name doesn't match.
+func ParseMediaName(re *regexp.Regexp, name string) *nfo.MediaInfo {
+	m := re.FindStringSubmatch(name)
+	if m == nil {
+		return nil
+	}
+	info := &nfo.MediaInfo{}
+	for i, g := range re.SubexpNames() {
+		switch g {
+		case "show":
+			info.Showtitle = m[i]
+		case "title":
+			info.Title = m[i]
+		case "season":
+			info.Season, _ = strconv.Atoi(m[i])
+		case "episode":
+			info.Episode, _ = strconv.Atoi(m[i])
+		case "aired":
+			if t, err := time.Parse("2006-01-02", m[i]); err == nil {
+				info.Aired = nfo.Aired(t)
+			}
+		}
+	}
+	return info
+}
@@ -0,0 +1,54 @@
+package download
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simulot/aspiratv/matcher"
+	"github.com/simulot/aspiratv/metadata/nfo"
+)
+
+func TestParseMediaName(t *testing.T) {
+	custom := &matcher.MatchRequest{}
+	err := custom.ShowNameTemplate.Set(`{{.Showtitle}} {{.Aired.Time.Format "2006-01-02"}} - E{{.Episode}}.mp4`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slug := &matcher.MatchRequest{}
+	err = slug.ShowNameTemplate.Set(`{{.Showtitle | slug}}-s{{.Season | pad 2}}e{{.Episode | pad 2}}.mp4`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		m    *matcher.MatchRequest
+		t    nfo.ShowType
+		file string
+		want *nfo.MediaInfo
+	}{
+		{"series", nil, nfo.TypeSeries, "Les Lapins Crétins - s01e12 - Lapin bricoleur.mp4", &nfo.MediaInfo{Showtitle: "Les Lapins Crétins", Title: "Lapin bricoleur", Season: 1, Episode: 12}},
+		{"show", nil, nfo.TypeShow, "C dans l'air - 2020-05-12 - Le déconfinement.mp4", &nfo.MediaInfo{Showtitle: "C dans l'air", Title: "Le déconfinement", Aired: nfo.Aired(time.Date(2020, 5, 12, 0, 0, 0, 0, time.UTC))}},
+		{"movie", nil, nfo.TypeMovie, "Le Dîner de cons.mp4", &nfo.MediaInfo{Title: "Le Dîner de cons"}},
+		{"custom", custom, nfo.TypeSeries, "Tintin 1991-09-02 - E3.mp4", &nfo.MediaInfo{Showtitle: "Tintin", Episode: 3, Aired: nfo.Aired(time.Date(1991, 9, 2, 0, 0, 0, 0, time.UTC))}},
+		{"template functions", slug, nfo.TypeSeries, "les-lapins-cretins-s01e12.mp4", &nfo.MediaInfo{Showtitle: "les-lapins-cretins", Season: 1, Episode: 12}},
+		{"not matching", nil, nfo.TypeSeries, "Lapin bricoleur.mp4", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re, err := MediaNameMatcher(tt.m, tt.t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ParseMediaName(re, tt.file)
+			if (got == nil) != (tt.want == nil) {
+				t.Fatalf("ParseMediaName() = %+v, want %+v", got, tt.want)
+			}
+			if got == nil {
+				return
+			}
+			if got.Showtitle != tt.want.Showtitle || got.Title != tt.want.Title || got.Season != tt.want.Season || got.Episode != tt.want.Episode || !got.Aired.Time().Equal(tt.want.Aired.Time()) {
+				t.Errorf("ParseMediaName() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
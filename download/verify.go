@@ -0,0 +1,161 @@
+package download
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrVerification is returned when the downloaded file doesn't have the expected streams or duration.
+// The file is likely truncated, downloading it again may succeed.
+var ErrVerification = errors.New("verification failed")
+
+// Expected describes the file that should be downloaded
+type Expected struct {
+	Duration  time.Duration // Zero when unknown
+	Tolerance time.Duration // Accepted difference of durations
+	Video     int           // Minimum number of video streams
+	Audio     int           // Minimum number of audio streams
+	Subtitles int           // Minimum number of subtitle streams
+	Height    int           // Minimum height of the video, zero when unknown
+}
+
+// Probe describes a file, as seen by ffprobe
+type Probe struct {
+	Duration  time.Duration
+	Video     int // Number of video streams
+	Audio     int // Number of audio streams
+	Subtitles int // Number of subtitle streams
+	Width     int // Size of the first video stream
+	Height    int
+}
+
+// ProbeFile runs ffprobe on the file
+func ProbeFile(ctx context.Context, path string) (*Probe, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path)
+	b, err := cmd.Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && len(ee.Stderr) > 0 {
+			err = fmt.Errorf("%s", strings.TrimSpace(string(ee.Stderr)))
+		}
+		return nil, fmt.Errorf("Can't probe %s: %w", path, err)
+	}
+	p, err := parseProbe(b)
+	if err != nil {
+		return nil, fmt.Errorf("Can't probe %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// parseProbe decodes the JSON output of ffprobe
+func parseProbe(b []byte) (*Probe, error) {
+	var out struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			Width     int    `json:"width"`
+			Height    int    `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	err := json.Unmarshal(b, &out)
+	if err != nil {
+		return nil, err
+	}
+	p := &Probe{}
+	if out.Format.Duration != "" {
+		s, err := strconv.ParseFloat(out.Format.Duration, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %q", out.Format.Duration)
+		}
+		p.Duration = time.Duration(s * float64(time.Second))
+	}
+	for _, s := range out.Streams {
+		switch s.CodecType {
+		case "video":
+			if p.Video == 0 {
+				p.Width, p.Height = s.Width, s.Height
+			}
+			p.Video++
+		case "audio":
+			p.Audio++
+		case "subtitle":
+			p.Subtitles++
+		}
+	}
+	return p, nil
+}
+
+// Check returns the failed checks of the probed file, nil when the file is as expected
+func (e Expected) Check(p *Probe) []string {
+	failures := []string{}
+	if e.Duration > 0 {
+		d := p.Duration - e.Duration
+		if d < 0 {
+			d = -d
+		}
+		if d > e.Tolerance {
+			failures = append(failures, fmt.Sprintf("duration %s instead of %s", p.Duration.Round(time.Second), e.Duration.Round(time.Second)))
+		}
+	}
+	streams := func(kind string, got, want int) {
+		if got < want {
+			failures = append(failures, fmt.Sprintf("%d %s streams instead of %d", got, kind, want))
+		}
+	}
+	streams("video", p.Video, e.Video)
+	streams("audio", p.Audio, e.Audio)
+	streams("subtitle", p.Subtitles, e.Subtitles)
+	if e.Height > 0 && p.Video > 0 && p.Height < e.Height {
+		failures = append(failures, fmt.Sprintf("resolution %dx%d instead of a height of %d", p.Width, p.Height, e.Height))
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	return failures
+}
+
+// verify probes the downloaded file, and returns an error wrapping ErrVerification when checks fail
+func (c *downloadConfiguration) verify(ctx context.Context, out string, e Expected) error {
+	if !c.verification {
+		return nil
+	}
+	c.stage("verifying...")
+	p, err := ProbeFile(ctx, out)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: %s", ErrVerification, err)
+	}
+	if failures := e.Check(p); failures != nil {
+		return fmt.Errorf("%w: %s: %s", ErrVerification, out, strings.Join(failures, ", "))
+	}
+	c.logger.Trace().Printf("%s verified: %s, %d video, %d audio, %d subtitle streams, %dx%d", out, p.Duration.Round(time.Second), p.Video, p.Audio, p.Subtitles, p.Width, p.Height)
+	return nil
+}
+
+// runtimeTolerance returns the accepted difference with a duration given in minutes by the provider
+func runtimeTolerance(d time.Duration) time.Duration {
+	t := d / 10
+	if t < 2*time.Minute {
+		t = 2 * time.Minute
+	}
+	return t
+}
+
+// manifestTolerance returns the accepted difference with the duration given by a manifest
+func manifestTolerance(d time.Duration) time.Duration {
+	t := d / 100
+	if t < 5*time.Second {
+		t = 5 * time.Second
+	}
+	return t
+}
@@ -0,0 +1,42 @@
+package download
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	p, err := parseProbe([]byte(`{
+		"streams": [
+			{"index": 0, "codec_type": "video", "width": 1280, "height": 720},
+			{"index": 1, "codec_type": "audio"},
+			{"index": 2, "codec_type": "subtitle"}
+		],
+		"format": {"duration": "3115.520000"}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Video != 1 || p.Audio != 1 || p.Subtitles != 1 || p.Height != 720 || p.Duration.Round(time.Second) != 3116*time.Second {
+		t.Fatalf("Unexpected probe: %+v", p)
+	}
+
+	tests := []struct {
+		name     string
+		expected Expected
+		failures int
+	}{
+		{"as expected", Expected{Duration: 3118 * time.Second, Tolerance: manifestTolerance(3118 * time.Second), Video: 1, Audio: 1, Subtitles: 1, Height: 720}, 0},
+		{"provider's duration", Expected{Duration: 52 * time.Minute, Tolerance: runtimeTolerance(52 * time.Minute), Video: 1, Audio: 1}, 0},
+		{"truncated", Expected{Duration: 90 * time.Minute, Tolerance: manifestTolerance(90 * time.Minute), Video: 1, Audio: 1}, 1},
+		{"missing audio", Expected{Video: 1, Audio: 2}, 1},
+		{"lower resolution", Expected{Video: 1, Height: 1080}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.expected.Check(p); len(got) != tt.failures {
+				t.Errorf("Check() = %q, want %d failures", got, tt.failures)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package events
+
+import (
+	"sync"
+)
+
+// Media identifies the media concerned by an event
+type Media struct {
+	Provider string // Provider's name
+	ID       string // Media ID given by the provider
+	Show     string // Show title
+	Title    string // Episode or movie title
+	Path     string // Path of the mp4 file
+}
+
+// Subject returns the media concerned by the event.
+// All events embed Media and then implement the Event interface.
+func (m Media) Subject() Media {
+	return m
+}
+
+// Event is the interface implemented by all events published on the bus
+type Event interface {
+	Subject() Media
+}
+
+// MediaDiscovered is published when a provider lists a new media matching the watch list
+type MediaDiscovered struct {
+	Media
+}
+
+// MediaSkipped is published when a media isn't downloaded
+type MediaSkipped struct {
+	Media
+	Reason string // Why the media is skipped
+}
+
+// MediaRenamed is published when the files of a media downloaded under another name are renamed,
+// because the provider has renumbered or retitled it. Path is the new path of the video.
+type MediaRenamed struct {
+	Media
+	From string // Previous path of the video
+}
+
+// DownloadStarted is published when a worker starts the download of a media
+type DownloadStarted struct {
+	Media
+	JobID int
+}
+
+// StageChanged is published when the download enters in a new stage
+type StageChanged struct {
+	Media
+	Stage string
+}
+
+// Progress is published during the download
+type Progress struct {
+	Media
+	Current int64 // Bytes downloaded so far
+	Total   int64 // Estimated size of the media
+}
+
+// DownloadFailed is published when the download ends with an error
+type DownloadFailed struct {
+	Media
+	Err error
+}
+
+// DownloadCompleted is published when the media is successfully downloaded
+type DownloadCompleted struct {
+	Media
+}
+
+// NFOWritten is published for each metadata file written for the media
+type NFOWritten struct {
+	Media
+	NFOPath string
+}
+
+// Handler is called for each event published on the bus.
+// Handlers are called synchronously from publisher's goroutine, they must not block.
+type Handler func(Event)
+
+// Bus dispatches events to its subscribers
+type Bus struct {
+	mu       sync.RWMutex
+	next     int
+	handlers map[int]Handler
+}
+
+// NewBus creates an event bus without subscribers
+func NewBus() *Bus {
+	return &Bus{
+		handlers: map[int]Handler{},
+	}
+}
+
+// Subscribe registers the handler and returns the function to call for unsubscribing
+func (b *Bus) Subscribe(h Handler) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.handlers[id] = h
+	return func() {
+		b.mu.Lock()
+		delete(b.handlers, id)
+		b.mu.Unlock()
+	}
+}
+
+// Publish sends the event to all subscribers. A nil bus discards the event.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, h := range b.handlers {
+		h(e)
+	}
+}
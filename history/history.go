@@ -0,0 +1,265 @@
+// Package history keeps the list of downloaded medias on disk, so a media is never
+// fetched again, even when its file has been moved or deleted. The history is seeded
+// with existing libraries by the library import command.
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/simulot/aspiratv/metadata/nfo"
+)
+
+// Sources of entries
+const (
+	SourceDownload = "download" // Downloaded by aspiratv
+	SourceImport   = "import"   // Imported from an existing library
+)
+
+// Entry is a media of the history
+type Entry struct {
+	IDs     []string `json:",omitempty"` // Provider's identifiers, as TYPE:ID from uniqueid
+	Show    string   `json:",omitempty"`
+	Title   string   `json:",omitempty"`
+	Season  int      `json:",omitempty"`
+	Episode int      `json:",omitempty"`
+	Aired   time.Time
+	Path    string `json:",omitempty"` // Path of the video when added
+	Source  string
+	Added   time.Time
+}
+
+// NewEntry returns the entry of the media
+func NewEntry(info *nfo.MediaInfo, path string, source string) Entry {
+	e := Entry{
+		Show:    info.Showtitle,
+		Title:   info.Title,
+		Season:  info.Season,
+		Episode: info.Episode,
+		Aired:   info.Aired.Time(),
+		Path:    path,
+		Source:  source,
+		Added:   time.Now(),
+	}
+	for _, id := range info.UniqueID {
+		if id.ID != "" {
+			e.IDs = append(e.IDs, id.Type+":"+id.ID)
+		}
+	}
+	return e
+}
+
+// keys identify the entry: by provider's identifiers, then by episode number, by broadcast
+// date and title, or by title for medias without number nor date
+func (e *Entry) keys() []string {
+	keys := []string{}
+	for _, id := range e.IDs {
+		keys = append(keys, "id:"+id)
+	}
+	show := nfo.Normalize(e.Show)
+	switch {
+	case e.Season > 0 && e.Episode > 0:
+		keys = append(keys, "episode:"+show+"/s"+strconv.Itoa(e.Season)+"e"+strconv.Itoa(e.Episode))
+	case !e.Aired.IsZero() && e.Title != "":
+		keys = append(keys, "aired:"+show+"/"+e.Aired.Format("2006-01-02")+"/"+nfo.Normalize(e.Title))
+	case e.Title != "":
+		keys = append(keys, "title:"+show+"/"+nfo.Normalize(e.Title))
+	}
+	return keys
+}
+
+// Store is the history persisted in a JSON file
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	entries []*Entry
+	index   map[string]*Entry
+}
+
+// Open reads the history file. A missing file gives an empty history.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:  path,
+		index: map[string]*Entry{},
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Can't read history: %w", err)
+	}
+	entries := []*Entry{}
+	err = json.Unmarshal(b, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("Can't decode history %s: %w", path, err)
+	}
+	for _, e := range entries {
+		s.add(e)
+	}
+	return s, nil
+}
+
+// Path returns the file of the history
+func (s *Store) Path() string {
+	return s.path
+}
+
+// otherID tells if both entries have an identifier of the same provider with different values,
+// so they are different medias, even when they share a number, a date or a title
+func (e *Entry) otherID(o *Entry) bool {
+	for _, a := range e.IDs {
+		for _, b := range o.IDs {
+			if idType(a) == idType(b) && a != b {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// idType returns the provider of an identifier given as TYPE:ID
+func idType(id string) string {
+	if i := strings.Index(id, ":"); i >= 0 {
+		return id[:i]
+	}
+	return ""
+}
+
+// lookup returns the entry of the media. Provider's identifiers decide when both medias have one
+// of the same provider: other keys only match entries without comparable identifier. s.mu must be held.
+func (s *Store) lookup(e *Entry) *Entry {
+	for _, k := range e.keys() {
+		if found, ok := s.index[k]; ok && (strings.HasPrefix(k, "id:") || !found.otherID(e)) {
+			return found
+		}
+	}
+	return nil
+}
+
+// add indexes the entry. It returns false when the media is already known. s.mu must be held.
+func (s *Store) add(e *Entry) bool {
+	if s.lookup(e) != nil {
+		return false
+	}
+	for _, k := range e.keys() {
+		if _, ok := s.index[k]; !ok {
+			s.index[k] = e
+		}
+	}
+	s.entries = append(s.entries, e)
+	return true
+}
+
+// Add records medias in the history. Medias already known are ignored.
+// It returns the number of added medias.
+func (s *Store) Add(entries ...Entry) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for i := range entries {
+		e := entries[i]
+		if s.add(&e) {
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.save()
+}
+
+// Update records the new name of a media renamed by its provider: the entry takes the title,
+// the season, the episode and the identifiers of info, and the new path of the video.
+func (s *Store) Update(e *Entry, info *nfo.MediaInfo, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range e.keys() {
+		if s.index[k] == e {
+			delete(s.index, k)
+		}
+	}
+	n := NewEntry(info, path, e.Source)
+	for _, id := range e.IDs {
+		if !contains(n.IDs, id) {
+			n.IDs = append(n.IDs, id)
+		}
+	}
+	e.IDs, e.Show, e.Title, e.Season, e.Episode, e.Aired, e.Path = n.IDs, n.Show, n.Title, n.Season, n.Episode, n.Aired, n.Path
+	for _, k := range e.keys() {
+		if _, ok := s.index[k]; !ok {
+			s.index[k] = e
+		}
+	}
+	return s.save()
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Find returns the entry of the media, nil when the media isn't in the history
+func (s *Store) Find(info *nfo.MediaInfo) *Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := NewEntry(info, "", "")
+	return s.lookup(&e)
+}
+
+// Entries returns a copy of the entries of the history, in the order they were added
+func (s *Store) Entries() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]Entry, len(s.entries))
+	for i, e := range s.entries {
+		entries[i] = *e
+	}
+	return entries
+}
+
+// save writes the history. The file is replaced only once the new content is completely written.
+// s.mu must be held.
+func (s *Store) save() error {
+	entries := append([]*Entry{}, s.entries...)
+	sort.SliceStable(entries, func(a, b int) bool {
+		return entries[a].Added.Before(entries[b].Added)
+	})
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Can't encode history: %w", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(s.path), 0777)
+	if err != nil {
+		return fmt.Errorf("Can't write history: %w", err)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(s.path), "."+filepath.Base(s.path)+"-")
+	if err != nil {
+		return fmt.Errorf("Can't write history: %w", err)
+	}
+	_, err = f.Write(b)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("Can't write history: %w", err)
+	}
+	return nil
+}
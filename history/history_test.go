@@ -0,0 +1,93 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/simulot/aspiratv/metadata/nfo"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aired := nfo.Aired(time.Date(2020, 5, 12, 0, 0, 0, 0, time.UTC))
+	episode := &nfo.MediaInfo{Showtitle: "Les Lapins Crétins", Title: "Lapin bricoleur", Season: 1, Episode: 2, UniqueID: []nfo.ID{{Type: "francetv", ID: "1234"}}}
+	show := &nfo.MediaInfo{Showtitle: "C dans l'air", Title: "Émission du 12 mai", Aired: aired}
+
+	n, err := s.Add(NewEntry(episode, "ep.mp4", SourceDownload), NewEntry(show, "show.mp4", SourceImport), NewEntry(episode, "copy.mp4", SourceImport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("Add() = %d, want 2", n)
+	}
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		info  *nfo.MediaInfo
+		found bool
+	}{
+		{"same id", &nfo.MediaInfo{Title: "Autre titre", UniqueID: []nfo.ID{{Type: "francetv", ID: "1234"}}}, true},
+		{"same episode number", &nfo.MediaInfo{Showtitle: "les lapins cretins", Title: "Autre titre", Season: 1, Episode: 2}, true},
+		{"same date and title", &nfo.MediaInfo{Showtitle: "C dans l'air", Title: "Emission du 12 mai", Aired: aired}, true},
+		{"other id, same episode number", &nfo.MediaInfo{Showtitle: "Les Lapins Crétins", Title: "Lapin bricoleur", Season: 1, Episode: 2, UniqueID: []nfo.ID{{Type: "francetv", ID: "5678"}}}, false},
+		{"id of another provider, same episode number", &nfo.MediaInfo{Showtitle: "Les Lapins Crétins", Title: "Autre titre", Season: 1, Episode: 2, UniqueID: []nfo.ID{{Type: "artetv", ID: "5678"}}}, true},
+		{"other episode", &nfo.MediaInfo{Showtitle: "Les Lapins Crétins", Title: "Lapin bricoleur", Season: 1, Episode: 3}, false},
+		{"other date", &nfo.MediaInfo{Showtitle: "C dans l'air", Title: "Émission du 12 mai", Aired: nfo.Aired(time.Date(2021, 5, 12, 0, 0, 0, 0, time.UTC))}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.Find(tt.info) != nil; got != tt.found {
+				t.Errorf("Find() = %v, want %v", got, tt.found)
+			}
+		})
+	}
+	// An episode taking the number of a renumbered one is another media
+	n, err = s.Add(NewEntry(tests[3].info, "other.mp4", SourceDownload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Add() = %d, want 1", n)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	episode := &nfo.MediaInfo{Showtitle: "Les Lapins Crétins", Title: "Lapin bricoleur", Season: 1, Episode: 2, UniqueID: []nfo.ID{{Type: "francetv", ID: "1234"}}}
+	_, err = s.Add(NewEntry(episode, "ep2.mp4", SourceDownload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed := &nfo.MediaInfo{Showtitle: "Les Lapins Crétins", Title: "Lapin bricoleur", Season: 1, Episode: 5}
+	err = s.Update(s.Find(episode), renamed, "ep5.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := s.Find(renamed); e == nil || e.Path != "ep5.mp4" || e.Source != SourceDownload {
+		t.Errorf("Find(renamed) = %+v, want the updated entry", e)
+	}
+	if e := s.Find(&nfo.MediaInfo{UniqueID: episode.UniqueID}); e == nil {
+		t.Errorf("Entry lost its identifiers")
+	}
+	if e := s.Find(&nfo.MediaInfo{Showtitle: "Les Lapins Crétins", Season: 1, Episode: 2}); e != nil {
+		t.Errorf("Old episode number still found")
+	}
+}
@@ -0,0 +1,356 @@
+package library
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/simulot/aspiratv/metadata/nfo"
+)
+
+// Kinds of problems found by Check
+const (
+	ProblemOrphan     = "orphan"     // Metadata file or thumbnail without video
+	ProblemNoNFO      = "no-nfo"     // Video without metadata file
+	ProblemEmpty      = "empty"      // Zero-length video
+	ProblemUnreadable = "unreadable" // Video that can't be probed, or metadata file that can't be decoded
+	ProblemTemporary  = "temporary"  // Stream left by an interrupted DASH download
+	ProblemTVShow     = "tvshow"     // tvshow.nfo missing or not matching episodes
+	ProblemSeason     = "season"     // season.nfo not matching episodes
+)
+
+// Problem found in the library
+type Problem struct {
+	Kind   string
+	Path   string
+	Detail string
+	fix    func() error // nil when the problem can't be fixed automatically
+}
+
+func (p Problem) String() string {
+	s := p.Kind + ": " + p.Path
+	if p.Detail != "" {
+		s += ": " + p.Detail
+	}
+	return s
+}
+
+// Fixable tells if Fix can solve the problem
+func (p Problem) Fixable() bool {
+	return p.fix != nil
+}
+
+// Fix solves the problem: leftover files are removed, broken videos are removed with their metadata
+// and thumbnails so they are downloaded again, missing or wrong show and season files are written.
+func (p Problem) Fix() error {
+	if p.fix == nil {
+		return fmt.Errorf("Can't fix %s", p)
+	}
+	return p.fix()
+}
+
+// ProbeFunc returns an error when the video can't be read
+type ProbeFunc func(ctx context.Context, path string) error
+
+type checkConfig struct {
+	probe ProbeFunc
+}
+
+// CheckOption configures Check
+type CheckOption func(c *checkConfig)
+
+// WithProbe checks that videos can be read with the probe function
+func WithProbe(probe ProbeFunc) CheckOption {
+	return func(c *checkConfig) {
+		c.probe = probe
+	}
+}
+
+// Thumbnails are named after the video or after their aspect for shows and seasons: base_N.ext
+var thumbRe = regexp.MustCompile(`^(.*)_\d+\.(png|jpeg|jpg|gif)$`)
+
+// Aspects of show and season images: poster_1.png, fanart_2.png...
+var aspectRe = regexp.MustCompile(`^[a-z]+$`)
+
+// Check walks the roots and returns problems sorted by path. Missing roots are ignored.
+func Check(ctx context.Context, roots []Root, opts ...CheckOption) ([]Problem, error) {
+	c := checkConfig{}
+	for _, o := range opts {
+		o(&c)
+	}
+
+	problems := []Problem{}
+	dirs := map[string]map[string]os.FileInfo{}
+	for _, root := range roots {
+		err := filepath.Walk(root.Path, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && path == root.Path {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if fi.IsDir() {
+				return nil
+			}
+			dir := filepath.Dir(path)
+			if dirs[dir] == nil {
+				dirs[dir] = map[string]os.FileInfo{}
+			}
+			dirs[dir][fi.Name()] = fi
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Can't check library: %w", err)
+		}
+	}
+
+	for dir, files := range dirs {
+		problems = append(problems, c.checkFiles(ctx, dir, files)...)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	items, err := Scan(roots)
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range roots {
+		for _, show := range showDirs(root, dirs) {
+			problems = append(problems, checkShow(show, episodesIn(items, show, true))...)
+		}
+	}
+	for dir, files := range dirs {
+		if _, ok := files["season.nfo"]; ok {
+			problems = append(problems, checkSeason(dir, episodesIn(items, dir, false))...)
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Path != problems[j].Path {
+			return problems[i].Path < problems[j].Path
+		}
+		return problems[i].Kind < problems[j].Kind
+	})
+	return problems, nil
+}
+
+// checkFiles checks files of a directory
+func (c *checkConfig) checkFiles(ctx context.Context, dir string, files map[string]os.FileInfo) []Problem {
+	problems := []Problem{}
+	videos := map[string]bool{}
+	for name := range files {
+		if IsVideo(name) {
+			videos[strings.TrimSuffix(name, filepath.Ext(name))] = true
+		}
+	}
+	_, hasShow := files["tvshow.nfo"]
+	_, hasSeason := files["season.nfo"]
+
+	for name, fi := range files {
+		path := filepath.Join(dir, name)
+		base := strings.TrimSuffix(name, filepath.Ext(name))
+		switch {
+		case IsTemporary(name):
+			problems = append(problems, Problem{Kind: ProblemTemporary, Path: path, fix: remove(path)})
+
+		case IsVideo(name):
+			videoBase := filepath.Join(dir, base)
+			if fi.Size() == 0 {
+				problems = append(problems, Problem{Kind: ProblemEmpty, Path: path, fix: removeMedia(path)})
+				continue
+			}
+			if c.probe != nil && ctx.Err() == nil {
+				if err := c.probe(ctx, path); err != nil && ctx.Err() == nil {
+					problems = append(problems, Problem{Kind: ProblemUnreadable, Path: path, Detail: err.Error(), fix: removeMedia(path)})
+					continue
+				}
+			}
+			if _, ok := files[base+".nfo"]; !ok {
+				problems = append(problems, Problem{Kind: ProblemNoNFO, Path: path})
+			} else if _, _, err := ReadNFO(videoBase + ".nfo"); err != nil {
+				problems = append(problems, Problem{Kind: ProblemUnreadable, Path: videoBase + ".nfo", Detail: err.Error()})
+			}
+
+		case strings.ToLower(filepath.Ext(name)) == ".nfo":
+			if name == "tvshow.nfo" || name == "season.nfo" || videos[base] {
+				continue
+			}
+			problems = append(problems, Problem{Kind: ProblemOrphan, Path: path, Detail: "metadata without video", fix: remove(path)})
+
+		default:
+			m := thumbRe.FindStringSubmatch(name)
+			if m == nil || videos[m[1]] {
+				continue
+			}
+			if (hasShow || hasSeason) && aspectRe.MatchString(m[1]) {
+				// Image of the show or of the season
+				continue
+			}
+			problems = append(problems, Problem{Kind: ProblemOrphan, Path: path, Detail: "thumbnail without video", fix: remove(path)})
+		}
+	}
+	return problems
+}
+
+// showDirs returns directories of shows of the root: the root itself when it is the path of a show,
+// its sub-directories when it is a destination
+func showDirs(root Root, dirs map[string]map[string]os.FileInfo) []string {
+	if root.Show {
+		return []string{root.Path}
+	}
+	shows := map[string]bool{}
+	for dir := range dirs {
+		rel, err := filepath.Rel(root.Path, dir)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		shows[filepath.Join(root.Path, strings.Split(filepath.ToSlash(rel), "/")[0])] = true
+	}
+	l := []string{}
+	for s := range shows {
+		l = append(l, s)
+	}
+	return l
+}
+
+// episodesIn returns episodes in the directory, and in its sub-directories when deep is true
+func episodesIn(items []Item, dir string, deep bool) []Item {
+	episodes := []Item{}
+	for _, i := range items {
+		if i.Kind != "episode" {
+			continue
+		}
+		d := filepath.Dir(i.Path)
+		if d == dir || deep && strings.HasPrefix(d, dir+string(filepath.Separator)) {
+			episodes = append(episodes, i)
+		}
+	}
+	return episodes
+}
+
+// checkShow checks tvshow.nfo of a show directory
+func checkShow(dir string, episodes []Item) []Problem {
+	if len(episodes) == 0 {
+		return nil
+	}
+	path := filepath.Join(dir, "tvshow.nfo")
+	title := mostCommon(episodes, func(i Item) string { return i.Info.Showtitle })
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		p := Problem{Kind: ProblemTVShow, Path: path, Detail: "missing"}
+		if title != "" {
+			p.fix = func() error {
+				return (&nfo.TVShow{Title: title}).WriteNFO(path)
+			}
+		}
+		return []Problem{p}
+	}
+	show := nfo.TVShow{}
+	if err == nil {
+		err = xml.Unmarshal(b, &show)
+	}
+	if err != nil {
+		return []Problem{{Kind: ProblemUnreadable, Path: path, Detail: err.Error()}}
+	}
+	if title != "" && nfo.Normalize(show.Title) != nfo.Normalize(title) {
+		return []Problem{{Kind: ProblemTVShow, Path: path, Detail: fmt.Sprintf("title %q, episodes of %q", show.Title, title)}}
+	}
+	return nil
+}
+
+// checkSeason checks season.nfo of a directory
+func checkSeason(dir string, episodes []Item) []Problem {
+	path := filepath.Join(dir, "season.nfo")
+	b, err := ioutil.ReadFile(path)
+	season := nfo.Season{}
+	if err == nil {
+		err = xml.Unmarshal(b, &season)
+	}
+	if err != nil {
+		return []Problem{{Kind: ProblemUnreadable, Path: path, Detail: err.Error()}}
+	}
+	if len(episodes) == 0 {
+		return []Problem{{Kind: ProblemSeason, Path: path, Detail: "no episode in the directory"}}
+	}
+	number := mostCommon(episodes, func(i Item) string {
+		if i.Info.Season == 0 {
+			return ""
+		}
+		return strconv.Itoa(i.Info.Season)
+	})
+	if number == "" || season.Seasonnumber == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(season.Seasonnumber); err != nil || strconv.Itoa(n) != number {
+		return []Problem{{
+			Kind:   ProblemSeason,
+			Path:   path,
+			Detail: fmt.Sprintf("season %s, episodes of season %s", season.Seasonnumber, number),
+			fix: func() error {
+				season.Seasonnumber = number
+				return season.WriteNFO(path)
+			},
+		}}
+	}
+	return nil
+}
+
+// mostCommon returns the most common non empty value of items
+func mostCommon(items []Item, value func(i Item) string) string {
+	count := map[string]int{}
+	best := ""
+	for _, i := range items {
+		v := value(i)
+		if v == "" {
+			continue
+		}
+		count[v]++
+		if count[v] > count[best] || count[v] == count[best] && v < best {
+			best = v
+		}
+	}
+	return best
+}
+
+// remove returns a function removing the file
+func remove(path string) func() error {
+	return func() error {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Can't remove %s: %w", path, err)
+		}
+		return nil
+	}
+}
+
+// removeMedia returns a function removing the video, its metadata file and its thumbnails
+func removeMedia(path string) func() error {
+	return func() error {
+		base := strings.TrimSuffix(path, filepath.Ext(path))
+		files, err := filepath.Glob(escapeGlob(base) + "*")
+		if err != nil {
+			return fmt.Errorf("Can't remove %s: %w", path, err)
+		}
+		for _, f := range files {
+			if !companionRe.MatchString(strings.TrimPrefix(f, base)) {
+				continue
+			}
+			err = remove(f)()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,90 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/simulot/aspiratv/metadata/nfo"
+)
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	show := filepath.Join(dir, "Les Lapins Crétins")
+	season := filepath.Join(show, "Season 01")
+	ep := filepath.Join(season, "Les Lapins Crétins - s01e02 - Lapin bricoleur")
+	writeFile(t, ep+".mp4", "video")
+	writeFile(t, ep+"_1.png", "image")
+	err := (&nfo.EpisodeDetails{MediaInfo: nfo.MediaInfo{Showtitle: "Les Lapins Crétins", Title: "Lapin bricoleur", Season: 1, Episode: 2}}).WriteNFO(ep + ".nfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = (&nfo.Season{Seasonnumber: "2"}).WriteNFO(filepath.Join(season, "season.nfo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(season, "poster_1.png"), "season's poster")
+
+	gone := filepath.Join(season, "Les Lapins Crétins - s01e01 - Disparu")
+	writeFile(t, gone+".nfo", "<episodedetails></episodedetails>")
+	writeFile(t, gone+"_1.png", "image")
+	writeFile(t, filepath.Join(season, "Les Lapins Crétins - s01e03 - Sans nfo.mp4"), "video")
+	empty := filepath.Join(season, "Les Lapins Crétins - s01e04 - Vide")
+	writeFile(t, empty+".mp4", "")
+	writeFile(t, empty+".nfo", "<episodedetails></episodedetails>")
+	writeFile(t, filepath.Join(season, "Les Lapins Crétins - s01e05 - Cassé.mp4"), "broken")
+	writeFile(t, ep+".mp4.audio-fr.mp4", "temporary stream")
+
+	probe := func(ctx context.Context, path string) error {
+		if strings.Contains(path, "Cassé") {
+			return errors.New("invalid data")
+		}
+		return nil
+	}
+	roots := []Root{{Name: "Jeunesse", Path: dir}}
+	problems, err := Check(ctx, roots, WithProbe(probe))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, p := range problems {
+		rel, _ := filepath.Rel(dir, p.Path)
+		got = append(got, p.Kind+" "+filepath.ToSlash(rel))
+	}
+	want := []string{
+		"orphan Les Lapins Crétins/Season 01/Les Lapins Crétins - s01e01 - Disparu.nfo",
+		"orphan Les Lapins Crétins/Season 01/Les Lapins Crétins - s01e01 - Disparu_1.png",
+		"temporary Les Lapins Crétins/Season 01/Les Lapins Crétins - s01e02 - Lapin bricoleur.mp4.audio-fr.mp4",
+		"no-nfo Les Lapins Crétins/Season 01/Les Lapins Crétins - s01e03 - Sans nfo.mp4",
+		"empty Les Lapins Crétins/Season 01/Les Lapins Crétins - s01e04 - Vide.mp4",
+		"unreadable Les Lapins Crétins/Season 01/Les Lapins Crétins - s01e05 - Cassé.mp4",
+		"season Les Lapins Crétins/Season 01/season.nfo",
+		"tvshow Les Lapins Crétins/tvshow.nfo",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("Check() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	for _, p := range problems {
+		if p.Fixable() {
+			err = p.Fix()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := os.Stat(empty + ".nfo"); !os.IsNotExist(err) {
+		t.Errorf("Metadata of the empty video not removed")
+	}
+	problems, err = Check(ctx, roots, WithProbe(probe))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0].Kind != ProblemNoNFO {
+		t.Errorf("Unexpected problems after fix: %v", problems)
+	}
+}
@@ -0,0 +1,249 @@
+package library
+
+import (
+	"encoding/xml"
+	"io"
+	"mime"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Feed lists videos of a show or of a destination, the newest first
+type Feed struct {
+	ID          string // Identifier of the feed, also used as file name
+	Title       string
+	Description string
+	Items       []Item
+}
+
+// URLFunc returns the URL of a file of the library
+type URLFunc func(path string) string
+
+// ShowFeeds returns a feed per show, sorted by root and by show
+func ShowFeeds(items []Item) []*Feed {
+	return group(items, func(i *Item) (string, string) {
+		return FeedName(i.Root) + "/" + FeedName(i.Show), i.Show
+	})
+}
+
+// RootFeeds returns a feed per root
+func RootFeeds(items []Item) []*Feed {
+	return group(items, func(i *Item) (string, string) {
+		return FeedName(i.Root), i.Root
+	})
+}
+
+// FeedName returns a name usable in file names and URLs
+func FeedName(s string) string {
+	return nameReplacer.Replace(s)
+}
+
+var nameReplacer = strings.NewReplacer("/", "-", "\\", "-", " ", "_", "?", "", "#", "", "%", "", ":", "", "*", "", "\"", "", "<", "", ">", "", "|", "")
+
+func group(items []Item, key func(i *Item) (string, string)) []*Feed {
+	byID := map[string]*Feed{}
+	feeds := []*Feed{}
+	for n := range items {
+		i := &items[n]
+		id, title := key(i)
+		f, ok := byID[id]
+		if !ok {
+			f = &Feed{ID: id, Title: title}
+			byID[id] = f
+			feeds = append(feeds, f)
+		}
+		f.Items = append(f.Items, *i)
+	}
+	for _, f := range feeds {
+		sort.SliceStable(f.Items, func(i, j int) bool {
+			return f.Items[i].Date().After(f.Items[j].Date())
+		})
+	}
+	sort.Slice(feeds, func(i, j int) bool {
+		return feeds[i].ID < feeds[j].ID
+	})
+	return feeds
+}
+
+// guid returns a stable identifier of the item: the provider's ID when known, its path otherwise
+func (i *Item) guid() string {
+	for _, id := range i.Info.UniqueID {
+		if id.ID != "" {
+			return id.Type + ":" + id.ID
+		}
+	}
+	return i.Root + "/" + i.RelPath
+}
+
+// mimeType returns the MIME type of the file
+func mimeType(path string) string {
+	t := mime.TypeByExtension(filepath.Ext(path))
+	if t == "" {
+		t = "video/mp4"
+	}
+	return t
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Itunes  string     `xml:"xmlns:itunes,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link,omitempty"`
+	Description string    `xml:"description"`
+	Generator   string    `xml:"generator"`
+	LastBuild   string    `xml:"lastBuildDate,omitempty"`
+	Image       *rssImage `xml:"itunes:image,omitempty"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type rssItem struct {
+	Title       string       `xml:"title"`
+	Description string       `xml:"description,omitempty"`
+	GUID        rssGUID      `xml:"guid"`
+	PubDate     string       `xml:"pubDate"`
+	Enclosure   rssEnclosure `xml:"enclosure"`
+	Image       *rssImage    `xml:"itunes:image,omitempty"`
+	Season      int          `xml:"itunes:season,omitempty"`
+	Episode     int          `xml:"itunes:episode,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	ID          string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// WriteRSS writes the feed in RSS 2.0 format, with iTunes extensions used by podcast applications
+func (f *Feed) WriteRSS(w io.Writer, url URLFunc) error {
+	rss := rssFeed{
+		Version: "2.0",
+		Itunes:  "http://www.itunes.com/dtds/podcast-1.0.dtd",
+		Channel: rssChannel{
+			Title:       f.Title,
+			Description: f.Description,
+			Generator:   "aspiratv",
+		},
+	}
+	if rss.Channel.Description == "" {
+		rss.Channel.Description = f.Title
+	}
+	for _, i := range f.Items {
+		item := rssItem{
+			Title:       i.Info.Title,
+			Description: i.Info.Plot,
+			GUID:        rssGUID{ID: i.guid()},
+			PubDate:     i.Date().Format(time.RFC1123Z),
+			Enclosure: rssEnclosure{
+				URL:    url(i.Path),
+				Length: i.Size,
+				Type:   mimeType(i.Path),
+			},
+			Season:  i.Info.Season,
+			Episode: i.Info.Episode,
+		}
+		if i.Thumb != "" {
+			item.Image = &rssImage{Href: url(i.Thumb)}
+			if rss.Channel.Image == nil {
+				rss.Channel.Image = item.Image
+			}
+		}
+		rss.Channel.Items = append(rss.Channel.Items, item)
+	}
+	if len(f.Items) > 0 {
+		rss.Channel.LastBuild = f.Items[0].Date().Format(time.RFC1123Z)
+	}
+	return writeXML(w, rss)
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Icon    string      `xml:"icon,omitempty"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID      string     `xml:"id"`
+	Title   string     `xml:"title"`
+	Updated string     `xml:"updated"`
+	Summary string     `xml:"summary,omitempty"`
+	Links   []atomLink `xml:"link"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+// WriteAtom writes the feed in Atom format
+func (f *Feed) WriteAtom(w io.Writer, url URLFunc) error {
+	atom := atomFeed{
+		ID:     "aspiratv:" + f.ID,
+		Title:  f.Title,
+		Author: atomAuthor{Name: "aspiratv"},
+	}
+	updated := time.Time{}
+	for _, i := range f.Items {
+		e := atomEntry{
+			ID:      "aspiratv:" + i.guid(),
+			Title:   i.Info.Title,
+			Updated: i.Date().Format(time.RFC3339),
+			Summary: i.Info.Plot,
+			Links: []atomLink{
+				{Rel: "enclosure", Href: url(i.Path), Type: mimeType(i.Path), Length: i.Size},
+			},
+		}
+		if i.Thumb != "" {
+			e.Links = append(e.Links, atomLink{Rel: "related", Href: url(i.Thumb), Type: mime.TypeByExtension(filepath.Ext(i.Thumb))})
+			if atom.Icon == "" {
+				atom.Icon = url(i.Thumb)
+			}
+		}
+		if i.Date().After(updated) {
+			updated = i.Date()
+		}
+		atom.Entries = append(atom.Entries, e)
+	}
+	atom.Updated = updated.Format(time.RFC3339)
+	return writeXML(w, atom)
+}
+
+func writeXML(w io.Writer, v interface{}) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	err = e.Encode(v)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
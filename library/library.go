@@ -0,0 +1,177 @@
+// Package library reads the downloaded medias: videos found in destinations, with their
+// metadata files and their thumbnails.
+package library
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/simulot/aspiratv/metadata/nfo"
+)
+
+// Root is a directory of the library: a destination, or the path of a show
+type Root struct {
+	Name string // Destination code, or show name
+	Path string
+	Show bool // The root is the path of a show, not a destination
+}
+
+// Item is a video of the library
+type Item struct {
+	Root    string         // Name of the root holding the video
+	Path    string         // Path of the video
+	RelPath string         // Path of the video relative to the root, with slashes
+	Show    string         // Show's title, from the metadata or the directory
+	NFOPath string         // Path of the metadata file, empty when missing
+	Thumb   string         // Path of the thumbnail, empty when missing
+	Kind    string         // episode or movie, empty when the metadata file is missing
+	Info    *nfo.MediaInfo // Metadata, only the title is set when the metadata file is missing
+	Size    int64
+	ModTime time.Time
+}
+
+// Date returns the broadcast date of the item, the date of the file when unknown
+func (i *Item) Date() time.Time {
+	if t := i.Info.Aired.Time(); !t.IsZero() {
+		return t
+	}
+	return i.ModTime
+}
+
+// Video extensions
+var videoExts = map[string]bool{".mp4": true, ".mkv": true}
+
+// Image extensions of thumbnails
+var imageExts = []string{".png", ".jpeg", ".jpg", ".gif"}
+
+// Streams downloaded separately by the DASH downloader, before being merged
+var temporaryRe = regexp.MustCompile(`\.(video|audio)-[^.]*\.mp4$`)
+
+// IsTemporary tells if the file is a temporary stream left by an interrupted download
+func IsTemporary(path string) bool {
+	return temporaryRe.MatchString(path)
+}
+
+// IsVideo tells if the file is a video of the library
+func IsVideo(path string) bool {
+	return videoExts[strings.ToLower(filepath.Ext(path))] && !IsTemporary(path)
+}
+
+// Scan returns videos of the roots, sorted by root and path. Missing roots are ignored.
+func Scan(roots []Root) ([]Item, error) {
+	items := []Item{}
+	for _, root := range roots {
+		err := filepath.Walk(root.Path, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && path == root.Path {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if fi.IsDir() || !IsVideo(path) {
+				return nil
+			}
+			items = append(items, newItem(root, path, fi))
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Can't scan library: %w", err)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Root != items[j].Root {
+			return items[i].Root < items[j].Root
+		}
+		return items[i].RelPath < items[j].RelPath
+	})
+	return items, nil
+}
+
+// newItem reads the metadata of the video
+func newItem(root Root, path string, fi os.FileInfo) Item {
+	rel, _ := filepath.Rel(root.Path, path)
+	i := Item{
+		Root:    root.Name,
+		Path:    path,
+		RelPath: filepath.ToSlash(rel),
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	}
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	nfoPath := base + ".nfo"
+	if info, kind, err := ReadNFO(nfoPath); err == nil {
+		i.NFOPath, i.Info, i.Kind = nfoPath, info, kind
+	} else {
+		i.Info = &nfo.MediaInfo{Title: filepath.Base(base)}
+	}
+	i.Thumb = Thumbnail(base)
+
+	i.Show = i.Info.Showtitle
+	if i.Show == "" {
+		if parts := strings.Split(i.RelPath, "/"); len(parts) > 1 {
+			i.Show = parts[0]
+		} else {
+			i.Show = root.Name
+		}
+	}
+	return i
+}
+
+// Thumbnail returns the first thumbnail of the video, given without extension. It returns an empty string when there is none.
+func Thumbnail(base string) string {
+	for _, ext := range imageExts {
+		matches, _ := filepath.Glob(escapeGlob(base) + "_*" + ext)
+		if len(matches) > 0 {
+			sort.Strings(matches)
+			return matches[0]
+		}
+	}
+	return ""
+}
+
+// escapeGlob escapes characters having a meaning in glob patterns
+func escapeGlob(s string) string {
+	return globEscaper.Replace(s)
+}
+
+var globEscaper = strings.NewReplacer(`*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// ReadNFO reads the metadata file of an episode or of a movie
+func ReadNFO(path string) (*nfo.MediaInfo, string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var root struct {
+		XMLName xml.Name
+	}
+	err = xml.Unmarshal(b, &root)
+	if err != nil {
+		return nil, "", fmt.Errorf("Can't decode %s: %w", path, err)
+	}
+	switch root.XMLName.Local {
+	case "episodedetails":
+		e := nfo.EpisodeDetails{}
+		err = xml.Unmarshal(b, &e)
+		if err != nil {
+			return nil, "", fmt.Errorf("Can't decode %s: %w", path, err)
+		}
+		return &e.MediaInfo, "episode", nil
+	case "movie":
+		m := nfo.Movie{}
+		err = xml.Unmarshal(b, &m)
+		if err != nil {
+			return nil, "", fmt.Errorf("Can't decode %s: %w", path, err)
+		}
+		m.MediaType = nfo.TypeMovie
+		return &m.MediaInfo, "movie", nil
+	}
+	return nil, "", fmt.Errorf("Can't decode %s: unexpected element <%s>", path, root.XMLName.Local)
+}
@@ -0,0 +1,108 @@
+package library
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simulot/aspiratv/metadata/nfo"
+)
+
+func writeFile(t *testing.T, p string, content string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(p), 0777)
+	if err == nil {
+		err = ioutil.WriteFile(p, []byte(content), 0666)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanAndFeeds(t *testing.T) {
+	dir := t.TempDir()
+	season := filepath.Join(dir, "Les Lapins Crétins", "Season 01")
+	ep := filepath.Join(season, "Les Lapins Crétins - s01e02 - Lapin bricoleur")
+	writeFile(t, ep+".mp4", "video")
+	writeFile(t, ep+"_1.png", "image")
+	info := nfo.EpisodeDetails{MediaInfo: nfo.MediaInfo{
+		Showtitle: "Les Lapins Crétins",
+		Title:     "Lapin bricoleur",
+		Plot:      "Les lapins & le bricolage",
+		Season:    1,
+		Episode:   2,
+		Aired:     nfo.Aired(time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)),
+		UniqueID:  []nfo.ID{{ID: "123", Type: "FRANCETV:SI_ID"}},
+	}}
+	err := info.WriteNFO(ep + ".nfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(season, "Les Lapins Crétins - s01e03 - Sans nfo.mp4"), "video")
+	writeFile(t, ep+".mp4.video-fr.mp4", "temporary stream")
+
+	roots := []Root{{Name: "Jeunesse", Path: dir}, {Name: "Missing", Path: filepath.Join(dir, "missing")}}
+	items, err := Scan(roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("Expecting 2 videos, got %+v", items)
+	}
+	i := items[0]
+	if i.Kind != "episode" || i.Info.Episode != 2 || i.Thumb != ep+"_1.png" || i.Show != "Les Lapins Crétins" {
+		t.Errorf("Unexpected item %+v", i)
+	}
+	if items[1].Kind != "" || items[1].Info.Title != "Les Lapins Crétins - s01e03 - Sans nfo" || items[1].Show != "Les Lapins Crétins" {
+		t.Errorf("Unexpected item without nfo %+v", items[1])
+	}
+
+	feeds := ShowFeeds(items)
+	if len(feeds) != 1 || feeds[0].ID != "Jeunesse/Les_Lapins_Crétins" || len(feeds[0].Items) != 2 {
+		t.Fatalf("Unexpected feeds %+v", feeds)
+	}
+
+	srv := NewServer(roots, "http://nas:8080/")
+	b := bytes.Buffer{}
+	err = feeds[0].WriteRSS(&b, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<enclosure url="http://nas:8080/files/Jeunesse/Les%20Lapins%20Cr%C3%A9tins/Season%2001/Les%20Lapins%20Cr%C3%A9tins%20-%20s01e02%20-%20Lapin%20bricoleur.mp4" length="5" type="video/mp4"></enclosure>`,
+		`<guid isPermaLink="false">FRANCETV:SI_ID:123</guid>`,
+		`<description>Les lapins &amp; le bricolage</description>`,
+		`<itunes:image href="http://nas:8080/files/Jeunesse/Les%20Lapins%20Cr%C3%A9tins/Season%2001/Les%20Lapins%20Cr%C3%A9tins%20-%20s01e02%20-%20Lapin%20bricoleur_1.png"></itunes:image>`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("Expecting %s in\n%s", want, b.String())
+		}
+	}
+
+	// Feeds and files are served
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	for path, want := range map[string]int{
+		"/feeds/Jeunesse/Les_Lapins_Crétins.atom": http.StatusOK,
+		"/feeds/Jeunesse.rss":                     http.StatusOK,
+		"/feeds/Unknown.rss":                      http.StatusNotFound,
+		"/files/Jeunesse/Les Lapins Crétins/Season 01/Les Lapins Crétins - s01e02 - Lapin bricoleur.mp4": http.StatusOK,
+		"/files/Jeunesse/Les Lapins Crétins/Season 01/Les Lapins Crétins - s01e02 - Lapin bricoleur.nfo": http.StatusNotFound,
+	} {
+		resp, err := http.Get(ts.URL + (&url.URL{Path: path}).EscapedPath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: expecting status %d, got %d", path, want, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package library
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/simulot/aspiratv/metadata/nfo"
+)
+
+// MinSimilarity is the similarity of titles above which two episodes aired the same day are the same
+const MinSimilarity = 0.7
+
+// FindSame returns the item of the library that is the same media as info, nil when there is none.
+// Items are the same media when they share a provider's ID. Items without such ID are the same media
+// when they belong to the same show, are aired the same day and have similar titles, so episodes renumbered
+// or retitled by the provider are found.
+func FindSame(items []Item, info *nfo.MediaInfo) *Item {
+	for n := range items {
+		if items[n].Info.SameID(info) {
+			return &items[n]
+		}
+	}
+	aired := info.Aired.Time()
+	if aired.IsZero() {
+		return nil
+	}
+	var best *Item
+	bestScore := MinSimilarity
+	for n := range items {
+		i := &items[n]
+		if i.Info.OtherID(info) || !sameDay(i.Info.Aired.Time(), aired) {
+			continue
+		}
+		if info.Showtitle != "" && nfo.Normalize(i.Show) != nfo.Normalize(info.Showtitle) {
+			continue
+		}
+		if s := nfo.Similarity(i.Info.Title, info.Title); s >= bestScore {
+			best, bestScore = i, s
+		}
+	}
+	return best
+}
+
+func sameDay(a, b time.Time) bool {
+	return !a.IsZero() && a.Format("2006-01-02") == b.Format("2006-01-02")
+}
+
+// Files of a media: the video, its metadata file and its thumbnails, named base_N.ext
+var companionRe = regexp.MustCompile(`^(\.[^.]+|_\d+\.[^.]+)$`)
+
+// Rename moves the video of the item, its metadata file and its thumbnails to match the new video path.
+// The video keeps its extension.
+// The metadata file is updated with the title, the season and the episode of info.
+func Rename(i *Item, videoPath string, info *nfo.MediaInfo) error {
+	oldBase := strings.TrimSuffix(i.Path, filepath.Ext(i.Path))
+	newBase := strings.TrimSuffix(videoPath, filepath.Ext(videoPath))
+	files, err := filepath.Glob(escapeGlob(oldBase) + "*")
+	if err != nil {
+		return fmt.Errorf("Can't rename %s: %w", i.Path, err)
+	}
+	err = os.MkdirAll(filepath.Dir(videoPath), 0777)
+	if err != nil {
+		return fmt.Errorf("Can't rename %s: %w", i.Path, err)
+	}
+	for _, f := range files {
+		suffix := strings.TrimPrefix(f, oldBase)
+		if !companionRe.MatchString(suffix) {
+			continue
+		}
+		err = os.Rename(f, newBase+suffix)
+		if err != nil {
+			return fmt.Errorf("Can't rename %s: %w", f, err)
+		}
+	}
+	i.Path = newBase + filepath.Ext(i.Path)
+	i.Thumb = Thumbnail(newBase)
+	if i.NFOPath == "" {
+		return nil
+	}
+	i.NFOPath = newBase + ".nfo"
+	i.Info.Title = info.Title
+	i.Info.Season = info.Season
+	i.Info.Episode = info.Episode
+	if len(i.Info.UniqueID) == 0 {
+		i.Info.UniqueID = info.UniqueID
+	}
+	return WriteNFO(i.NFOPath, i.Kind, i.Info)
+}
+
+// WriteNFO writes the metadata file of an episode or of a movie
+func WriteNFO(path string, kind string, info *nfo.MediaInfo) error {
+	if kind == "movie" {
+		return (&nfo.Movie{MediaInfo: *info}).WriteNFO(path)
+	}
+	return (&nfo.EpisodeDetails{MediaInfo: *info}).WriteNFO(path)
+}
@@ -0,0 +1,92 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/simulot/aspiratv/metadata/nfo"
+)
+
+func TestFindSameAndRename(t *testing.T) {
+	dir := t.TempDir()
+	aired := nfo.Aired(time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC))
+	ep := filepath.Join(dir, "Season 01", "Les Lapins Crétins - s01e02 - Lapin bricoleur")
+	writeFile(t, ep+".mp4", "video")
+	writeFile(t, ep+"_1.png", "image")
+	writeFile(t, ep+" bis.mp4", "other video")
+	old := nfo.EpisodeDetails{MediaInfo: nfo.MediaInfo{
+		Showtitle: "Les Lapins Crétins",
+		Title:     "Lapin bricoleur",
+		Season:    1,
+		Episode:   2,
+		Aired:     aired,
+	}}
+	err := old.WriteNFO(ep + ".nfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := Scan([]Root{{Name: "Les Lapins Crétins", Path: dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		info nfo.MediaInfo
+		want bool
+	}{
+		{"retitled", nfo.MediaInfo{Showtitle: "Les Lapins Crétins", Title: "Le lapin bricoleur !", Aired: aired}, true},
+		{"other day", nfo.MediaInfo{Showtitle: "Les Lapins Crétins", Title: "Lapin bricoleur", Aired: nfo.Aired(time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC))}, false},
+		{"other title", nfo.MediaInfo{Showtitle: "Les Lapins Crétins", Title: "Lapin cuisinier", Aired: aired}, false},
+		{"other number", nfo.MediaInfo{Showtitle: "Les Lapins Crétins", Title: "Lapin bricoleur 2", Aired: aired}, false},
+		{"other show", nfo.MediaInfo{Showtitle: "Les Lapins", Title: "Lapin bricoleur", Aired: aired}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FindSame(items, &tt.info)
+			if (got != nil) != tt.want {
+				t.Errorf("FindSame() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	info := &nfo.MediaInfo{
+		Showtitle: "Les Lapins Crétins",
+		Title:     "Le lapin bricoleur",
+		Season:    1,
+		Episode:   5,
+		Aired:     aired,
+		UniqueID:  []nfo.ID{{ID: "123", Type: "FRANCETV:SI_ID"}},
+	}
+	i := FindSame(items, info)
+	if i == nil {
+		t.Fatal("Episode not found")
+	}
+	newEp := filepath.Join(dir, "Season 01", "Les Lapins Crétins - s01e05 - Le lapin bricoleur")
+	err = Rename(i, newEp+".mp4", info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{newEp + ".mp4", newEp + "_1.png", newEp + ".nfo", ep + " bis.mp4"} {
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("Missing file: %s", err)
+		}
+	}
+	if _, err := os.Stat(ep + ".mp4"); !os.IsNotExist(err) {
+		t.Errorf("Old video still present")
+	}
+	got, _, err := ReadNFO(newEp + ".nfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Episode != 5 || got.Title != "Le lapin bricoleur" || len(got.UniqueID) != 1 {
+		t.Errorf("Unexpected metadata after renaming: %+v", got)
+	}
+
+	// The renamed episode is now found by its ID
+	if FindSame(items, &nfo.MediaInfo{UniqueID: info.UniqueID}) != i {
+		t.Errorf("Renamed episode not found by its ID")
+	}
+}
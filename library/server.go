@@ -0,0 +1,133 @@
+package library
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Server serves feeds of the library and its files over HTTP:
+//
+//	GET /feeds/<root>.rss          feed of a root, also in Atom format with .atom
+//	GET /feeds/<root>/<show>.rss   feed of a show
+//	GET /files/<root>/<path>       video or thumbnail
+type Server struct {
+	roots   []Root
+	baseURL string
+}
+
+// NewServer returns the server of the roots. Links of feeds start by baseURL.
+func NewServer(roots []Root, baseURL string) *Server {
+	return &Server{
+		roots:   roots,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// URL returns the URL of a file of the library, an empty string when the file is outside roots
+func (s *Server) URL(p string) string {
+	for _, r := range s.roots {
+		rel, err := filepath.Rel(r.Path, p)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		u := s.baseURL + "/files/" + url.PathEscape(FeedName(r.Name))
+		for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+			u += "/" + url.PathEscape(part)
+		}
+		return u
+	}
+	return ""
+}
+
+// FileURL returns the file:// URL of a local file, for feeds read on the same machine
+func FileURL(p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		abs = p
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := path.Clean(r.URL.Path)
+	switch {
+	case strings.HasPrefix(p, "/feeds/"):
+		s.serveFeed(w, r, strings.TrimPrefix(p, "/feeds/"))
+	case strings.HasPrefix(p, "/files/"):
+		s.serveFile(w, r, strings.TrimPrefix(p, "/files/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveFeed(w http.ResponseWriter, r *http.Request, name string) {
+	ext := path.Ext(name)
+	id := strings.TrimSuffix(name, ext)
+	if ext != ".rss" && ext != ".atom" {
+		http.NotFound(w, r)
+		return
+	}
+	items, err := Scan(s.roots)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	feeds := RootFeeds(items)
+	if strings.Contains(id, "/") {
+		feeds = ShowFeeds(items)
+	}
+	for _, f := range feeds {
+		if f.ID != id {
+			continue
+		}
+		if ext == ".atom" {
+			w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+			f.WriteAtom(w, s.URL)
+		} else {
+			w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+			f.WriteRSS(w, s.URL)
+		}
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) < 2 {
+		http.NotFound(w, r)
+		return
+	}
+	for _, root := range s.roots {
+		if FeedName(root.Name) != parts[0] {
+			continue
+		}
+		// The path is cleaned, it can't go above the root
+		p := filepath.Join(root.Path, filepath.FromSlash(parts[1]))
+		if !IsVideo(p) && !isImage(p) {
+			break
+		}
+		http.ServeFile(w, r, p)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// isImage tells if the file is a thumbnail
+func isImage(p string) bool {
+	ext := strings.ToLower(filepath.Ext(p))
+	for _, e := range imageExts {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
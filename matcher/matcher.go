@@ -3,6 +3,7 @@ package matcher
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -35,10 +36,14 @@ type MatchRequest struct {
 	TitleExclude       Filter         // ShowTitle and Episode title must not match this regexp to be downloaded
 	KeepBonus          bool           // When trie bonuses and trailer are retrieved
 	Force              bool           // True to force  medias
+	Disabled           bool           `json:",omitempty"` // True when the entry is kept in the watch list, but not pulled
+	Priority           int            // Medias of shows with higher priority are downloaded first
+	PreferredProviders []string       `json:",omitempty"` // Providers in preference order, when the media is available on several providers
 
 }
 
-func (m MatchRequest) Validate(destinations map[string]string) error {
+// Check returns an error when a mandatory field is missing. Nothing is created on disk.
+func (m MatchRequest) Check() error {
 	if m.Show == "" {
 		return errors.New("Missing show name")
 	}
@@ -50,6 +55,25 @@ func (m MatchRequest) Validate(destinations map[string]string) error {
 	if m.ShowRootPath == "" && m.Destination == "" {
 		return errors.New("Missing show path or destination")
 	}
+	return nil
+}
+
+func (m MatchRequest) Validate(destinations map[string]string) error {
+	err := m.Check()
+	if err != nil {
+		return err
+	}
+
+	err = m.CheckTemplates()
+	if err != nil {
+		return err
+	}
+
+	if m.ShowRootPath == "" {
+		if _, ok := destinations[m.Destination]; !ok {
+			return fmt.Errorf("Unknown destination %q for show %q", m.Destination, m.Show)
+		}
+	}
 
 	if m.ShowRootPath != "" {
 		p := os.ExpandEnv(m.ShowRootPath)
@@ -90,18 +114,19 @@ type Filter struct {
 
 //MarshalJSON returns a  string from regexp and place it in the JSON stream
 func (t Filter) MarshalJSON() ([]byte, error) {
-
-	return []byte(`"` + t.String() + `"`), nil
+	return json.Marshal(t.String())
 }
 
 // UnmarshalJSON takes the string from the stream and compile the regexp
 func (t *Filter) UnmarshalJSON(b []byte) error {
-	if b[0] == '"' {
-		b = b[1 : len(b)-1]
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
 	}
 	t.Regexp = nil
-	if len(b) > 0 {
-		return t.Set(string(b))
+	if len(s) > 0 {
+		return t.Set(s)
 	}
 	return nil
 }
@@ -140,7 +165,7 @@ func (t TemplateString) Type() string {
 
 func (t *TemplateString) Set(s string) error {
 	var err error
-	t.T, err = template.New("").Parse(s)
+	t.T, err = NewTemplate("", s)
 	if err != nil {
 		return err
 	}
@@ -150,26 +175,27 @@ func (t *TemplateString) Set(s string) error {
 }
 
 //MarshalJSON returns a  string from regexp and place it in the JSON stream
+// A missing template is written as null to keep the default naming
 func (t TemplateString) MarshalJSON() ([]byte, error) {
-	return []byte(`"` + t.S + `"`), nil
+	if t.T == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.S)
 }
 
 // UnmarshalJSON takes the string from the stream and compile the template
 func (t *TemplateString) UnmarshalJSON(b []byte) error {
-	var err error
-	// if b[0] == '"' {
-	// 	b = b[1 : len(b)-1]
-	// }
-	err = json.Unmarshal(b, &t.S)
-	if err != nil {
-		return err
+	if string(b) == "null" {
+		t.S, t.T = "", nil
+		return nil
 	}
-
-	t.T, err = template.New("").Parse(t.S)
+	var s string
+	err := json.Unmarshal(b, &s)
 	if err != nil {
 		return err
 	}
-	return nil
+	// An empty template is meaningful: "SeasonPathTemplate": "" skips the season in path
+	return t.Set(s)
 }
 
 // Accepted check if ShowTitle or episode Title matches the filter
@@ -0,0 +1,173 @@
+package matcher
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/simulot/aspiratv/metadata/nfo"
+)
+
+// TemplateFuncs are functions available in ShowNameTemplate and SeasonPathTemplate:
+//
+//	slug         {{.Title | slug}}                      lower case ASCII words joined by dashes
+//	lower, upper {{.Showtitle | upper}}                 change the case
+//	ascii        {{.Title | ascii}}                     remove accents
+//	truncate     {{.Title | truncate 40}}               keep the first characters
+//	pad          {{.Episode | pad 3}}                   pad a number with zeros
+//	date         {{.Aired | date "2 January 2006"}}     format a date in French
+//	default      {{.Showtitle | default "Divers"}}      replace an empty value
+//	replace      {{.Title | replace ":" " -"}}          replace all occurrences of a string
+//	provider     {{provider}}                           name of the provider of the media
+var TemplateFuncs = template.FuncMap{
+	"slug":     slug,
+	"lower":    strings.ToLower,
+	"upper":    strings.ToUpper,
+	"ascii":    nfo.ASCII,
+	"truncate": truncate,
+	"pad":      pad,
+	"date":     frenchDate,
+	"default":  defaultValue,
+	"replace":  replace,
+	"provider": func() string { return "" },
+}
+
+// NewTemplate parses a template using TemplateFuncs
+func NewTemplate(name, s string) (*template.Template, error) {
+	return template.New(name).Funcs(TemplateFuncs).Parse(s)
+}
+
+// ExecuteTemplate applies the template to the media of the provider
+func ExecuteTemplate(t *template.Template, w io.Writer, provider string, info *nfo.MediaInfo) error {
+	t, err := t.Clone()
+	if err != nil {
+		return err
+	}
+	return t.Funcs(template.FuncMap{"provider": func() string { return provider }}).Execute(w, info)
+}
+
+// sampleMedia is used to check templates when reading the configuration
+func sampleMedia(m MatchRequest) *nfo.MediaInfo {
+	return &nfo.MediaInfo{
+		Title:     "Title",
+		Showtitle: "Show",
+		Season:    1,
+		Episode:   1,
+		Aired:     nfo.Aired(time.Now()),
+		UniqueID:  []nfo.ID{{ID: "1", Type: m.Provider}},
+		MediaType: m.Type,
+	}
+}
+
+// CheckTemplates executes name and season templates against a sample media
+func (m MatchRequest) CheckTemplates() error {
+	info := sampleMedia(m)
+	if m.SeasonPathTemplate.T != nil {
+		err := ExecuteTemplate(m.SeasonPathTemplate.T, ioutil.Discard, m.Provider, info)
+		if err != nil {
+			return fmt.Errorf("Can't use the season template of show %q: %w", m.Show, err)
+		}
+	}
+	if m.ShowNameTemplate.T != nil {
+		err := ExecuteTemplate(m.ShowNameTemplate.T, ioutil.Discard, m.Provider, info)
+		if err != nil {
+			return fmt.Errorf("Can't use the name template of show %q: %w", m.Show, err)
+		}
+	}
+	return nil
+}
+
+// slug returns lower case ASCII words joined by dashes
+func slug(s string) string {
+	return strings.ReplaceAll(nfo.Normalize(s), " ", "-")
+}
+
+// truncate keeps the n first characters of s, without trailing spaces
+func truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return strings.TrimSpace(string([]rune(s)[:n]))
+}
+
+// pad writes the number with at least n digits
+func pad(n int, v interface{}) (string, error) {
+	var s string
+	switch v := v.(type) {
+	case int:
+		s = strconv.Itoa(v)
+	case string:
+		if _, err := strconv.Atoi(v); err != nil {
+			return "", fmt.Errorf("pad: %q isn't a number", v)
+		}
+		s = v
+	default:
+		return "", fmt.Errorf("pad: %v isn't a number", v)
+	}
+	for len(s) < n {
+		s = "0" + s
+	}
+	return s, nil
+}
+
+var (
+	frenchMonths      = []string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"}
+	frenchShortMonths = []string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."}
+	frenchDays        = []string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"}
+	frenchShortDays   = []string{"dim.", "lun.", "mar.", "mer.", "jeu.", "ven.", "sam."}
+)
+
+// frenchDate formats the date with the layout of time.Format, with names of months and days in French
+func frenchDate(layout string, v interface{}) (string, error) {
+	var t time.Time
+	switch v := v.(type) {
+	case time.Time:
+		t = v
+	case nfo.Aired:
+		t = v.Time()
+	default:
+		return "", fmt.Errorf("date: %v isn't a date", v)
+	}
+	layout = strings.NewReplacer(
+		"January", frenchMonths[t.Month()-1],
+		"Jan", frenchShortMonths[t.Month()-1],
+		"Monday", frenchDays[t.Weekday()],
+		"Mon", frenchShortDays[t.Weekday()],
+	).Replace(layout)
+	return t.Format(layout), nil
+}
+
+// defaultValue returns def when v is empty
+func defaultValue(def string, v interface{}) interface{} {
+	switch x := v.(type) {
+	case nil:
+		return def
+	case nfo.Aired:
+		if x.Time().IsZero() {
+			return def
+		}
+	case time.Time:
+		if x.IsZero() {
+			return def
+		}
+	case string:
+		if x == "" {
+			return def
+		}
+	case int:
+		if x == 0 {
+			return def
+		}
+	}
+	return v
+}
+
+// replace replaces all occurrences of old by new in s
+func replace(old, new, s string) string {
+	return strings.ReplaceAll(s, old, new)
+}
@@ -0,0 +1,63 @@
+package matcher
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simulot/aspiratv/metadata/nfo"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	info := &nfo.MediaInfo{
+		Showtitle: "Les Lapins Crétins",
+		Title:     "Lapin bricoleur : la revanche",
+		Season:    1,
+		Episode:   7,
+		Aired:     nfo.Aired(time.Date(2021, 8, 3, 0, 0, 0, 0, time.UTC)),
+	}
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{`{{.Showtitle | slug}}`, "les-lapins-cretins"},
+		{`{{.Showtitle | upper}} {{.Title | lower}}`, "LES LAPINS CRÉTINS lapin bricoleur : la revanche"},
+		{`{{.Showtitle | ascii}}`, "Les Lapins Cretins"},
+		{`{{.Title | truncate 15}}`, "Lapin bricoleur"},
+		{`{{.Title | truncate 100}}`, "Lapin bricoleur : la revanche"},
+		{`s{{.Season | pad 2}}e{{.Episode | pad 3}}`, "s01e007"},
+		{`{{.Aired | date "Monday 2 January 2006"}}`, "mardi 3 août 2021"},
+		{`{{.Aired | date "Mon 02 Jan 06"}}`, "mar. 03 août 21"},
+		{`{{.Aired.Time | date "2006-01-02"}}`, "2021-08-03"},
+		{`{{.Plot | default "Sans résumé"}} {{.Title | default "Sans titre" | truncate 5}}`, "Sans résumé Lapin"},
+		{`{{.Title | replace " : " " - "}}`, "Lapin bricoleur - la revanche"},
+		{`{{provider}}/{{.Showtitle}}`, "francetv/Les Lapins Crétins"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			tmpl, err := NewTemplate("", tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := strings.Builder{}
+			err = ExecuteTemplate(tmpl, &b, "francetv", info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("got %q, want %q", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckTemplates(t *testing.T) {
+	m := MatchRequest{Show: "Les Lapins Crétins", Provider: "francetv", Destination: "Jeunesse"}
+	err := m.ShowNameTemplate.Set(`{{.Title | pad 2}}.mp4`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(map[string]string{"Jeunesse": t.TempDir()}); err == nil {
+		t.Errorf("Validate() accepts a name template failing with a media")
+	}
+}
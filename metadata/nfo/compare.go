@@ -0,0 +1,139 @@
+package nfo
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Letters with diacritics, and ligatures, by their ASCII replacement
+var asciiFolding = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a",
+	'À': "A", 'Á': "A", 'Â': "A", 'Ã': "A", 'Ä': "A", 'Å': "A",
+	'ç': "c", 'Ç': "C",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e",
+	'È': "E", 'É': "E", 'Ê': "E", 'Ë': "E",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i",
+	'Ì': "I", 'Í': "I", 'Î': "I", 'Ï': "I",
+	'ñ': "n", 'Ñ': "N",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o",
+	'Ò': "O", 'Ó': "O", 'Ô': "O", 'Õ': "O", 'Ö': "O", 'Ø': "O",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u",
+	'Ù': "U", 'Ú': "U", 'Û': "U", 'Ü': "U",
+	'ý': "y", 'ÿ': "y", 'Ý': "Y", 'Ÿ': "Y",
+	'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE", 'ß': "ss",
+	'’': "'", '‘': "'", '«': "\"", '»': "\"", '–': "-", '—': "-", '…': "...",
+}
+
+// ASCII replaces letters with diacritics by their base letter, and removes other non ASCII characters
+func ASCII(s string) string {
+	b := strings.Builder{}
+	for _, r := range s {
+		switch {
+		case r <= unicode.MaxASCII:
+			b.WriteRune(r)
+		case asciiFolding[r] != "":
+			b.WriteString(asciiFolding[r])
+		case unicode.IsSpace(r):
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
+
+// Normalize returns the string in lower case, without diacritics and punctuation, to compare titles
+func Normalize(s string) string {
+	s = strings.ToLower(ASCII(s))
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// Similarity of two titles, from 0 for different titles to 1 for same titles. Titles having different
+// numbers are different, as they are often distinct episodes of a show.
+func Similarity(a, b string) float64 {
+	a, b = Normalize(a), Normalize(b)
+	if a == b {
+		return 1
+	}
+	if numbers(a) != numbers(b) {
+		return 0
+	}
+	ra, rb := []rune(a), []rune(b)
+	l := len(ra)
+	if len(rb) > l {
+		l = len(rb)
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(l)
+}
+
+// numbers returns numbers of the normalized string
+func numbers(s string) string {
+	n := []string{}
+	for _, w := range strings.Fields(s) {
+		if strings.IndexFunc(w, unicode.IsDigit) >= 0 {
+			n = append(n, strings.TrimLeft(strings.Map(func(r rune) rune {
+				if unicode.IsDigit(r) {
+					return r
+				}
+				return -1
+			}, w), "0"))
+		}
+	}
+	return strings.Join(n, " ")
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// SameID returns true when both medias share a provider's identifier
+func (m *MediaInfo) SameID(o *MediaInfo) bool {
+	for _, a := range m.UniqueID {
+		for _, b := range o.UniqueID {
+			if a.ID != "" && a.Type == b.Type && a.ID == b.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// OtherID returns true when both medias have an identifier of the same type with different values,
+// so they are different medias of the same provider
+func (m *MediaInfo) OtherID(o *MediaInfo) bool {
+	for _, a := range m.UniqueID {
+		for _, b := range o.UniqueID {
+			if a.Type == b.Type && a.ID != b.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
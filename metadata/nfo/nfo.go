@@ -49,6 +49,7 @@ type MediaInfo struct {
 	Studio         string   `xml:"studio,omitempty"`
 	Actor          []Actor  `xml:"actor,omitempty"`
 	Tag            []string `xml:"tag,omitempty"`
+	Runtime        int      `xml:"runtime,omitempty"` // Duration in minutes
 
 	MediaURL   string  `xml:"-"` // Media URL mp4
 	PageURL    string  `xml:"-"` // Show page url
@@ -58,6 +59,19 @@ type MediaInfo struct {
 	IsDetailed bool    `xml:"-"` // True when details have been retrieved
 	IsBonus    bool    `xml:"-"` // True when the media is a bonus or a teaser
 	MediaType  ShowType
+
+	AvailableFrom  time.Time `xml:"-"` // Start of the replay, zero when unknown
+	AvailableUntil time.Time `xml:"-"` // End of the replay, zero when unknown
+}
+
+// NotYetAvailable returns true when the replay starts after now
+func (m *MediaInfo) NotYetAvailable(now time.Time) bool {
+	return !m.AvailableFrom.IsZero() && now.Before(m.AvailableFrom)
+}
+
+// Expired returns true when the replay has ended before now
+func (m *MediaInfo) Expired(now time.Time) bool {
+	return !m.AvailableUntil.IsZero() && !now.Before(m.AvailableUntil)
 }
 
 // ShowType says if the media is a movie (one time broadcast), Show (recurring show) or a series (with seasons and episodes)
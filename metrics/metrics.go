@@ -0,0 +1,289 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metrics families and writes them using the Prometheus text exposition format
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Default is the registry used by package level constructors
+var Default = NewRegistry()
+
+type kind string
+
+const (
+	kindCounter   kind = "counter"
+	kindGauge     kind = "gauge"
+	kindHistogram kind = "histogram"
+)
+
+// family is a named metric with its series, one per label values combination
+type family struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	kind    kind
+	labels  []string
+	buckets []float64 // Upper bounds for histograms
+	series  map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64  // Counter or gauge value, sum for histograms
+	count       uint64   // Number of observations for histograms
+	buckets     []uint64 // Observations per bucket for histograms
+}
+
+func (r *Registry) register(f *family) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, g := range r.families {
+		if g.name == f.name {
+			panic("metrics: duplicate metric " + f.name)
+		}
+	}
+	r.families = append(r.families, f)
+	return f
+}
+
+func newFamily(name, help string, k kind, labels []string) *family {
+	return &family{
+		name:   name,
+		help:   help,
+		kind:   k,
+		labels: labels,
+		series: map[string]*series{},
+	}
+}
+
+// get returns the series for the label values, creating it when needed. Caller must hold the lock.
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	k := strings.Join(labelValues, "\xff")
+	s, ok := f.series[k]
+	if !ok {
+		s = &series{
+			labelValues: append([]string(nil), labelValues...),
+		}
+		if f.kind == kindHistogram {
+			s.buckets = make([]uint64, len(f.buckets))
+		}
+		f.series[k] = s
+	}
+	return s
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	f *family
+}
+
+// NewCounter registers a counter in the default registry
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// NewCounter registers a counter in the registry
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{f: r.register(newFamily(name, help, kindCounter, labels))}
+}
+
+// Inc increments the counter for given label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter for given label values. Negative values are ignored.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.f.mu.Lock()
+	c.f.get(labelValues).value += v
+	c.f.mu.Unlock()
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	f *family
+}
+
+// NewGauge registers a gauge in the default registry
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.NewGauge(name, help, labels...)
+}
+
+// NewGauge registers a gauge in the registry
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{f: r.register(newFamily(name, help, kindGauge, labels))}
+}
+
+// Set the gauge value for given label values
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	g.f.get(labelValues).value = v
+	g.f.mu.Unlock()
+}
+
+// Add v to the gauge value for given label values
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	g.f.get(labelValues).value += v
+	g.f.mu.Unlock()
+}
+
+// Histogram counts observations into buckets
+type Histogram struct {
+	f *family
+}
+
+// DefaultBuckets are suitable for durations in seconds, from 10ms to 1 hour
+var DefaultBuckets = []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300, 900, 1800, 3600}
+
+// NewHistogram registers a histogram in the default registry.
+// When buckets is nil, DefaultBuckets are used.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+// NewHistogram registers a histogram in the registry.
+// When buckets is nil, DefaultBuckets are used.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	f := newFamily(name, help, kindHistogram, labels)
+	f.buckets = append([]float64(nil), buckets...)
+	sort.Float64s(f.buckets)
+	return &Histogram{f: r.register(f)}
+}
+
+// Observe adds an observation for given label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	s := h.f.get(labelValues)
+	s.value += v
+	s.count++
+	for i, b := range h.f.buckets {
+		if v <= b {
+			s.buckets[i]++
+		}
+	}
+}
+
+// WriteTo writes all metrics using the Prometheus text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	b := &strings.Builder{}
+	for _, f := range families {
+		f.write(b)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (f *family) write(b *strings.Builder) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(b, "# TYPE %s %s\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := f.series[k]
+		switch f.kind {
+		case kindHistogram:
+			for i, ub := range f.buckets {
+				fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, f.labelString(s.labelValues, "le", formatFloat(ub)), s.buckets[i])
+			}
+			fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, f.labelString(s.labelValues, "le", "+Inf"), s.count)
+			fmt.Fprintf(b, "%s_sum%s %s\n", f.name, f.labelString(s.labelValues), formatFloat(s.value))
+			fmt.Fprintf(b, "%s_count%s %d\n", f.name, f.labelString(s.labelValues), s.count)
+		default:
+			fmt.Fprintf(b, "%s%s %s\n", f.name, f.labelString(s.labelValues), formatFloat(s.value))
+		}
+	}
+}
+
+// labelString formats labels as {name="value",...}. Extra label name and values pairs can be added.
+func (f *family) labelString(values []string, extra ...string) string {
+	if len(values) == 0 && len(extra) == 0 {
+		return ""
+	}
+	parts := []string{}
+	for i, l := range f.labels {
+		parts = append(parts, l+"="+quoteLabel(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, extra[i]+"="+quoteLabel(extra[i+1]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// labelEscaper escapes label values as allowed by the text exposition format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quoteLabel returns the label value between double quotes
+func quoteLabel(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+// Handler returns an HTTP handler serving registry's metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// Handler returns an HTTP handler serving metrics of the default registry
+func Handler() http.Handler {
+	return Default.Handler()
+}
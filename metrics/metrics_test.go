@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_requests_total", "Number of requests", "host", "code")
+	g := r.NewGauge("test_queue_depth", "Jobs waiting")
+	h := r.NewHistogram("test_duration_seconds", "Durations", []float64{1, 10})
+
+	c.Inc("example.com", "200")
+	c.Inc("example.com", "200")
+	c.Add(3, "example.com", "404")
+	c.Add(-1, "example.com", "404")
+	g.Add(2)
+	g.Add(-1)
+	h.Observe(0.5)
+	h.Observe(5)
+	h.Observe(50)
+
+	b := &strings.Builder{}
+	_, err := r.WriteTo(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP test_duration_seconds Durations
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="1"} 1
+test_duration_seconds_bucket{le="10"} 2
+test_duration_seconds_bucket{le="+Inf"} 3
+test_duration_seconds_sum 55.5
+test_duration_seconds_count 3
+# HELP test_queue_depth Jobs waiting
+# TYPE test_queue_depth gauge
+test_queue_depth 1
+# HELP test_requests_total Number of requests
+# TYPE test_requests_total counter
+test_requests_total{host="example.com",code="200"} 2
+test_requests_total{host="example.com",code="404"} 3
+`
+	if got := b.String(); got != want {
+		t.Errorf("WriteTo() got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRegistry_DuplicateName(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic when registering twice the same name")
+		}
+	}()
+	r := NewRegistry()
+	r.NewCounter("dup", "")
+	r.NewGauge("dup", "")
+}
+
+func TestQuoteLabel(t *testing.T) {
+	if got, want := quoteLabel("Les \"Lapins\"\n\\ Crétins\t"), `"Les \"Lapins\"\n\\ Crétins`+"\t"+`"`; got != want {
+		t.Errorf("quoteLabel() = %s, want %s", got, want)
+	}
+}
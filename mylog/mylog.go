@@ -1,11 +1,16 @@
 package mylog
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Logger interface {
@@ -38,29 +43,118 @@ var prefixes = map[Level]string{
 	LevelDebug: "[DEBUG] ",
 }
 
+var levelNames = map[Level]string{
+	LevelFatal: "FATAL",
+	LevelError: "ERROR",
+	LevelInfo:  "INFO",
+	LevelTrace: "TRACE",
+	LevelDebug: "DEBUG",
+}
+
+// MyLog is a levelled logger. Child loggers created with With and Subsystem share the same output.
 type MyLog struct {
-	logLevel Level
-	logger   Logger
+	*sink
+	subsystem string        // Name of the subsystem, empty for the root logger
+	fields    []interface{} // Key / value pairs added to each message
+}
+
+// sink is the output shared by a logger and its children
+type sink struct {
+	mu       sync.Mutex
+	logLevel Level            // Default level
+	levels   map[string]Level // Level overrides per subsystem
+	logger   Logger           // Text output
+	json     io.Writer        // JSON output, replaces the text output when not nil
 }
 
-// NewLog return a MyLog structure
-func NewLog(lvl string, logger Logger) (*MyLog, error) {
-	var (
-		level Level
-		ok    bool
-	)
+// Option configures the logger
+type Option func(*MyLog)
+
+// WithJSON writes messages as JSON objects, one per line, on w
+func WithJSON(w io.Writer) Option {
+	return func(l *MyLog) {
+		l.json = w
+	}
+}
 
-	if level, ok = levelStrings[strings.ToUpper(lvl)]; !ok {
-		return nil, fmt.Errorf("Invalid log level '%s'", lvl)
+// NewLog return a MyLog structure.
+// The level is either a simple level like "ERROR", or a comma separated list of levels
+// with overrides per subsystem like "ERROR,dash=DEBUG".
+func NewLog(lvl string, logger Logger, opts ...Option) (*MyLog, error) {
+	level, levels, err := ParseLevels(lvl)
+	if err != nil {
+		return nil, err
 	}
 
 	// intercept all direct call to log as in http package
 	log.SetOutput(ioutil.Discard)
 
-	return &MyLog{
-		logLevel: level,
-		logger:   logger,
-	}, nil
+	l := &MyLog{
+		sink: &sink{
+			logLevel: level,
+			levels:   levels,
+			logger:   logger,
+		},
+	}
+	for _, o := range opts {
+		o(l)
+	}
+	return l, nil
+}
+
+// ParseLevels decodes a level specification like "ERROR,dash=DEBUG,http=TRACE".
+// It returns the default level and levels per subsystem.
+func ParseLevels(spec string) (Level, map[string]Level, error) {
+	var level Level = LevelError
+	levels := map[string]Level{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		subsystem := ""
+		if i := strings.Index(part, "="); i >= 0 {
+			subsystem, part = strings.ToLower(strings.TrimSpace(part[:i])), strings.TrimSpace(part[i+1:])
+		}
+		l, ok := levelStrings[strings.ToUpper(part)]
+		if !ok {
+			return 0, nil, fmt.Errorf("Invalid log level '%s'", part)
+		}
+		if subsystem == "" {
+			level = l
+		} else {
+			levels[subsystem] = l
+		}
+	}
+	return level, levels, nil
+}
+
+// With returns a child logger adding key / value pairs to each message
+func (l *MyLog) With(keyValues ...interface{}) *MyLog {
+	if l == nil {
+		return nil
+	}
+	c := *l
+	c.fields = append(append([]interface{}(nil), l.fields...), keyValues...)
+	return &c
+}
+
+// Subsystem returns a child logger for the named subsystem. Its level can be overridden.
+func (l *MyLog) Subsystem(name string) *MyLog {
+	if l == nil {
+		return nil
+	}
+	c := *l
+	c.subsystem = strings.ToLower(name)
+	return &c
+}
+
+// level returns the level of the logger, taking subsystem's override into account
+func (l *MyLog) level() Level {
+	if lvl, ok := l.levels[l.subsystem]; ok && l.subsystem != "" {
+		return lvl
+	}
+	return l.logLevel
 }
 
 // Fatal prepare the output of FATAL message
@@ -93,7 +187,7 @@ func (l *MyLog) IsDebug() bool {
 	if l == nil {
 		return true
 	}
-	return l.logLevel >= LevelDebug
+	return l.level() >= LevelDebug
 }
 
 // logcontext get the level of current message
@@ -117,12 +211,67 @@ func (c logcontext) Printf(fmt string, args ...interface{}) {
 		}
 		return
 	}
-	if c.lvl <= c.mylog.logLevel {
-		if c.mylog.logger != nil {
-			c.mylog.logger.Printf(prefixes[c.lvl]+fmt, args...)
-		}
+	if c.lvl <= c.mylog.level() {
+		c.mylog.write(c.lvl, fmt, args...)
 	}
 	if c.lvl == LevelFatal {
 		os.Exit(1)
 	}
 }
+
+func (l *MyLog) write(lvl Level, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.json != nil {
+		r := map[string]interface{}{
+			"time":  time.Now().Format(time.RFC3339Nano),
+			"level": levelNames[lvl],
+			"msg":   msg,
+		}
+		if l.subsystem != "" {
+			r["subsystem"] = l.subsystem
+		}
+		for i := 0; i+1 < len(l.fields); i += 2 {
+			k := fmt.Sprint(l.fields[i])
+			v := l.fields[i+1]
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			r[k] = v
+		}
+		b, err := json.Marshal(r)
+		if err != nil {
+			b, _ = json.Marshal(map[string]interface{}{"level": levelNames[lvl], "msg": msg, "error": err.Error()})
+		}
+		l.json.Write(append(b, '\n'))
+		return
+	}
+
+	if l.logger == nil {
+		return
+	}
+	prefix := prefixes[lvl]
+	if l.subsystem != "" {
+		prefix += "[" + l.subsystem + "] "
+	}
+	l.logger.Printf("%s", prefix+msg+formatFields(l.fields))
+}
+
+// formatFields format key / value pairs as logfmt
+func formatFields(keyValues []interface{}) string {
+	b := strings.Builder{}
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		v := fmt.Sprint(keyValues[i+1])
+		if v == "" || strings.ContainsAny(v, " =\"\t\n") {
+			v = strconv.Quote(v)
+		}
+		b.WriteString(" ")
+		b.WriteString(fmt.Sprint(keyValues[i]))
+		b.WriteString("=")
+		b.WriteString(v)
+	}
+	return b.String()
+}
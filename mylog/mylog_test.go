@@ -0,0 +1,147 @@
+package mylog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type bufLogger struct {
+	bytes.Buffer
+}
+
+func (b *bufLogger) Printf(format string, args ...interface{}) {
+	fmt.Fprintf(&b.Buffer, format+"\n", args...)
+}
+
+func TestSubsystemLevels(t *testing.T) {
+	b := &bufLogger{}
+	l, err := NewLog("ERROR,dash=DEBUG", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Debug().Printf("not written")
+	l.Subsystem("DASH").Debug().Printf("segment %d", 1)
+	l.Subsystem("runner").Info().Printf("not written")
+	l.With("provider", "francetv", "show", "Les Lapins Crétins").Error().Printf("can't download")
+
+	want := "[DEBUG] [dash] segment 1\n" +
+		"[ERROR] can't download provider=francetv show=\"Les Lapins Crétins\"\n"
+	if got := b.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if !l.Subsystem("dash").IsDebug() || l.IsDebug() {
+		t.Errorf("IsDebug doesn't take subsystem levels into account")
+	}
+}
+
+func TestParseLevels(t *testing.T) {
+	_, _, err := ParseLevels("ERROR,dash=LOUD")
+	if err == nil {
+		t.Errorf("expecting an error for an invalid level")
+	}
+	lvl, levels, err := ParseLevels("trace, http = debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lvl != LevelTrace || levels["http"] != LevelDebug {
+		t.Errorf("unexpected levels %v, %v", lvl, levels)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	b := &bytes.Buffer{}
+	l, err := NewLog("INFO", nil, WithJSON(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Subsystem("runner").With("job", 12, "err", fmt.Errorf("boom")).Info().Printf("job %s", "done")
+
+	r := map[string]interface{}{}
+	err = json.Unmarshal(b.Bytes(), &r)
+	if err != nil {
+		t.Fatalf("can't decode %q: %s", b.String(), err)
+	}
+	for k, v := range map[string]interface{}{"level": "INFO", "subsystem": "runner", "msg": "job done", "job": 12.0, "err": "boom"} {
+		if r[k] != v {
+			t.Errorf("field %q: got %v, want %v", k, r[k], v)
+		}
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "aspiratv.log")
+	other := filepath.Join(dir, "aspiratv-debug.log")
+	err := os.WriteFile(other, []byte("not a rotated file\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRotatingFile(p, 10, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		_, err = r.Write([]byte("12345678\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Close()
+
+	backups, _ := filepath.Glob(filepath.Join(dir, "aspiratv-2*.log"))
+	if len(backups) != 2 {
+		t.Errorf("expecting 2 backups, got %d", len(backups))
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "12345678\n" {
+		t.Errorf("unexpected content of the current log file %q", string(b))
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("unrelated file removed: %s", err)
+	}
+}
+
+func TestRotatingFileAge(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "aspiratv.log")
+	r, err := NewRotatingFile(p, 0, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Write([]byte("first run\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	// The file is appended by a later run, when it is older than maxAge
+	err = os.WriteFile(r.createdPath(), []byte(time.Now().Add(-2*time.Hour).Format(time.RFC3339Nano)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = NewRotatingFile(p, 0, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Write([]byte("second run\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	b, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "second run\n" {
+		t.Errorf("log file not rotated, content %q", string(b))
+	}
+}
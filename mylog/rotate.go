@@ -0,0 +1,175 @@
+package mylog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RotatingFile is a log file rotated when it reaches a given size or a given age.
+// Rotated files are renamed with a timestamp suffix, and only the most recent ones are kept.
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64         // Rotate when the file size exceeds maxSize bytes, 0 to disable
+	maxAge     time.Duration // Rotate when the file is older than maxAge, 0 to disable
+	maxBackups int           // Number of rotated files to keep, 0 to keep all of them
+	f          *os.File
+	size       int64
+	opened     time.Time
+}
+
+// NewRotatingFile opens the log file in append mode
+func NewRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f = f
+	r.size = st.Size()
+	r.opened = time.Now()
+	if r.size > 0 {
+		// The file has been created by a previous run, its age counts from its creation
+		if t, ok := r.readCreated(); ok {
+			r.opened = t
+			return nil
+		}
+	}
+	r.writeCreated()
+	return nil
+}
+
+// createdPath returns the file holding the creation time of the log file. The modification time
+// of an appended log file doesn't tell its age.
+func (r *RotatingFile) createdPath() string {
+	return filepath.Join(filepath.Dir(r.path), "."+filepath.Base(r.path)+".created")
+}
+
+// readCreated returns the creation time of the log file
+func (r *RotatingFile) readCreated() (time.Time, bool) {
+	b, err := ioutil.ReadFile(r.createdPath())
+	if err != nil {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(b)))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// writeCreated records the creation time of the log file. The age of the file is counted from
+// the current run when it can't be written.
+func (r *RotatingFile) writeCreated() {
+	ioutil.WriteFile(r.createdPath(), []byte(r.opened.Format(time.RFC3339Nano)+"\n"), 0644)
+}
+
+// Write implements io.Writer. The file is rotated before writing when needed.
+func (r *RotatingFile) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size > 0 && ((r.maxSize > 0 && r.size+int64(len(b)) > r.maxSize) || (r.maxAge > 0 && time.Since(r.opened) > r.maxAge)) {
+		err := r.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(b)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file and opens a new one
+func (r *RotatingFile) rotate() error {
+	err := r.f.Close()
+	if err != nil {
+		return err
+	}
+	ext := filepath.Ext(r.path)
+	stamp := strings.TrimSuffix(r.path, ext) + "-" + time.Now().Format(backupLayout)
+	backup := stamp + ext
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backup); os.IsNotExist(err) {
+			break
+		}
+		backup = fmt.Sprintf("%s.%d%s", stamp, i, ext)
+	}
+	err = os.Rename(r.path, backup)
+	if err != nil {
+		return err
+	}
+	r.prune()
+	return r.open()
+}
+
+// Timestamp of rotated files
+const backupLayout = "20060102-150405.000"
+
+// prune removes the oldest rotated files. Only files named by rotate are considered.
+func (r *RotatingFile) prune() {
+	if r.maxBackups <= 0 {
+		return
+	}
+	ext := filepath.Ext(r.path)
+	base := strings.TrimSuffix(filepath.Base(r.path), ext)
+	re, err := regexp.Compile(`^` + regexp.QuoteMeta(base) + `-\d{8}-\d{6}\.\d{3}(\.\d+)?` + regexp.QuoteMeta(ext) + `$`)
+	if err != nil {
+		return
+	}
+	matches, err := filepath.Glob(strings.TrimSuffix(r.path, ext) + "-*" + ext)
+	if err != nil {
+		return
+	}
+	backups := []string{}
+	for _, m := range matches {
+		if re.MatchString(filepath.Base(m)) {
+			backups = append(backups, m)
+		}
+	}
+	// Timestamps in names give the chronological order
+	sort.Strings(backups)
+	for len(backups) > r.maxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
+
+// Sync commits the current content of the file
+func (r *RotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Sync()
+}
+
+// Close the log file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
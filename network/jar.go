@@ -0,0 +1,143 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Jar is a cookie jar that can be saved into a file
+type Jar struct {
+	mu      sync.Mutex
+	path    string
+	jar     *cookiejar.Jar
+	cookies map[string]savedCookie // Cookies received, by domain, path and name
+	changed bool
+}
+
+// savedCookie is a cookie with the URL that has set it
+type savedCookie struct {
+	URL    string
+	Cookie *http.Cookie
+}
+
+// NewJar returns a jar loaded with cookies of the file. Cookies aren't saved when path is empty.
+func NewJar(path string) (*Jar, error) {
+	cj, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	j := &Jar{
+		path:    path,
+		jar:     cj,
+		cookies: map[string]savedCookie{},
+	}
+	if path == "" {
+		return j, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Can't read cookies: %w", err)
+	}
+	saved := []savedCookie{}
+	err = json.Unmarshal(b, &saved)
+	if err != nil {
+		return nil, fmt.Errorf("Can't decode cookies %s: %w", path, err)
+	}
+	now := time.Now()
+	for _, s := range saved {
+		if s.Cookie == nil || (!s.Cookie.Expires.IsZero() && s.Cookie.Expires.Before(now)) {
+			continue
+		}
+		u, err := url.Parse(s.URL)
+		if err != nil {
+			continue
+		}
+		j.setCookies(u, []*http.Cookie{s.Cookie})
+	}
+	return j, nil
+}
+
+// SetCookies implements http.CookieJar
+func (j *Jar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.setCookies(u, cookies)
+	j.changed = true
+}
+
+// setCookies records cookies. j.mu must be held.
+func (j *Jar) setCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+	for _, c := range cookies {
+		domain := c.Domain
+		if domain == "" {
+			domain = u.Hostname()
+		}
+		k := domain + c.Path + ";" + c.Name
+		if c.MaxAge < 0 {
+			delete(j.cookies, k)
+			continue
+		}
+		c := *c
+		if c.MaxAge > 0 {
+			// Max-Age is relative to the reception of the cookie
+			c.Expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+			c.MaxAge = 0
+		}
+		j.cookies[k] = savedCookie{URL: u.String(), Cookie: &c}
+	}
+}
+
+// Cookies implements http.CookieJar
+func (j *Jar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// Save writes cookies into the jar's file, when they have changed
+func (j *Jar) Save() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.path == "" || !j.changed {
+		return nil
+	}
+	now := time.Now()
+	keys := make([]string, 0, len(j.cookies))
+	for k := range j.cookies {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	saved := []savedCookie{}
+	for _, k := range keys {
+		s := j.cookies[k]
+		if !s.Cookie.Expires.IsZero() && s.Cookie.Expires.Before(now) {
+			continue
+		}
+		saved = append(saved, s)
+	}
+	b, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Can't encode cookies: %w", err)
+	}
+	err = os.MkdirAll(filepath.Dir(j.path), 0700)
+	if err != nil {
+		return fmt.Errorf("Can't write cookies: %w", err)
+	}
+	err = ioutil.WriteFile(j.path, b, 0600)
+	if err != nil {
+		return fmt.Errorf("Can't write cookies: %w", err)
+	}
+	j.changed = false
+	return nil
+}
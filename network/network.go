@@ -0,0 +1,182 @@
+// Package network gives the HTTP transports and clients shared by providers and downloads.
+// Connections are pooled by proxy, cookies are kept in one jar that can be saved on disk,
+// and additional certificate authorities can be trusted.
+package network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Default timeouts
+const (
+	DefaultTimeout        = 10 // Seconds allowed to a provider's query
+	DefaultConnectTimeout = 30 // Seconds allowed to connect and get response's headers
+)
+
+// Config of the network
+type Config struct {
+	Proxy          string // URL of the HTTP or SOCKS5 proxy, the environment's proxy when empty
+	Timeout        int    // Seconds allowed to a provider's query, DefaultTimeout when 0
+	ConnectTimeout int    // Seconds allowed to connect and get response's headers, DefaultConnectTimeout when 0
+	CAFile         string // PEM file of certificate authorities trusted in addition to system ones
+	CookieFile     string // File where cookies are kept between runs, cookies are forgotten when empty
+}
+
+// TimeoutDuration returns the timeout of provider's queries
+func (c Config) TimeoutDuration() time.Duration {
+	if c.Timeout <= 0 {
+		return DefaultTimeout * time.Second
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
+// connectTimeout returns the timeout of connections
+func (c Config) connectTimeout() time.Duration {
+	if c.ConnectTimeout <= 0 {
+		return DefaultConnectTimeout * time.Second
+	}
+	return time.Duration(c.ConnectTimeout) * time.Second
+}
+
+// Network holds transports and the cookie jar
+type Network struct {
+	conf       Config
+	tlsConfig  *tls.Config
+	jar        *Jar
+	mu         sync.Mutex
+	transports map[string]*http.Transport // By proxy
+}
+
+// New checks the configuration and loads the cookie file
+func New(conf Config) (*Network, error) {
+	n := &Network{
+		conf:       conf,
+		transports: map[string]*http.Transport{},
+	}
+	if conf.Proxy != "" {
+		_, err := parseProxy(conf.Proxy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if conf.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		b, err := ioutil.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Can't read certificate authorities: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("Can't read certificate authorities: no certificate found in %s", conf.CAFile)
+		}
+		n.tlsConfig = &tls.Config{RootCAs: pool}
+	}
+	var err error
+	n.jar, err = NewJar(conf.CookieFile)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Config returns the configuration of the network
+func (n *Network) Config() Config {
+	return n.conf
+}
+
+// Jar returns the cookie jar
+func (n *Network) Jar() *Jar {
+	return n.jar
+}
+
+// parseProxy checks the proxy's URL
+func parseProxy(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("Can't use proxy %q: %w", proxy, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("Can't use proxy %q: scheme must be http, https or socks5", proxy)
+	}
+	return u, nil
+}
+
+// CheckProxy returns an error when the proxy's URL can't be used
+func CheckProxy(proxy string) error {
+	_, err := parseProxy(proxy)
+	return err
+}
+
+// Transport returns the transport going through the proxy. The network's proxy is used
+// when proxy is empty. Transports are shared, so connections are reused.
+func (n *Network) Transport(proxy string) (http.RoundTripper, error) {
+	if proxy == "" {
+		proxy = n.conf.Proxy
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if t, ok := n.transports[proxy]; ok {
+		return t, nil
+	}
+
+	proxyFn := http.ProxyFromEnvironment
+	if proxy != "" {
+		u, err := parseProxy(proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxyFn = http.ProxyURL(u)
+	}
+	timeout := n.conf.connectTimeout()
+	t := &http.Transport{
+		Proxy: proxyFn,
+		DialContext: (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       n.tlsConfig,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		ForceAttemptHTTP2:     true,
+	}
+	n.transports[proxy] = t
+	return t, nil
+}
+
+// Client returns a client going through the proxy, sharing the cookie jar. The network's
+// proxy is used when proxy is empty. The client has no global timeout, as it is used for long downloads.
+func (n *Network) Client(proxy string) (*http.Client, error) {
+	t, err := n.Transport(proxy)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: t,
+		Jar:       n.jar,
+	}, nil
+}
+
+// NoRedirect returns a copy of the client that returns redirections instead of following them
+func NoRedirect(c *http.Client) *http.Client {
+	nc := *c
+	nc.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &nc
+}
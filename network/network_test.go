@@ -0,0 +1,66 @@
+package network
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestProxyAndCookies(t *testing.T) {
+	// The test server acts as a proxy: it receives requests for other hosts
+	hosts := []string{}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		if c, err := r.Cookie("session"); err == nil {
+			w.Write([]byte(c.Value))
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "42", MaxAge: 3600})
+	}))
+	defer proxy.Close()
+
+	cookieFile := filepath.Join(t.TempDir(), "cookies.json")
+	n, err := New(Config{Proxy: proxy.URL, CookieFile: cookieFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := n.Client("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get("http://www.example.com/page")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if want := []string{"", "42"}[i]; string(b) != want {
+			t.Errorf("request %d: got cookie %q, want %q", i, string(b), want)
+		}
+	}
+	if len(hosts) != 2 || hosts[0] != "www.example.com" {
+		t.Errorf("requests don't go through the proxy: %v", hosts)
+	}
+
+	err = n.Jar().Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := NewJar(cookieFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("http://www.example.com/")
+	if cookies := j.Cookies(u); len(cookies) != 1 || cookies[0].Value != "42" {
+		t.Errorf("cookies aren't restored: %v", cookies)
+	}
+
+	_, err = New(Config{Proxy: "ftp://proxy"})
+	if err == nil {
+		t.Errorf("expecting an error for an ftp proxy")
+	}
+}
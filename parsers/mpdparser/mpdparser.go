@@ -17,24 +17,44 @@ import (
 type MPDParser struct {
 	*MPD
 	ActualURL string
+	client    *http.Client
+}
+
+// Option of the parser
+type Option func(p *MPDParser)
+
+// WithClient gives the HTTP client used to get the MPD
+func WithClient(c *http.Client) Option {
+	return func(p *MPDParser) {
+		p.client = c
+	}
 }
 
 // NewMPDParser allocate a new MPD parser
-func NewMPDParser() *MPDParser {
-	return &MPDParser{}
+func NewMPDParser(opts ...Option) *MPDParser {
+	p := &MPDParser{
+		client: http.DefaultClient,
+	}
+	for _, o := range opts {
+		o(p)
+	}
+	return p
 }
 
 // Get queries the webserver, remember the redirected location and get the MPD
 func (p *MPDParser) Get(ctx context.Context, url string) error {
 	// Set up the HTTP request
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return err
 	}
 
-	// disable redirection system using the simple vanilla roundtripper
-	transport := http.Transport{}
-	resp, err := transport.RoundTrip(req)
+	// disable redirection, to remember the location
+	noRedirect := *p.client
+	noRedirect.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := noRedirect.Do(req)
 	if err != nil {
 		return err
 	}
@@ -48,7 +68,11 @@ func (p *MPDParser) Get(ctx context.Context, url string) error {
 		p.ActualURL = resp.Header.Get("Location")
 		resp.Body.Close()
 
-		resp, err = http.Get(p.ActualURL)
+		req, err = http.NewRequestWithContext(ctx, "GET", p.ActualURL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err = p.client.Do(req)
 		if err != nil {
 			return err
 		}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"regexp"
 	"strconv"
@@ -65,6 +66,7 @@ func (p *ArteTV) Configure(fns ...providers.ProviderConfigFn) {
 	for _, f := range fns {
 		c = f(c)
 	}
+	c.Log = c.Log.Subsystem(p.Name())
 	p.config = c
 }
 
@@ -128,7 +130,7 @@ func (p *ArteTV) getShowList(ctx context.Context, mr *matcher.MatchRequest) chan
 			var result APIResult
 			err = json.Unmarshal(r, &result)
 			if err != nil {
-				p.config.Log.Error().Printf("[%s] Can't decode search API result: %q", p.Name(), err)
+				p.config.ReportError(providers.NewError(providers.ErrProtocolChanged, p.Name(), apiSEARCH, fmt.Errorf("Can't decode search API result: %w", err)))
 				return
 			}
 
@@ -203,7 +205,8 @@ func (p *ArteTV) getShows(ctx context.Context, mr *matcher.MatchRequest, data []
 					PageURL:   ep.URL,
 					MediaType: nfo.TypeMovie,
 					// TVShow:    &tvshow,
-					Tag: []string{"Arte"},
+					Tag:     []string{"Arte"},
+					Runtime: ep.Duration / 60,
 				},
 			}
 
@@ -249,7 +252,7 @@ func (p *ArteTV) getSerie(ctx context.Context, mr *matcher.MatchRequest, d Data)
 		// TODO: use user's preferred language
 		// Refactoring. The collection's page contains a script with the full serie split per seasons, no need to call the api
 
-		parser := colly.NewCollector()
+		parser := providers.NewCollector(p.config)
 
 		pgm := InitialProgram{}
 		parser.OnHTML("body > script", func(e *colly.HTMLElement) {
@@ -266,12 +269,12 @@ func (p *ArteTV) getSerie(ctx context.Context, mr *matcher.MatchRequest, d Data)
 			js := e.Text[:end+1][start:]
 			err := json.NewDecoder(strings.NewReader(js)).Decode(&pgm)
 			if err != nil {
-				p.config.Log.Error().Printf("[%s] Can't parse JSON collection: %s", p.Name(), err)
+				p.config.ReportError(providers.NewError(providers.ErrProtocolChanged, p.Name(), d.URL, fmt.Errorf("Can't parse JSON collection: %w", err)))
 				return
 			}
 
 		})
-		p.config.HitsLimiter.Wait(ctx)
+		p.config.Wait(ctx)
 		if ctx.Err() == context.Canceled {
 			return
 		}
@@ -279,7 +282,7 @@ func (p *ArteTV) getSerie(ctx context.Context, mr *matcher.MatchRequest, d Data)
 		err := parser.Visit(d.URL)
 
 		if err != nil {
-			p.config.Log.Error().Printf("[%s] Can't visit URL %q: %s", p.Name(), err)
+			p.config.ReportError(fmt.Errorf("Can't visit URL %q: %w", d.URL, err))
 			return
 		}
 
@@ -347,6 +350,10 @@ func (p *ArteTV) getSerie(ctx context.Context, mr *matcher.MatchRequest, d Data)
 								Aired:     nfo.Aired(ep.Availability.Start),
 								PageURL:   ep.URL,
 								MediaType: mediaType,
+								Runtime:   ep.Duration / 60,
+
+								AvailableFrom:  ep.Availability.Start.Time(),
+								AvailableUntil: ep.Availability.EndTime(),
 							},
 						}
 
@@ -440,7 +447,7 @@ func (p *ArteTV) GetMediaDetails(ctx context.Context, m *media.Media) error {
 	player_url := fmt.Sprintf(arteDetails, info.UniqueID[0].ID) // TODO search for ARTE ID
 
 	if !info.IsDetailed {
-		parser := colly.NewCollector()
+		parser := providers.NewCollector(p.config)
 		pgm := InitialProgram{}
 		js := ""
 
@@ -459,20 +466,18 @@ func (p *ArteTV) GetMediaDetails(ctx context.Context, m *media.Media) error {
 
 		})
 
-		p.config.HitsLimiter.Wait(ctx)
+		p.config.Wait(ctx)
 		if ctx.Err() == context.Canceled {
 			return ctx.Err()
 		}
 
 		err := parser.Visit(info.PageURL)
 		if err != nil {
-			p.config.Log.Error().Printf("[%s] Can't visit URL %q: %s", p.Name(), err)
-			return err
+			return fmt.Errorf("Can't visit URL %q: %w", info.PageURL, err)
 		}
 		err = json.Unmarshal([]byte(js), &pgm)
 		if err != nil {
-			p.config.Log.Error().Printf("[%s] Can't parse JSON collection: %s", p.Name(), err)
-			return err
+			return providers.NewError(providers.ErrProtocolChanged, p.Name(), info.PageURL, fmt.Errorf("Can't parse JSON collection: %w", err))
 		}
 		for _, page := range pgm.Pages.List {
 			for _, zone := range page.Zones {
@@ -520,7 +525,7 @@ func (p *ArteTV) GetMediaDetails(ctx context.Context, m *media.Media) error {
 		}
 	}
 
-	p.config.Log.Trace().Printf("[%s] Title '%s' player url: %q", p.Name(), info.Title, player_url)
+	p.config.Log.Trace().Printf("Title '%s' player url: %q", info.Title, player_url)
 
 	client := providers.NewHTTPClient(p.config)
 	buf, err := client.Get(ctx, player_url, nil, nil)
@@ -531,7 +536,7 @@ func (p *ArteTV) GetMediaDetails(ctx context.Context, m *media.Media) error {
 	player := playerAPI{}
 	err = json.Unmarshal(buf, &player)
 	if err != nil {
-		return fmt.Errorf("Can't decode show's detailled information: %w", err)
+		return providers.NewError(providers.ErrProtocolChanged, p.Name(), player_url, fmt.Errorf("Can't decode show's detailled information: %w", err))
 	}
 
 	u, err := p.getBestVideo(player.VideoJSONPlayer.VSR)
@@ -543,6 +548,15 @@ func (p *ArteTV) GetMediaDetails(ctx context.Context, m *media.Media) error {
 	if info.Aired.Time().IsZero() {
 		info.Aired = nfo.Aired(player.VideoJSONPlayer.VRA.Time())
 	}
+	if info.AvailableFrom.IsZero() {
+		info.AvailableFrom = player.VideoJSONPlayer.VRA.Time()
+	}
+	if info.AvailableUntil.IsZero() {
+		info.AvailableUntil = player.VideoJSONPlayer.VRU.Time()
+	}
+	if info.Runtime == 0 {
+		info.Runtime = player.VideoJSONPlayer.VideoDurationSeconds / 60
+	}
 
 	// if info.TVShow != nil && !info.TVShow.HasEpisodes && info.Episode == 0 {
 	// 	info.Season = info.Aired.Time().Year()
@@ -567,5 +581,10 @@ func (p *ArteTV) getBestVideo(ss map[string]StreamInfo) (string, error) {
 			}
 		}
 	}
-	return "", errors.New("Can't find a suitable video stream")
+	return "", providers.NewError(providers.ErrUnavailable, p.Name(), "", errors.New("Can't find a suitable video stream"))
+}
+
+// IsCatalogQuery tells that search results and collection pages can be cached with the provider's CacheTTL
+func (p *ArteTV) IsCatalogQuery(r *http.Request) bool {
+	return r.URL.Host == "www.arte.tv" && (strings.HasSuffix(r.URL.Path, "/SEARCH_LISTING") || strings.Contains(r.URL.Path, "/videos/RC-"))
 }
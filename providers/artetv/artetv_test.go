@@ -0,0 +1,109 @@
+package artetv
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/simulot/aspiratv/matcher"
+	"github.com/simulot/aspiratv/media"
+	"github.com/simulot/aspiratv/providers/fixtures"
+)
+
+// newOffline returns a provider answered by recorded fixtures
+func newOffline(t *testing.T) *ArteTV {
+	p, err := New()
+	if err == nil {
+		err = fixtures.Offline(p, "testdata/fixtures")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func mediaList(t *testing.T, p *ArteTV, show string) []*media.Media {
+	list := []*media.Media{}
+	for m := range p.MediaList(context.Background(), []*matcher.MatchRequest{{Show: show, Provider: "artetv"}}) {
+		list = append(list, m)
+	}
+	return list
+}
+
+func describe(m *media.Media) string {
+	info := m.Metadata.GetMediaInfo()
+	return fmt.Sprintf("%s %s S%dE%d %s", m.ID, info.Showtitle, info.Season, info.Episode, info.Title)
+}
+
+func TestMediaList(t *testing.T) {
+	tests := []struct {
+		name string
+		show string
+		want []string
+	}{
+		{
+			name: "collection",
+			show: "karambolage",
+			want: []string{
+				"100001-001-A Karambolage S2E1 La baguette",
+				"100001-002-A Karambolage S2E2 Le bretzel",
+			},
+		},
+		{
+			name: "single program without bonus",
+			show: "le salaire de la peur",
+			want: []string{
+				"100002-000-A  S0E0 Le salaire de la peur",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, m := range mediaList(t, newOffline(t), tt.show) {
+				got = append(got, describe(m))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %q, want %q", got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGetMediaDetails(t *testing.T) {
+	p := newOffline(t)
+	list := mediaList(t, p, "le salaire de la peur")
+	if len(list) != 1 {
+		t.Fatalf("expecting 1 media, got %d", len(list))
+	}
+	m := list[0]
+	err := p.GetMediaDetails(context.Background(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := m.Metadata.GetMediaInfo()
+
+	if want := "https://arteptweb-a.akamaihd.net/100002-000-A_SQ_1_VF.mp4"; info.MediaURL != want {
+		t.Errorf("got media url %q, want %q", info.MediaURL, want)
+	}
+	if want := "2021-01-02"; info.Aired.Time().Format("2006-01-02") != want {
+		t.Errorf("got aired %s, want %s", info.Aired.Time().Format("2006-01-02"), want)
+	}
+	if len(info.Director) != 1 || info.Director[0] != "Henri-Georges Clouzot" {
+		t.Errorf("unexpected directors %q", info.Director)
+	}
+	if len(info.Actor) != 2 || info.Actor[0].Name != "Yves Montand" || info.Actor[0].Role != "Mario" || info.Actor[1].Name != "Charles Vanel" {
+		t.Errorf("unexpected actors %+v", info.Actor)
+	}
+	if len(info.Credits) != 1 || info.Credits[0] != "Georges Auric (Musique)" {
+		t.Errorf("unexpected credits %q", info.Credits)
+	}
+	if len(info.Thumb) != 1 || info.Thumb[0].URL != "https://api-cdn.arte.tv/img/large.jpg" {
+		t.Errorf("unexpected thumbnails %+v", info.Thumb)
+	}
+}
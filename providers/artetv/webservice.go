@@ -117,6 +117,15 @@ type Availability struct {
 	UpcomingDate tsUTC  `json:"upcomingDate"`
 }
 
+// EndTime returns the end of the availability, zero when unknown
+func (a Availability) EndTime() time.Time {
+	t, err := time.ParseInLocation(tsGuidefmt, a.End, utcTZ)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
 type Zones struct {
 	ID             string         `json:"id"`
 	Code           Code           `json:"code"`
@@ -0,0 +1,418 @@
+// Package cache keeps providers' HTTP responses on disk, to avoid downloading again
+// catalogs and search results that change only a few times a day.
+//
+// Responses are fresh as long as their Cache-Control or Expires headers say. Stale
+// responses having an ETag or a Last-Modified header are revalidated with a conditional
+// request. A TTL can override response headers. Only catalog and search pages are cached:
+// player and token answers are short-lived, and must never be replayed.
+package cache
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/simulot/aspiratv/metrics"
+)
+
+// entry describes a cached response. The body is in a separate file.
+type entry struct {
+	Provider string
+	Method   string
+	URL      string
+	Status   int
+	Header   http.Header
+	Stored   time.Time
+	Expires  time.Time // The response is fresh until this time
+	Size     int64     // Size of the body
+}
+
+// Store is a directory of cached responses
+type Store struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// Open returns the store kept in the directory. The directory is created when needed.
+func Open(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Dir returns the directory of the store
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// key returns the file name of the request's entry
+func key(method, url string) string {
+	h := sha1.Sum([]byte(method + " " + url))
+	return hex.EncodeToString(h[:])
+}
+
+// get reads the entry and its body, nil when the request isn't cached
+func (s *Store) get(method, url string) (*entry, []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := key(method, url)
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, k+".json"))
+	if err != nil {
+		return nil, nil
+	}
+	e := &entry{}
+	if json.Unmarshal(b, e) != nil || e.URL != url {
+		return nil, nil
+	}
+	body, err := ioutil.ReadFile(filepath.Join(s.dir, k+".body"))
+	if err != nil {
+		return nil, nil
+	}
+	return e, body
+}
+
+// put writes the entry and its body. The body is written first, so an entry is never
+// read without its body.
+func (s *Store) put(e *entry, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := os.MkdirAll(s.dir, 0777)
+	if err != nil {
+		return fmt.Errorf("Can't write cache: %w", err)
+	}
+	k := key(e.Method, e.URL)
+	if body != nil {
+		e.Size = int64(len(body))
+		err = writeFile(filepath.Join(s.dir, k+".body"), body)
+		if err != nil {
+			return err
+		}
+	}
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Can't encode cache entry: %w", err)
+	}
+	return writeFile(filepath.Join(s.dir, k+".json"), b)
+}
+
+// writeFile replaces the file once the new content is completely written
+func writeFile(path string, b []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return fmt.Errorf("Can't write cache: %w", err)
+	}
+	_, err = f.Write(b)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("Can't write cache: %w", err)
+	}
+	return nil
+}
+
+// Stats of a provider's entries
+type Stats struct {
+	Provider string
+	Entries  int
+	Fresh    int   // Entries that can be used without query
+	Size     int64 // Size of bodies
+}
+
+// Stats returns statistics by provider, sorted by provider
+func (s *Store) Stats() ([]Stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	byProvider := map[string]*Stats{}
+	err := s.walk(func(k string, e *entry) error {
+		st, ok := byProvider[e.Provider]
+		if !ok {
+			st = &Stats{Provider: e.Provider}
+			byProvider[e.Provider] = st
+		}
+		st.Entries++
+		st.Size += e.Size
+		if now.Before(e.Expires) {
+			st.Fresh++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats := []Stats{}
+	for _, st := range byProvider {
+		stats = append(stats, *st)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Provider < stats[j].Provider
+	})
+	return stats, nil
+}
+
+// Clear removes entries of the provider, all entries when provider is empty.
+// It returns the number of removed entries.
+func (s *Store) Clear(provider string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	err := s.walk(func(k string, e *entry) error {
+		if provider != "" && e.Provider != provider {
+			return nil
+		}
+		for _, ext := range []string{".json", ".body"} {
+			err := os.Remove(filepath.Join(s.dir, k+ext))
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("Can't clear cache: %w", err)
+			}
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// walk calls fn for each entry of the store. s.mu must be held.
+func (s *Store) walk(fn func(k string, e *entry) error) error {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return fmt.Errorf("Can't read cache: %w", err)
+		}
+		e := &entry{}
+		if json.Unmarshal(b, e) != nil {
+			continue
+		}
+		err = fn(strings.TrimSuffix(filepath.Base(f), ".json"), e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var cacheRequests = metrics.NewCounter("aspiratv_http_cache_requests_total", "Number of providers' requests by cache result (hit, revalidated, miss).", "provider", "result")
+
+// Transport is a http.RoundTripper answering GET requests from the store when possible
+type Transport struct {
+	store    *Store
+	next     http.RoundTripper
+	provider string
+	ttl      time.Duration              // Freshness of catalog pages, 0 to use response headers
+	catalog  func(r *http.Request) bool // Tells if the request is a catalog or search query, nil when none is
+	now      func() time.Time
+}
+
+// Option of the transport
+type Option func(t *Transport)
+
+// WithCatalog tells which requests are catalog or search queries. Only those are cached.
+func WithCatalog(catalog func(r *http.Request) bool) Option {
+	return func(t *Transport) {
+		t.catalog = catalog
+	}
+}
+
+// WithTTL keeps catalog and search pages fresh during ttl, whatever response headers say
+func WithTTL(ttl time.Duration) Option {
+	return func(t *Transport) {
+		t.ttl = ttl
+	}
+}
+
+// NewTransport returns a transport caching provider's catalog and search pages in the store.
+// Nothing is cached without WithCatalog. The default transport is used when next is nil.
+func NewTransport(store *Store, provider string, next http.RoundTripper, opts ...Option) *Transport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	t := &Transport{
+		store:    store,
+		next:     next,
+		provider: provider,
+		now:      time.Now,
+	}
+	for _, o := range opts {
+		o(t)
+	}
+	return t
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodGet || r.Header.Get("Range") != "" || t.catalog == nil || !t.catalog(r) {
+		return t.next.RoundTrip(r)
+	}
+	u := r.URL.String()
+	e, body := t.store.get(r.Method, u)
+	if e != nil && t.now().Before(e.Expires) {
+		cacheRequests.Inc(t.provider, "hit")
+		return e.response(r, body), nil
+	}
+
+	if e != nil {
+		// Revalidate the stale entry
+		etag, lastModified := e.Header.Get("ETag"), e.Header.Get("Last-Modified")
+		if etag != "" || lastModified != "" {
+			r = r.Clone(r.Context())
+			if etag != "" {
+				r.Header.Set("If-None-Match", etag)
+			}
+			if lastModified != "" {
+				r.Header.Set("If-Modified-Since", lastModified)
+			}
+		}
+	}
+
+	resp, err := t.next.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if e != nil && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		cacheRequests.Inc(t.provider, "revalidated")
+		for k, v := range resp.Header {
+			switch k {
+			case "Cache-Control", "Expires", "Date", "Etag", "Last-Modified":
+				e.Header[k] = v
+			}
+		}
+		e.Stored = t.now()
+		e.Expires = e.Stored.Add(t.freshness(r, e.Header))
+		err = t.store.put(e, nil)
+		if err != nil {
+			return nil, err
+		}
+		return e.response(r, body), nil
+	}
+
+	cacheRequests.Inc(t.provider, "miss")
+	if !t.storable(r, resp) {
+		return resp, nil
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	header := resp.Header.Clone()
+	header.Del("Set-Cookie")
+	header.Del("Content-Length")
+	now := t.now()
+	e = &entry{
+		Provider: t.provider,
+		Method:   r.Method,
+		URL:      u,
+		Status:   resp.StatusCode,
+		Header:   header,
+		Stored:   now,
+		Expires:  now.Add(t.freshness(r, header)),
+	}
+	err = t.store.put(e, b)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// storable tells if the response can be kept
+func (t *Transport) storable(r *http.Request, resp *http.Response) bool {
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	cc := cacheControl(resp.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	for _, v := range strings.Split(resp.Header.Get("Vary"), ",") {
+		if v := strings.TrimSpace(v); v != "" && !strings.EqualFold(v, "Accept-Encoding") {
+			return false
+		}
+	}
+	return t.freshness(r, resp.Header) > 0 || resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+}
+
+// freshness returns how long the response can be used without query
+func (t *Transport) freshness(r *http.Request, h http.Header) time.Duration {
+	if t.ttl > 0 {
+		return t.ttl
+	}
+	cc := cacheControl(h)
+	if _, ok := cc["no-cache"]; ok {
+		return 0
+	}
+	if v, ok := cc["max-age"]; ok {
+		s, err := strconv.Atoi(v)
+		if err != nil || s < 0 {
+			return 0
+		}
+		return time.Duration(s) * time.Second
+	}
+	if v := h.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0
+		}
+		date, err := http.ParseTime(h.Get("Date"))
+		if err != nil {
+			date = t.now()
+		}
+		if d := expires.Sub(date); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// cacheControl returns directives of the Cache-Control header
+func cacheControl(h http.Header) map[string]string {
+	cc := map[string]string{}
+	for _, d := range strings.Split(h.Get("Cache-Control"), ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		k, v := d, ""
+		if i := strings.Index(d, "="); i >= 0 {
+			k, v = d[:i], strings.Trim(d[i+1:], `"`)
+		}
+		cc[strings.ToLower(k)] = v
+	}
+	return cc
+}
+
+// response builds the response to the request from the entry
+func (e *entry) response(r *http.Request, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}
+}
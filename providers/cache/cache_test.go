@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransport(t *testing.T) {
+	queries := map[string]int{}
+	revalidated := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries[r.URL.Path]++
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=3600")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				revalidated++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/catalog":
+			w.Header().Set("Cache-Control", "max-age=0")
+		case "/nostore":
+			w.Header().Set("Cache-Control", "no-store, max-age=3600")
+		case "/player":
+			w.Header().Set("Cache-Control", "max-age=3600")
+		}
+		w.Write([]byte("page " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	store := Open(t.TempDir())
+	now := time.Now()
+	// Player answers are never cached
+	tr := NewTransport(store, "test", nil, WithCatalog(func(r *http.Request) bool {
+		return r.URL.Path != "/player"
+	}))
+	tr.now = func() time.Time { return now }
+	// The TTL overrides response headers
+	ttl := NewTransport(store, "test", nil, WithTTL(time.Hour), WithCatalog(func(r *http.Request) bool {
+		return strings.HasPrefix(r.URL.Path, "/catalog")
+	}))
+	ttl.now = tr.now
+
+	c := &http.Client{Transport: tr}
+	get := func(path string) {
+		t.Helper()
+		resp, err := c.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(b) != "page "+path {
+			t.Errorf("%s: expecting page, got %d %q", path, resp.StatusCode, b)
+		}
+	}
+
+	for _, path := range []string{"/fresh", "/etag", "/nostore", "/player"} {
+		get(path)
+		get(path)
+	}
+	c = &http.Client{Transport: ttl}
+	get("/catalog")
+	get("/catalog")
+	expected := map[string]int{"/fresh": 1, "/etag": 2, "/catalog": 1, "/nostore": 2, "/player": 2}
+	for path, n := range expected {
+		if queries[path] != n {
+			t.Errorf("%s: expecting %d queries, got %d", path, n, queries[path])
+		}
+	}
+	if revalidated != 1 {
+		t.Errorf("Expecting 1 revalidation, got %d", revalidated)
+	}
+
+	// The catalog TTL expires
+	now = now.Add(2 * time.Hour)
+	get("/catalog")
+	if queries["/catalog"] != 2 {
+		t.Errorf("/catalog: expecting a query once the TTL is expired, got %d queries", queries["/catalog"])
+	}
+
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].Entries != 3 {
+		t.Errorf("Expecting 3 entries for provider test, got %+v", stats)
+	}
+	n, err := store.Clear("test")
+	if err != nil || n != 3 {
+		t.Errorf("Expecting 3 removed entries, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,165 @@
+package providers
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/simulot/aspiratv/events"
+	"github.com/simulot/aspiratv/media"
+	"github.com/simulot/aspiratv/metadata/nfo"
+)
+
+// MinTitleSimilarity is the similarity of titles above which medias of different providers can be the same
+const MinTitleSimilarity = 0.8
+
+// Dedup is a stage shared by runners of different providers. It recognizes medias available on several
+// providers, like co-productions, and keeps only one copy according to the provider preference order of
+// the match request (PreferredProviders).
+//
+// Runners add discovered medias to the stage, then wait in Resolve until all runners have discovered
+// their medias.
+type Dedup struct {
+	mu         sync.Mutex
+	runners    int           // Runners still discovering medias
+	done       chan struct{} // Closed when all runners have discovered their medias
+	candidates []candidate
+	kept       map[*Runner][]*media.Media
+}
+
+type candidate struct {
+	r *Runner
+	m *media.Media
+}
+
+// NewDedup returns a stage shared by the given number of runners
+func NewDedup(runners int) *Dedup {
+	return &Dedup{
+		runners: runners,
+		done:    make(chan struct{}),
+		kept:    map[*Runner][]*media.Media{},
+	}
+}
+
+// Add a media discovered by the runner
+func (d *Dedup) Add(r *Runner, m *media.Media) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.candidates = append(d.candidates, candidate{r: r, m: m})
+}
+
+// Resolve tells that the runner has discovered all its medias, and waits for other runners.
+// It returns runner's medias to be downloaded. Duplicates are published as skipped.
+func (d *Dedup) Resolve(ctx context.Context, r *Runner) []*media.Media {
+	d.mu.Lock()
+	d.runners--
+	if d.runners == 0 {
+		d.resolve()
+		close(d.done)
+	}
+	d.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-d.done:
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.kept[r]
+}
+
+// resolve groups copies of the same media, and keeps the preferred one. d.mu must be held.
+func (d *Dedup) resolve() {
+	groups := [][]candidate{}
+	for _, c := range d.candidates {
+		found := false
+		for n, g := range groups {
+			if sameMedia(g, c) {
+				groups[n] = append(g, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			groups = append(groups, []candidate{c})
+		}
+	}
+
+	for _, g := range groups {
+		prefs := preferredProviders(g)
+		sort.SliceStable(g, func(i, j int) bool {
+			ri, rj := rank(prefs, g[i].r.p.Name()), rank(prefs, g[j].r.p.Name())
+			if ri != rj {
+				return ri < rj
+			}
+			return g[i].r.p.Name() < g[j].r.p.Name()
+		})
+		best := g[0]
+		d.kept[best.r] = append(d.kept[best.r], best.m)
+		for _, c := range g[1:] {
+			reason := "duplicate of " + best.r.p.Name() + " " + best.m.ID
+			c.r.log.With("media", c.m.ID).Info().Printf("Media %q is %s", c.m.Metadata.GetMediaInfo().Title, reason)
+			c.r.c.bus.Publish(events.MediaSkipped{Media: c.r.subject(c.m), Reason: reason})
+		}
+	}
+}
+
+// sameMedia tells if the candidate is a copy of the group's medias, found on another provider
+func sameMedia(g []candidate, c candidate) bool {
+	for _, o := range g {
+		if o.r.p.Name() == c.r.p.Name() {
+			return false
+		}
+	}
+	return SameMedia(g[0].m.Metadata.GetMediaInfo(), c.m.Metadata.GetMediaInfo())
+}
+
+// SameMedia tells if medias of different providers are the same: same show, similar titles, and same duration
+// or same broadcast day.
+func SameMedia(a, b *nfo.MediaInfo) bool {
+	if a.Showtitle != "" && b.Showtitle != "" && nfo.Normalize(a.Showtitle) != nfo.Normalize(b.Showtitle) {
+		return false
+	}
+	if nfo.Similarity(a.Title, b.Title) < MinTitleSimilarity {
+		return false
+	}
+	if a.Runtime > 0 && b.Runtime > 0 {
+		return closeRuntimes(a.Runtime, b.Runtime)
+	}
+	aired, other := a.Aired.Time(), b.Aired.Time()
+	return !aired.IsZero() && !other.IsZero() && aired.Format("2006-01-02") == other.Format("2006-01-02")
+}
+
+// closeRuntimes tells if durations differ by less than 10%, or 2 minutes for short medias
+func closeRuntimes(a, b int) bool {
+	d := a - b
+	if d < 0 {
+		d = -d
+	}
+	max := a
+	if b > max {
+		max = b
+	}
+	return d <= 2 || d*10 <= max
+}
+
+// preferredProviders returns the first provider preference order given by group's match requests
+func preferredProviders(g []candidate) []string {
+	for _, c := range g {
+		if c.m.Match != nil && len(c.m.Match.PreferredProviders) > 0 {
+			return c.m.Match.PreferredProviders
+		}
+	}
+	return nil
+}
+
+// rank of the provider in the preference order, providers not listed come last
+func rank(prefs []string, provider string) int {
+	for i, p := range prefs {
+		if p == provider {
+			return i
+		}
+	}
+	return len(prefs)
+}
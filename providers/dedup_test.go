@@ -0,0 +1,107 @@
+package providers
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/simulot/aspiratv/events"
+	"github.com/simulot/aspiratv/matcher"
+	"github.com/simulot/aspiratv/media"
+	"github.com/simulot/aspiratv/metadata/nfo"
+	"github.com/simulot/aspiratv/mylog"
+	"github.com/simulot/aspiratv/workers"
+)
+
+type fakeProvider struct {
+	name    string
+	medias  []*media.Media       // Medias listed by MediaList
+	details func(m *media.Media) // Called by GetMediaDetails
+
+	requests []*matcher.MatchRequest // Requests of the last call to MediaList
+}
+
+func (p *fakeProvider) Configure(fns ...ProviderConfigFn) {}
+func (p *fakeProvider) Name() string                      { return p.name }
+func (p *fakeProvider) MediaList(ctx context.Context, mr []*matcher.MatchRequest) chan *media.Media {
+	p.requests = mr
+	c := make(chan *media.Media, len(p.medias))
+	for _, m := range p.medias {
+		c <- m
+	}
+	close(c)
+	return c
+}
+func (p *fakeProvider) GetMediaDetails(ctx context.Context, m *media.Media) error {
+	if p.details != nil {
+		p.details(m)
+	}
+	return nil
+}
+
+func TestDedup(t *testing.T) {
+	ctx := context.Background()
+	log, err := mylog.NewLog("ERROR", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewBus()
+	skipped := []string{}
+	mu := sync.Mutex{}
+	defer bus.Subscribe(func(e events.Event) {
+		if e, ok := e.(events.MediaSkipped); ok {
+			mu.Lock()
+			skipped = append(skipped, e.Media.ID+": "+e.Reason)
+			mu.Unlock()
+		}
+	})()
+	pool := workers.New(ctx, 1, log)
+	defer pool.Stop(ctx)
+	newRunner := func(name string) *Runner {
+		return NewRunner(ctx, &Settings{}, &fakeProvider{name: name}, RunnerWithLogger(log), RunnerWithEvents(bus), RunnerWithPool(pool))
+	}
+	arte, ftv := newRunner("artetv"), newRunner("francetv")
+
+	aired := nfo.Aired(time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC))
+	newMedia := func(id string, mr *matcher.MatchRequest, info nfo.MediaInfo) *media.Media {
+		return &media.Media{ID: id, Match: mr, Metadata: &nfo.Movie{MediaInfo: info}}
+	}
+	preferArte := &matcher.MatchRequest{PreferredProviders: []string{"artetv", "francetv"}}
+	other := &matcher.MatchRequest{}
+
+	d := NewDedup(2)
+	d.Add(ftv, newMedia("ftv-1", other, nfo.MediaInfo{Title: "L'Odyssée des Cétacés", Runtime: 52}))
+	d.Add(ftv, newMedia("ftv-2", other, nfo.MediaInfo{Title: "Les Océans", Runtime: 52}))
+	d.Add(ftv, newMedia("ftv-3", other, nfo.MediaInfo{Title: "Au cœur des forêts", Aired: aired}))
+	d.Add(arte, newMedia("arte-1", preferArte, nfo.MediaInfo{Title: "L'odyssée des cétacés", Runtime: 53}))
+	d.Add(arte, newMedia("arte-2", preferArte, nfo.MediaInfo{Title: "Les Océans", Runtime: 90}))
+	d.Add(arte, newMedia("arte-3", preferArte, nfo.MediaInfo{Title: "Au coeur des forêts", Aired: aired}))
+
+	got := map[string][]string{}
+	wg := sync.WaitGroup{}
+	for _, r := range []*Runner{arte, ftv} {
+		wg.Add(1)
+		go func(r *Runner) {
+			defer wg.Done()
+			ids := []string{}
+			for _, m := range d.Resolve(ctx, r) {
+				ids = append(ids, m.ID)
+			}
+			mu.Lock()
+			got[r.p.Name()] = ids
+			mu.Unlock()
+		}(r)
+	}
+	wg.Wait()
+
+	if g := got["artetv"]; len(g) != 3 || g[0] != "arte-1" || g[1] != "arte-3" || g[2] != "arte-2" {
+		t.Errorf("Unexpected artetv medias: %v", g)
+	}
+	if g := got["francetv"]; len(g) != 1 || g[0] != "ftv-2" {
+		t.Errorf("Unexpected francetv medias: %v", g)
+	}
+	if len(skipped) != 2 || skipped[0] != "ftv-1: duplicate of artetv arte-1" || skipped[1] != "ftv-3: duplicate of artetv arte-3" {
+		t.Errorf("Unexpected skipped medias: %v", skipped)
+	}
+}
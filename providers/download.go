@@ -8,10 +8,12 @@ import (
 	"image"
 
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	// Image formats
 	_ "image/gif"
@@ -19,21 +21,29 @@ import (
 	_ "image/png"
 
 	"github.com/simulot/aspiratv/download"
+	"github.com/simulot/aspiratv/events"
+	"github.com/simulot/aspiratv/history"
 	"github.com/simulot/aspiratv/media"
 	"github.com/simulot/aspiratv/metadata/nfo"
+	"github.com/simulot/aspiratv/mylog"
+	"github.com/simulot/aspiratv/workers"
 )
 
 type downloader struct {
 	r           *Runner
+	log         *mylog.MyLog
 	crumbs                     // Collect created files to be deleted in event of cancellation
 	returnedErr error          // The final error
 	info        *nfo.MediaInfo // Metadata
 	mediaPath   string         // Path of Video
+	subject     events.Media   // Identification of the media in events
+	overwrite   bool           // Existing metadata files and thumbnails are replaced
 }
 
-func newDownloader(r *Runner) *downloader {
+func newDownloader(r *Runner, log *mylog.MyLog) *downloader {
 	return &downloader{
 		r:      r,
+		log:    log.Subsystem("downloader"),
 		crumbs: crumbs{},
 	}
 }
@@ -45,22 +55,32 @@ func (d *downloader) done(ctx context.Context) {
 	}
 	if d.returnedErr != nil {
 		d.crumbs.cleanFiles()
+		if !errors.Is(d.returnedErr, context.Canceled) {
+			countError(d.r.p.Name(), d.returnedErr)
+			alertProtocolChanged(d.log, d.returnedErr)
+		}
+		d.r.c.bus.Publish(events.DownloadFailed{Media: d.subject, Err: d.returnedErr})
+		return
 	}
+	if d.r.c.history != nil {
+		_, err := d.r.c.history.Add(history.NewEntry(d.info, d.mediaPath, history.SourceDownload))
+		if err != nil {
+			d.log.Error().Printf("%s", err)
+		}
+	}
+	d.r.c.bus.Publish(events.DownloadCompleted{Media: d.subject})
 }
 
-func (d *downloader) download(ctx context.Context, m *media.Media, fb FeedBacker) {
-	d.r.c.log.Trace().Printf("[downloader] [%s] Start downloading %q", d.r.p.Name(), m.ShowRootPath)
+func (d *downloader) download(ctx context.Context, m *media.Media, job *workers.Job) {
+	d.log.Trace().Printf("Start downloading %q", m.ShowRootPath)
 	defer func() {
 		defer d.done(ctx)
-		d.r.c.log.Trace().Printf("[downloader] [%s] Exit downloader.download(%s), %s", d.r.p.Name(), m.Metadata.GetMediaInfo().Title, ErrString(d.returnedErr))
+		d.log.Trace().Printf("Exit downloader.download(%s), %s", m.Metadata.GetMediaInfo().Title, ErrString(d.returnedErr))
 	}()
-	if fb != nil {
-		defer func() {
-			fb.Done()
-		}()
-	}
 
 	d.info = m.Metadata.GetMediaInfo()
+	d.subject = d.r.subject(m)
+	d.r.c.bus.Publish(events.DownloadStarted{Media: d.subject, JobID: job.ID})
 
 	// the media will be downladed into mediaPath file
 	d.mediaPath, d.returnedErr = download.MediaPath(m.ShowRootPath, m.Match, d.info)
@@ -79,7 +99,13 @@ func (d *downloader) download(ctx context.Context, m *media.Media, fb FeedBacker
 	itemName := filepath.Base(d.mediaPath)
 
 	// Get more details from the provider
-	d.returnedErr = d.r.p.GetMediaDetails(ctx, m)
+	d.stage("getting details...")
+	d.returnedErr = d.r.retry(ctx, func() error {
+		return d.r.p.GetMediaDetails(ctx, m)
+	})
+	if (d.returnedErr == nil || Permanent(d.returnedErr)) && d.info.Expired(time.Now()) {
+		d.returnedErr = d.r.expired(d.log, m)
+	}
 	if d.returnedErr != nil {
 		return
 	}
@@ -89,71 +115,86 @@ func (d *downloader) download(ctx context.Context, m *media.Media, fb FeedBacker
 		d.returnedErr = fmt.Errorf("Can't get url from %s: empty url", itemName)
 		return
 	}
-	d.r.c.log.Trace().Printf("[downloader] [%s] Player URL for '%s' is %q ", d.r.p.Name(), itemName, url)
-	if fb != nil {
-		fb.Stage("downloading media...")
-		fb.Total(1)
-	}
+	d.log.Trace().Printf("Player URL for '%s' is %q ", itemName, url)
+	d.stage("downloading media...")
 	d.crumbs.addFile(d.mediaPath)
 
-	d.returnedErr = download.Download(ctx, d.r.c.log, url, d.mediaPath, d.info, download.WithLogger(d.r.c.log), download.WithProgress(fb))
+	d.returnedErr = download.Download(ctx, d.log, url, d.mediaPath, d.info, download.WithLogger(d.log), download.WithEvents(d.r.c.bus, d.subject), download.WithPauser(job), download.WithClient(d.r.c.client), download.WithVerification(d.r.c.verification))
+	if errors.Is(d.returnedErr, download.ErrVerification) {
+		// The file is truncated or incomplete, the download is tried again later
+		d.log.Error().Printf("%s", d.returnedErr)
+		d.returnedErr = NewError(ErrTransient, d.r.p.Name(), url, d.returnedErr)
+	}
 	if d.returnedErr != nil {
 		return
 	}
 
 	// TODO implement DownloadNFO option
-	d.writeNFO(ctx, m, fb)
+	d.writeNFO(ctx, m)
+
+}
+
+// stage publishes the current stage of the download
+func (d *downloader) stage(stage string) {
+	d.r.c.bus.Publish(events.StageChanged{Media: d.subject, Stage: stage})
+}
 
+// nfoWritten publishes the creation of a metadata file
+func (d *downloader) nfoWritten(nfoPath string) {
+	d.r.c.bus.Publish(events.NFOWritten{Media: d.subject, NFOPath: nfoPath})
 }
 
-func (d *downloader) writeNFO(ctx context.Context, m *media.Media, fb FeedBacker) {
-	// fb.Stage("Thumbnails...")
+// writeNFO writes metadata files and thumbnails of the show, of the season and of the media. Files of
+// the show and of the season are written only when the media is in the show's directory.
+func (d *downloader) writeNFO(ctx context.Context, m *media.Media) {
+	d.stage("writing metadata...")
 
 	if d.info.MediaType != nfo.TypeMovie {
-		if d.info.TVShow != nil {
-			nfoPath := filepath.Join(m.Match.ShowRootPath, "tvshow.nfo")
-			nfoExists, err := fileExists(nfoPath)
-			if !nfoExists && err == nil {
-				d.crumbs.addFile(nfoPath)
-				d.info.TVShow.WriteNFO(nfoPath)
-				if err != nil {
-					d.r.c.log.Error().Printf("WriteNFO: %s", err)
-				}
-				d.downloadImages(ctx, nfoPath, d.info.TVShow.Thumb)
-			}
+		if d.info.TVShow != nil && strings.HasPrefix(d.mediaPath, m.ShowRootPath+string(filepath.Separator)) {
+			nfoPath := filepath.Join(m.ShowRootPath, "tvshow.nfo")
+			d.writeNFOFile(nfoPath, d.info.TVShow.WriteNFO)
+			d.downloadImages(ctx, nfoPath, d.info.TVShow.Thumb)
 		}
 		if d.info.SeasonInfo != nil {
 			seasonPath, err := download.SeasonPath(m.ShowRootPath, m.Match, d.info)
 			if err != nil {
-				d.r.c.log.Error().Printf("Can't dertermine season path: %s", err)
-			}
-			nfoPath := filepath.Join(seasonPath, "season.nfo")
-			nfoExists, err := fileExists(nfoPath)
-			if !nfoExists && err == nil {
-				d.addFile(nfoPath)
-				d.info.SeasonInfo.WriteNFO(nfoPath)
-				if err != nil {
-					d.r.c.log.Error().Printf("WriteNFO: %s", err)
-				}
+				d.log.Error().Printf("Can't dertermine season path: %s", err)
+			} else if filepath.Dir(d.mediaPath) == seasonPath {
+				nfoPath := filepath.Join(seasonPath, "season.nfo")
+				d.writeNFOFile(nfoPath, d.info.SeasonInfo.WriteNFO)
 				d.downloadImages(ctx, nfoPath, d.info.SeasonInfo.Thumb)
 			}
 		}
 	}
 
 	nfoPath := strings.TrimSuffix(d.mediaPath, filepath.Ext(d.mediaPath)) + ".nfo"
+	d.writeNFOFile(nfoPath, m.Metadata.WriteNFO)
+	d.downloadImages(ctx, nfoPath, d.info.Thumb)
+}
+
+// writeNFOFile writes the metadata file with write, unless it exists and d.overwrite is false
+func (d *downloader) writeNFOFile(nfoPath string, write func(string) error) {
 	nfoExists, err := fileExists(nfoPath)
-	if !nfoExists && err == nil {
+	if err != nil {
+		d.log.Error().Printf("WriteNFO: %s", err)
+		return
+	}
+	if nfoExists && !d.overwrite {
+		return
+	}
+	if !nfoExists {
 		d.addFile(nfoPath)
-		err = m.Metadata.WriteNFO(nfoPath)
-		if err != nil {
-			d.r.c.log.Error().Printf("WriteNFO: %s", err)
-		}
-		d.downloadImages(ctx, nfoPath, d.info.Thumb)
 	}
-
+	err = write(nfoPath)
+	if err != nil {
+		d.log.Error().Printf("WriteNFO: %s", err)
+		return
+	}
+	d.nfoWritten(nfoPath)
 }
 
 // downloadImages images listed in nfo.Thumbs into nfoPath. nfo's name  indicates if images are those from episode, season or show.
+// Existing images are kept, unless d.overwrite is true.
 func (d *downloader) downloadImages(ctx context.Context, nfoPath string, thumbs []nfo.Thumb) {
 
 	nfoDir := filepath.Dir(nfoPath)
@@ -177,20 +218,44 @@ func (d *downloader) downloadImages(ctx context.Context, nfoPath string, thumbs
 			}
 		}
 		thumbName := filepath.Join(nfoDir, base)
-		if thumbExists, _ := fileExists(thumbName); thumbExists {
+		if len(imageFiles(thumbName)) > 0 && !d.overwrite {
 			continue
 		}
 		d.downloadImage(ctx, thumb.URL, thumbName)
 	}
 }
 
+// imageFormats are formats of thumbnails, as named by image.DecodeConfig
+var imageFormats = []string{"png", "jpeg", "gif"}
+
+// imageFiles returns existing files of the image, whatever their format
+func imageFiles(imageName string) []string {
+	files := []string{}
+	base := strings.TrimSuffix(imageName, filepath.Ext(imageName))
+	for _, f := range imageFormats {
+		if ok, _ := fileExists(base + "." + f); ok {
+			files = append(files, base+"."+f)
+		}
+	}
+	return files
+}
+
 func (d *downloader) downloadImage(ctx context.Context, url, imageName string) {
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		d.log.Error().Printf("Can't get thumbnail: %s", err)
+		return
+	}
+	resp, err := d.r.c.client.Do(req)
 	if err != nil {
-		d.r.c.log.Error().Printf("[%s] Can't get thumbnail: %s", err)
+		d.log.Error().Printf("Can't get thumbnail: %s", err)
 		return
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		d.log.Error().Printf("Can't get thumbnail %s: %s", url, resp.Status)
+		return
+	}
 
 	// /!\ Test the exact type of image ... sometime .jpg is actually .png
 	var format string
@@ -198,26 +263,46 @@ func (d *downloader) downloadImage(ctx context.Context, url, imageName string) {
 
 	tr := io.TeeReader(resp.Body, buf)
 	_, format, err = image.DecodeConfig(tr)
+	if err != nil {
+		d.log.Error().Printf("Can't get thumbnail %s: %s", url, err)
+		return
+	}
 
 	// image with the correct extension
+	previous := imageFiles(imageName)
 	imageName = strings.TrimSuffix(imageName, filepath.Ext(imageName)) + "." + format
-	d.crumbs.addFile(imageName)
 
-	w, err := os.Create(imageName)
+	// The image replaces the existing one only once completely written
+	w, err := ioutil.TempFile(filepath.Dir(imageName), "."+filepath.Base(imageName)+"-")
 	if err != nil {
-		d.r.c.log.Error().Printf("[%s] Can't get thumbnail: %s", err)
+		d.log.Error().Printf("Can't get thumbnail: %s", err)
 		return
 	}
-	defer w.Close()
-
-	mr := io.MultiReader(buf, resp.Body)
-	_, err = io.Copy(w, mr)
-
+	_, err = io.Copy(w, io.MultiReader(buf, resp.Body))
+	if err1 := w.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Chmod(w.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(w.Name(), imageName)
+	}
 	if err != nil {
-		d.r.c.log.Error().Printf("[%s] Can't get thumbnail: %s", err)
+		os.Remove(w.Name())
+		d.log.Error().Printf("Can't get thumbnail: %s", err)
 		return
 	}
-	d.r.c.log.Trace().Printf("[%s] thumbnail %q downloaded.", d.r.p.Name(), imageName)
+	if len(previous) == 0 {
+		d.crumbs.addFile(imageName)
+	}
+	for _, f := range previous {
+		// The image is replaced by a new one in another format
+		if f != imageName {
+			os.Remove(f)
+		}
+	}
+	d.log.Trace().Printf("thumbnail %q downloaded.", imageName)
 }
 
 // crumbs collect files and dir names created during the process, to be able to
@@ -0,0 +1,60 @@
+package providers
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/simulot/aspiratv/events"
+	"github.com/simulot/aspiratv/mylog"
+)
+
+func TestDownloadImage(t *testing.T) {
+	ctx := context.Background()
+	log, err := mylog.NewLog("ERROR", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.Error(w, "not found", http.StatusNotFound)
+		case "/text":
+			w.Write([]byte("not an image"))
+		default:
+			png.Encode(w, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	thumb := filepath.Join(dir, "show_1.png")
+	previous := filepath.Join(dir, "show_1.jpeg")
+	err = ioutil.WriteFile(previous, []byte("previous"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRunner(ctx, &Settings{}, &fakeProvider{name: "fake"}, RunnerWithLogger(log), RunnerWithEvents(events.NewBus()))
+	defer r.WaitUntilCompletion(ctx)
+	d := newDownloader(r, log)
+
+	for _, path := range []string{"/missing", "/text"} {
+		d.downloadImage(ctx, srv.URL+path, thumb)
+		if files := imageFiles(thumb); len(files) != 1 || files[0] != previous {
+			t.Errorf("%s: thumbnails %v, want %s kept", path, files, previous)
+		}
+	}
+
+	d.downloadImage(ctx, srv.URL+"/image", thumb)
+	if files := imageFiles(thumb); len(files) != 1 || files[0] != thumb {
+		t.Errorf("Thumbnails %v, want %s only", files, thumb)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, ".*")); len(files) > 0 {
+		t.Errorf("Temporary files left: %v", files)
+	}
+}
@@ -0,0 +1,166 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/simulot/aspiratv/metrics"
+	"github.com/simulot/aspiratv/mylog"
+)
+
+// Kinds of provider errors. Errors returned by HTTPClient and providers can be
+// tested with errors.Is against these values.
+var (
+	ErrNotFound        = errors.New("not found")        // The page or the media doesn't exist
+	ErrGeoBlocked      = errors.New("geo-blocked")      // The media isn't available from this country
+	ErrUnavailable     = errors.New("unavailable")      // The media is expired, or not yet available
+	ErrRateLimited     = errors.New("rate limited")     // Too many queries, try later
+	ErrProtocolChanged = errors.New("protocol changed") // The site's answer can't be understood anymore
+	ErrTransient       = errors.New("transient error")  // Network or server error, try later
+)
+
+var errorKinds = []error{ErrNotFound, ErrGeoBlocked, ErrUnavailable, ErrRateLimited, ErrProtocolChanged, ErrTransient}
+
+// Error is an error of a provider, classified by its kind
+type Error struct {
+	Kind       error         // One of the ErrXXX values
+	Provider   string        // Provider's name
+	URL        string        // Queried URL, when known
+	Status     int           // HTTP status, 0 when the error isn't an HTTP error
+	RetryAfter time.Duration // Delay asked by the server before a new query, 0 when not given
+	Err        error         // Underlying error, can be nil
+}
+
+// NewError returns an error of the given kind
+func NewError(kind error, provider, url string, err error) *Error {
+	return &Error{
+		Kind:     kind,
+		Provider: provider,
+		URL:      url,
+		Err:      err,
+	}
+}
+
+func (e *Error) Error() string {
+	s := e.Kind.Error()
+	if e.Provider != "" {
+		s = e.Provider + ": " + s
+	}
+	if e.Status != 0 {
+		s += fmt.Sprintf(" (%d %s)", e.Status, http.StatusText(e.Status))
+	}
+	if e.URL != "" {
+		s += ": " + e.URL
+	}
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrXXX) true for the error's kind
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// StatusError classifies a HTTP response having an error status
+func StatusError(provider, url string, resp *http.Response) *Error {
+	e := NewError(ErrProtocolChanged, provider, url, nil)
+	e.Status = resp.StatusCode
+	switch {
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusGone:
+		e.Kind = ErrNotFound
+	case resp.StatusCode == http.StatusForbidden, resp.StatusCode == http.StatusUnavailableForLegalReasons:
+		e.Kind = ErrGeoBlocked
+	case resp.StatusCode == http.StatusTooManyRequests:
+		e.Kind = ErrRateLimited
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode >= 500:
+		e.Kind = ErrTransient
+	}
+	if e.Kind == ErrRateLimited || e.Kind == ErrTransient {
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+			e.RetryAfter = time.Duration(s) * time.Second
+		}
+	}
+	return e
+}
+
+// RequestError classifies an error that prevents to get a response. Errors due to the
+// cancellation of the context, and already classified errors are returned unchanged.
+func RequestError(ctx context.Context, provider, url string, err error) error {
+	var pe *Error
+	if ctx.Err() != nil || errors.As(err, &pe) {
+		return err
+	}
+	return NewError(ErrTransient, provider, url, err)
+}
+
+// ErrorKind returns the kind of the error as a string, "" when the error isn't classified
+func ErrorKind(err error) string {
+	for _, k := range errorKinds {
+		if errors.Is(err, k) {
+			return k.Error()
+		}
+	}
+	return ""
+}
+
+// Retryable returns true when the query can succeed later
+func Retryable(err error) bool {
+	return errors.Is(err, ErrTransient) || errors.Is(err, ErrRateLimited)
+}
+
+// Permanent returns true when retrying is useless: the media is missing, blocked or expired
+func Permanent(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrGeoBlocked) || errors.Is(err, ErrUnavailable)
+}
+
+// retryAfter returns the delay asked by the server, 0 when not given
+func retryAfter(err error) time.Duration {
+	var pe *Error
+	if errors.As(err, &pe) {
+		return pe.RetryAfter
+	}
+	return 0
+}
+
+var providerErrors = metrics.NewCounter("aspiratv_provider_errors_total", "Number of errors returned by providers, by kind of error.", "provider", "kind")
+
+// countError counts the error by kind
+func countError(provider string, err error) {
+	kind := ErrorKind(err)
+	if kind == "" {
+		kind = "other"
+	}
+	providerErrors.Inc(provider, kind)
+}
+
+// alertProtocolChanged logs errors showing that the provider's site has changed, as the provider must be fixed.
+// It returns true when the error is logged.
+func alertProtocolChanged(log *mylog.MyLog, err error) bool {
+	if !errors.Is(err, ErrProtocolChanged) {
+		return false
+	}
+	log.Error().Printf("The site seems to have changed, the provider must be updated: %s", err)
+	return true
+}
+
+// ReportError logs the error and counts it by kind
+func (c ProviderConfig) ReportError(err error) {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	countError(c.Name, err)
+	if !alertProtocolChanged(c.Log, err) {
+		c.Log.Error().Printf("%s", err)
+	}
+}
@@ -0,0 +1,92 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/simulot/aspiratv/mylog"
+)
+
+func TestHTTPErrors(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/blocked":
+			w.WriteHeader(http.StatusForbidden)
+		case "/busy":
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/flaky":
+			calls++
+			if calls < 3 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte("ok"))
+		default:
+			w.Write([]byte("<html><body>ok</body></html>"))
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	log, err := mylog.NewLog("ERROR", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewHTTPClient(ProviderConfig{Name: "test", Log: log})
+
+	tcs := []struct {
+		path      string
+		kind      error
+		permanent bool
+		retryable bool
+	}{
+		{"/missing", ErrNotFound, true, false},
+		{"/blocked", ErrGeoBlocked, true, false},
+		{"/busy", ErrRateLimited, false, true},
+	}
+	for _, tc := range tcs {
+		_, err = c.Get(ctx, srv.URL+tc.path, nil, nil)
+		if !errors.Is(err, tc.kind) {
+			t.Errorf("%s: got %v, want %v", tc.path, err, tc.kind)
+		}
+		if Permanent(err) != tc.permanent || Retryable(err) != tc.retryable {
+			t.Errorf("%s: unexpected policy for %v", tc.path, err)
+		}
+		col := NewCollector(ProviderConfig{Name: "test"})
+		err = col.Visit(srv.URL + tc.path)
+		if !errors.Is(err, tc.kind) {
+			t.Errorf("collector %s: got %v, want %v", tc.path, err, tc.kind)
+		}
+	}
+	_, err = c.Get(ctx, srv.URL+"/busy", nil, nil)
+	if got := retryAfter(err); got != 2*time.Second {
+		t.Errorf("retry after: got %s, want 2s", got)
+	}
+
+	// Transient errors are retried by the runner
+	retryDelay = time.Millisecond
+	r := &Runner{log: log}
+	err = r.retry(ctx, func() error {
+		_, err := c.Get(ctx, srv.URL+"/flaky", nil, nil)
+		return err
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("retry: got %v after %d calls", err, calls)
+	}
+	err = r.retry(ctx, func() error {
+		calls++
+		_, err := c.Get(ctx, srv.URL+"/missing", nil, nil)
+		return err
+	})
+	if !errors.Is(err, ErrNotFound) || calls != 4 {
+		t.Errorf("permanent errors must not be retried: %v after %d calls", err, calls)
+	}
+}
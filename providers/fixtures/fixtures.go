@@ -0,0 +1,224 @@
+// Package fixtures records HTTP exchanges with providers' web sites, and replays them
+// to test providers offline.
+//
+// Each exchange is stored in a directory as two files: NAME.json describes the request
+// and the response, NAME.body holds the response's body. NAME is made of the host and a hash
+// of the request. Only the content of the .json file is used to find the response of a request.
+package fixtures
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/simulot/aspiratv/mylog"
+	"github.com/simulot/aspiratv/providers"
+)
+
+// Fixture is a recorded HTTP exchange
+type Fixture struct {
+	Method      string
+	URL         string
+	RequestBody string `json:",omitempty"` // Used to match POST requests
+	Status      int
+	Header      http.Header `json:",omitempty"`
+	BodyFile    string      // Response's body, relative to the fixture directory
+}
+
+// key identifies a request
+func key(method, url, body string) string {
+	return method + " " + url + "\n" + body
+}
+
+// readRequestBody returns the body of the request, and restores it for the next transport
+func readRequestBody(r *http.Request) (string, error) {
+	if r.Body == nil {
+		return "", nil
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return string(b), nil
+}
+
+// Recorder is a http.RoundTripper that saves exchanges made through the next transport
+type Recorder struct {
+	dir  string
+	next http.RoundTripper
+	mu   sync.Mutex
+}
+
+// NewRecorder returns a transport that records exchanges into the directory.
+// The default transport is used when next is nil.
+func NewRecorder(dir string, next http.RoundTripper) *Recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Recorder{
+		dir:  dir,
+		next: next,
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (rec *Recorder) RoundTrip(r *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(r)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := rec.next.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	err = rec.save(r, reqBody, resp, b)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// save writes the fixture of the exchange
+func (rec *Recorder) save(r *http.Request, reqBody string, resp *http.Response, b []byte) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	h := sha1.Sum([]byte(key(r.Method, r.URL.String(), reqBody)))
+	name := r.URL.Hostname() + "-" + hex.EncodeToString(h[:])[:12]
+
+	header := resp.Header.Clone()
+	// The body is stored decoded
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+	header.Del("Set-Cookie")
+
+	f := Fixture{
+		Method:      r.Method,
+		URL:         r.URL.String(),
+		RequestBody: reqBody,
+		Status:      resp.StatusCode,
+		Header:      header,
+		BodyFile:    name + ".body",
+	}
+	js, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Can't encode fixture: %w", err)
+	}
+	err = os.MkdirAll(rec.dir, 0777)
+	if err != nil {
+		return fmt.Errorf("Can't record fixture: %w", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(rec.dir, f.BodyFile), b, 0644)
+	if err != nil {
+		return fmt.Errorf("Can't record fixture: %w", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(rec.dir, name+".json"), js, 0644)
+	if err != nil {
+		return fmt.Errorf("Can't record fixture: %w", err)
+	}
+	return nil
+}
+
+// Replayer is a http.RoundTripper that answers requests with recorded fixtures
+type Replayer struct {
+	dir      string
+	fixtures map[string]Fixture
+}
+
+// NewReplayer reads fixtures of the directory
+func NewReplayer(dir string) (*Replayer, error) {
+	rep := &Replayer{
+		dir:      dir,
+		fixtures: map[string]Fixture{},
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Can't read fixture: %w", err)
+		}
+		f := Fixture{}
+		err = json.Unmarshal(b, &f)
+		if err != nil {
+			return nil, fmt.Errorf("Can't decode fixture %s: %w", file, err)
+		}
+		if f.Method == "" {
+			f.Method = http.MethodGet
+		}
+		if f.Status == 0 {
+			f.Status = http.StatusOK
+		}
+		rep.fixtures[key(f.Method, f.URL, f.RequestBody)] = f
+	}
+	return rep, nil
+}
+
+// RoundTrip implements http.RoundTripper. Requests without fixture get an error.
+func (rep *Replayer) RoundTrip(r *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(r)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := rep.fixtures[key(r.Method, r.URL.String(), reqBody)]
+	if !ok {
+		return nil, fmt.Errorf("No fixture for %s %s", r.Method, r.URL.String())
+	}
+	var body io.ReadCloser = ioutil.NopCloser(strings.NewReader(""))
+	if f.BodyFile != "" {
+		b, err := ioutil.ReadFile(filepath.Join(rep.dir, f.BodyFile))
+		if err != nil {
+			return nil, fmt.Errorf("Can't read fixture: %w", err)
+		}
+		body = ioutil.NopCloser(bytes.NewReader(b))
+	}
+	header := f.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", f.Status, http.StatusText(f.Status)),
+		StatusCode: f.Status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body:       body,
+		Request:    r,
+	}, nil
+}
+
+// Offline configures the provider for tests: its requests are answered with the fixtures of
+// the directory, and only errors are logged.
+func Offline(p providers.Provider, dir string) error {
+	log, err := mylog.NewLog("ERROR", nil)
+	if err != nil {
+		return err
+	}
+	rep, err := NewReplayer(dir)
+	if err != nil {
+		return err
+	}
+	p.Configure(providers.ProviderLog(log), providers.ProviderTransport(rep))
+	return nil
+}
@@ -0,0 +1,68 @@
+package fixtures
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(r.Method + " " + r.URL.RequestURI() + " " + string(b)))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	requests := []struct {
+		method, path, body string
+	}{
+		{"GET", "/search?q=tintin", ""},
+		{"POST", "/search", `{"term":"tintin"}`},
+		{"POST", "/search", `{"term":"milou"}`},
+	}
+
+	rec := &http.Client{Transport: NewRecorder(dir, nil)}
+	want := []string{}
+	for _, r := range requests {
+		req, _ := http.NewRequest(r.method, srv.URL+r.path, strings.NewReader(r.body))
+		resp, err := rec.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		want = append(want, string(b))
+	}
+	srv.Close()
+
+	rep, err := NewReplayer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{Transport: rep}
+	for i, r := range requests {
+		req, _ := http.NewRequest(r.method, srv.URL+r.path, strings.NewReader(r.body))
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != want[i] {
+			t.Errorf("got %q, want %q", string(b), want[i])
+		}
+		if resp.StatusCode != http.StatusAccepted || resp.Header.Get("Content-Type") != "text/plain" {
+			t.Errorf("unexpected response %d %v", resp.StatusCode, resp.Header)
+		}
+	}
+
+	_, err = c.Get(srv.URL + "/unknown")
+	if err == nil {
+		t.Error("expecting an error for a request without fixture")
+	}
+}
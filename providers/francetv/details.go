@@ -3,6 +3,7 @@ package francetv
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
 	"strings"
@@ -48,7 +49,7 @@ type FTVPlayerVideo struct {
 
 func (p *FranceTV) GetMediaDetails(ctx context.Context, m *media.Media) error {
 	info := m.Metadata.GetMediaInfo()
-	parser := colly.NewCollector()
+	parser := providers.NewCollector(p.config)
 	videoID := ""
 
 	parser.OnHTML("meta", func(e *colly.HTMLElement) {
@@ -94,17 +95,20 @@ func (p *FranceTV) GetMediaDetails(ctx context.Context, m *media.Media) error {
 		var videos []FTVPlayerVideo
 
 		err := json.Unmarshal([]byte(s), &videos)
-		if err != nil {
-			p.config.Log.Error().Printf("[%s] Can't decode FTVPlayerVideo: %s", p.Name(), err)
+		if err != nil || len(videos) == 0 {
+			p.config.ReportError(providers.NewError(providers.ErrProtocolChanged, p.Name(), info.PageURL, fmt.Errorf("Can't decode FTVPlayerVideo: %v", err)))
 			return
 		}
 		videoID = videos[0].VideoID
 	})
-	p.config.HitsLimiter.Wait(ctx)
+	p.config.Wait(ctx)
 	err := parser.Visit(info.PageURL)
 	if err != nil {
 		return err
 	}
+	if videoID == "" {
+		return providers.NewError(providers.ErrUnavailable, p.Name(), info.PageURL, errors.New("no video in the page"))
+	}
 
 	err = p.getMediaURL(ctx, info, videoID)
 	return err
@@ -124,7 +128,7 @@ func (p *FranceTV) getMediaURL(ctx context.Context, info *nfo.MediaInfo, videoID
 	v.Set("gmt", "+1")
 
 	u := "https://player.webservices.francetelevisions.fr/v1/videos/" + videoID + "?" + v.Encode()
-	p.config.Log.Debug().Printf("[%s] Player URL for title '%s' is %q.", p.Name(), info.Title, u)
+	p.config.Log.Debug().Printf("Player URL for title '%s' is %q.", info.Title, u)
 
 	client := providers.NewHTTPClient(p.config)
 	resp, err := client.Get(ctx, u, v, nil)
@@ -135,12 +139,12 @@ func (p *FranceTV) getMediaURL(ctx context.Context, info *nfo.MediaInfo, videoID
 	pl := player{}
 	err = json.Unmarshal(resp, &pl)
 	if err != nil {
-		return fmt.Errorf("Can't decode player: %w", err)
+		return providers.NewError(providers.ErrProtocolChanged, p.Name(), u, fmt.Errorf("Can't decode player: %w", err))
 	}
 
 	// Get Token
 	if len(pl.Video.Token) > 0 {
-		p.config.Log.Debug().Printf("[%s] Player token for '%s' is %q ", p.Name(), info.Title, pl.Video.Token)
+		p.config.Log.Debug().Printf("Player token for '%s' is %q ", info.Title, pl.Video.Token)
 		r2, err := client.Get(ctx, pl.Video.Token, nil, nil)
 		if err != nil {
 			return fmt.Errorf("Can't get token %s: %w", pl.Video.Token, err)
@@ -150,10 +154,10 @@ func (p *FranceTV) getMediaURL(ctx context.Context, info *nfo.MediaInfo, videoID
 		}{}
 		err = json.Unmarshal(r2, &pl)
 		if err != nil {
-			return fmt.Errorf("Can't decode token's url : %w", err)
+			return providers.NewError(providers.ErrProtocolChanged, p.Name(), u, fmt.Errorf("Can't decode token's url: %w", err))
 		}
 		if len(pl.URL) == 0 {
-			return fmt.Errorf("Show's URL is empty")
+			return providers.NewError(providers.ErrUnavailable, p.Name(), u, errors.New("Show's URL is empty"))
 		}
 		info.MediaURL = pl.URL
 
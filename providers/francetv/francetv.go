@@ -45,6 +45,7 @@ func (p *FranceTV) Configure(fns ...providers.ProviderConfigFn) {
 	for _, f := range fns {
 		c = f(c)
 	}
+	c.Log = c.Log.Subsystem(p.Name())
 	p.config = c
 }
 
@@ -55,7 +56,7 @@ func (p *FranceTV) MediaList(ctx context.Context, mm []*matcher.MatchRequest) ch
 	go func() {
 		defer close(shows)
 		for _, m := range mm {
-			p.config.Log.Trace().Printf("[%s] Check matching request for %q", p.Name(), m.Show)
+			p.config.Log.Trace().Printf("Check matching request for %q", m.Show)
 
 			if m.Provider != "francetv" {
 				continue
@@ -68,7 +69,7 @@ func (p *FranceTV) MediaList(ctx context.Context, mm []*matcher.MatchRequest) ch
 				}
 			}
 		}
-		p.config.Log.Trace().Printf("[%s] MediaList is done", p.Name())
+		p.config.Log.Trace().Printf("MediaList is done")
 	}()
 	return shows
 }
@@ -0,0 +1,127 @@
+package francetv
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/simulot/aspiratv/matcher"
+	"github.com/simulot/aspiratv/media"
+	"github.com/simulot/aspiratv/providers/fixtures"
+)
+
+// newOffline returns a provider answered by recorded fixtures
+func newOffline(t *testing.T) *FranceTV {
+	p, err := New()
+	if err == nil {
+		err = fixtures.Offline(p, "testdata/fixtures")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func mediaList(t *testing.T, p *FranceTV, show string) []*media.Media {
+	list := []*media.Media{}
+	for m := range p.MediaList(context.Background(), []*matcher.MatchRequest{{Show: show, Provider: "francetv"}}) {
+		list = append(list, m)
+	}
+	return list
+}
+
+func describe(m *media.Media) string {
+	info := m.Metadata.GetMediaInfo()
+	return fmt.Sprintf("%s %s S%dE%d %s", m.ID, info.Showtitle, info.Season, info.Episode, info.Title)
+}
+
+func TestMediaList(t *testing.T) {
+	tests := []struct {
+		name string
+		show string
+		want []string
+	}{
+		{
+			name: "series page without bonus",
+			show: "un si grand soleil",
+			want: []string{
+				"2345678 Un si grand soleil S1E5 Le retour d'Elisabeth",
+			},
+		},
+		{
+			name: "movie from search results",
+			show: "la grande vadrouille",
+			want: []string{
+				"a1b2c3d4  S0E0 La grande vadrouille",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, m := range mediaList(t, newOffline(t), tt.show) {
+				got = append(got, describe(m))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %q, want %q", got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMovieMetadata(t *testing.T) {
+	list := mediaList(t, newOffline(t), "la grande vadrouille")
+	if len(list) != 1 {
+		t.Fatalf("expecting 1 media, got %d", len(list))
+	}
+	info := list[0].Metadata.GetMediaInfo()
+	if want := "2021-01-03"; info.Aired.Time().UTC().Format("2006-01-02") != want {
+		t.Errorf("got aired %s, want %s", info.Aired.Time().UTC().Format("2006-01-02"), want)
+	}
+	want := []string{"Bourvil/Augustin Bouvet", "Louis de Funès/Stanislas Lefort", "Gérard Oury/Director"}
+	got := []string{}
+	for _, a := range info.Actor {
+		role := a.Role
+		if role == "" {
+			role = a.Type
+		}
+		got = append(got, a.Name+"/"+role)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got actors %q, want %q", got, want)
+	}
+	if len(info.Thumb) != 1 || info.Thumb[0].URL != "https://www.france.tv/image/vignette_16x9/1024/a.jpg" {
+		t.Errorf("unexpected thumbnails %+v", info.Thumb)
+	}
+}
+
+func TestGetMediaDetails(t *testing.T) {
+	p := newOffline(t)
+	list := mediaList(t, p, "un si grand soleil")
+	if len(list) != 1 {
+		t.Fatalf("expecting 1 media, got %d", len(list))
+	}
+	m := list[0]
+	err := p.GetMediaDetails(context.Background(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := m.Metadata.GetMediaInfo()
+	if want := "https://cloudreplay.ftven.fr/0f1e2d3c/manifest.mpd?hdnea=exp=1609800000~acl=%2F*~hmac=0123456789abcdef"; info.MediaURL != want {
+		t.Errorf("got media url %q, want %q", info.MediaURL, want)
+	}
+	if want := "Elisabeth revient à Montpellier."; info.Plot != want {
+		t.Errorf("got plot %q, want %q", info.Plot, want)
+	}
+	if len(info.Actor) != 2 || info.Actor[1].Name != "Moïse Santamaria" {
+		t.Errorf("unexpected actors %+v", info.Actor)
+	}
+	if len(info.Director) != 1 || info.Director[0] != "Jérôme Navarro" {
+		t.Errorf("unexpected directors %q", info.Director)
+	}
+}
@@ -106,14 +106,14 @@ type Hits struct {
 	Characters              string                   `json:"characters"`
 	ProductionYear          int                      `json:"production_year"`
 	Dates                   map[string]UnixTimeStamp `json:"dates"`
-	// Ranges                  Ranges          `json:"ranges"`
-	Image         Image         `json:"image,omitempty"`
-	Categories    []Categories  `json:"categories"`
-	Channels      []Channels    `json:"channels"`
-	Program       Program       `json:"program"`
-	Season        seasonWrapper `json:"season"`
-	RatingCsaCode string        `json:"rating_csa_code"`
-	SiID          intOrString   `json:"si_id"`
+	Ranges                  Ranges                   `json:"ranges"`
+	Image                   Image                    `json:"image,omitempty"`
+	Categories              []Categories             `json:"categories"`
+	Channels                []Channels               `json:"channels"`
+	Program                 Program                  `json:"program"`
+	Season                  seasonWrapper            `json:"season"`
+	RatingCsaCode           string                   `json:"rating_csa_code"`
+	SiID                    intOrString              `json:"si_id"`
 	// FreeID        int        `json:"free_id"`
 	// OrangeID      string     `json:"orange_id"`
 	ObjectID string `json:"objectID"`
@@ -133,6 +133,33 @@ type Result struct {
 	Index            string `json:"index"`
 }
 
+// Range is an availability window
+type Range struct {
+	BeginDate UnixTimeStamp `json:"begin_date"`
+	EndDate   UnixTimeStamp `json:"end_date"`
+}
+
+// Ranges are availability windows by usage (replay) and by platform (web, free, orange...)
+type Ranges map[string]map[string]Range
+
+// UnmarshalJSON ignores ranges that can't be decoded, as they are given as an empty array when missing
+func (r *Ranges) UnmarshalJSON(b []byte) error {
+	m := map[string]map[string]Range{}
+	if json.Unmarshal(b, &m) == nil {
+		*r = m
+	}
+	return nil
+}
+
+// Replay returns the availability window of the replay on the web, zero times when unknown
+func (r Ranges) Replay() (from, until time.Time) {
+	w, ok := r["replay"]["web"]
+	if !ok {
+		return
+	}
+	return w.BeginDate.Time(), w.EndDate.Time()
+}
+
 type UnixTimeStamp time.Time
 
 func (v *UnixTimeStamp) UnmarshalJSON(b []byte) error {
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
@@ -38,13 +39,12 @@ func (p *FranceTV) search(ctx context.Context, mr *matcher.MatchRequest) chan *m
 
 	go func() {
 		var err error
-		p.config.Log.Trace().Printf("[%s] Search for %q", p.Name(), mr.Show)
+		p.config.Log.Trace().Printf("Search for %q", mr.Show)
 
 		defer func() {
-			p.config.Log.Trace().Printf("[%s] Search for %q is done", p.Name(), mr.Show)
+			p.config.Log.Trace().Printf("Search for %q is done", mr.Show)
 			if err != nil {
-				p.config.Log.Error().Printf("[%s] Can't search: %w", p.Name(), err)
-
+				p.config.ReportError(fmt.Errorf("Can't search %q: %w", mr.Show, err))
 			}
 			close(mm)
 		}()
@@ -63,27 +63,32 @@ func (p *FranceTV) search(ctx context.Context, mr *matcher.MatchRequest) chan *m
 		var body []byte
 		body, err = json.Marshal(rq)
 		if err != nil {
-			p.config.Log.Error().Printf("[%s] Can't encode request: %s", p.Name(), err)
+			p.config.Log.Error().Printf("Can't encode request: %s", err)
 			return
 		}
 
-		resp, err := client.Post(ctx, "https://www.france.tv/recherche/lancer/", nil, bytes.NewBuffer(body))
+		const searchURL = "https://www.france.tv/recherche/lancer/"
+		var resp []byte
+		resp, err = client.Post(ctx, searchURL, nil, bytes.NewBuffer(body))
+		if err != nil {
+			return
+		}
 
 		// resp is an encoded string containing a json object.
 		decResp := ""
 		err = json.Unmarshal(resp, &decResp)
 		if err != nil {
-			p.config.Log.Error().Printf("[%s] Can't decode search response: %s", p.Name(), err)
+			err = providers.NewError(providers.ErrProtocolChanged, p.Name(), searchURL, fmt.Errorf("Can't decode search response: %w", err))
 			return
 		}
 		if p.config.Log.IsDebug() {
-			p.config.Log.Debug().Printf("[%s] Decoded result\n%s", p.Name(), decResp)
+			p.config.Log.Debug().Printf("Decoded result\n%s", decResp)
 		}
 
 		results := map[string]query.Result{}
 		err = json.Unmarshal([]byte(decResp), &results)
 		if err != nil {
-			p.config.Log.Error().Printf("[%s] Can't decode API result: %s", p.Name(), err)
+			err = providers.NewError(providers.ErrProtocolChanged, p.Name(), searchURL, fmt.Errorf("Can't decode API result: %w", err))
 			return
 		}
 
@@ -99,7 +104,10 @@ func (p *FranceTV) search(ctx context.Context, mr *matcher.MatchRequest) chan *m
 
 		if len(series) > 0 {
 			for _, prog := range series {
-				p.visitPageSerie(ctx, mr, mm, prog.URLComplete)
+				err := p.visitPageSerie(ctx, mr, mm, prog.URLComplete)
+				if err != nil {
+					p.config.ReportError(fmt.Errorf("Can't get episodes of %q: %w", prog.Label, err))
+				}
 			}
 			return
 		}
@@ -152,6 +160,8 @@ func (p *FranceTV) search(ctx context.Context, mr *matcher.MatchRequest) chan *m
 						},
 					},
 				}
+				info.AvailableFrom, info.AvailableUntil = h.Ranges.Replay()
+				info.Runtime = int(h.Duration.Duration().Minutes())
 				info.Actor = []nfo.Actor{}
 				info.Tag = []string{}
 				if len(h.Program.Label) > 0 {
@@ -242,7 +252,7 @@ func (p *FranceTV) visitPageSerie(ctx context.Context, mr *matcher.MatchRequest,
 	// https://www.france.tv/series-et-fictions/series-policieres-thrillers/district-31
 	// https://www.france.tv/recherche/lancer/query=district+31\u0026hitsPerPage=20\u0026page=0\u0026filters=(class%3Aprogram%20OR%20class%3Aevent)%20AND%20(counters.web.integral_counter%20%3E%200%20OR%20counters.web.extract_counter%20%3E%200)%20AND%20NOT%20type%3Asaison%20AND%20NOT%20type%3Acomposite\u0026restrictSearchableAttributes=%5B%22label%22%2C%22title%22%2C%22description%22%2C%22seo%22%5D
 
-	parser := colly.NewCollector()
+	parser := providers.NewCollector(p.config)
 	page := 0
 	hits := 0
 	lastPageWithHits := 0
@@ -312,7 +322,7 @@ func (p *FranceTV) visitPageSerie(ctx context.Context, mr *matcher.MatchRequest,
 			}
 		})
 
-		p.config.Log.Trace().Printf("[%s] Found %q", p.Name(), info.Title)
+		p.config.Log.Trace().Printf("Found %q", info.Title)
 
 		media := &media.Media{
 			ID:    id,
@@ -329,10 +339,10 @@ func (p *FranceTV) visitPageSerie(ctx context.Context, mr *matcher.MatchRequest,
 	url = "https://www.france.tv/" + url + "/toutes-les-videos/"
 
 	for {
-		p.config.HitsLimiter.Wait(ctx)
+		p.config.Wait(ctx)
 		u := url + "?page=" + strconv.Itoa(page)
 
-		p.config.Log.Trace().Printf("[%s] Visiting page %q", p.Name(), u)
+		p.config.Log.Trace().Printf("Visiting page %q", u)
 		err := parser.Visit(u)
 		if err != nil {
 			return err
@@ -360,3 +370,8 @@ func (p *FranceTV) visitPageSerie(ctx context.Context, mr *matcher.MatchRequest,
 	}
 	return nil
 }
+
+// IsCatalogQuery tells that the pages listing a show's videos can be cached with the provider's CacheTTL
+func (p *FranceTV) IsCatalogQuery(r *http.Request) bool {
+	return r.URL.Host == "www.france.tv" && strings.HasSuffix(r.URL.Path, "/toutes-les-videos/")
+}
@@ -2,9 +2,11 @@ package gulli
 
 import (
 	"context"
+	"net/http"
 	"strings"
 
 	"github.com/gocolly/colly"
+	"github.com/simulot/aspiratv/providers"
 )
 
 type ShowEntry struct {
@@ -18,7 +20,7 @@ var catalogURL = "https://replay.gulli.fr/"
 func (p *Gulli) downloadCatalog(ctx context.Context) ([]ShowEntry, error) {
 	cat := []ShowEntry{}
 
-	parser := colly.NewCollector()
+	parser := providers.NewCollector(p.config)
 	parser.OnHTML("div.bloc_linkmore ul.multicolumn>li>a", func(e *colly.HTMLElement) {
 
 		entry := ShowEntry{
@@ -28,10 +30,15 @@ func (p *Gulli) downloadCatalog(ctx context.Context) ([]ShowEntry, error) {
 		cat = append(cat, entry)
 	})
 
-	p.config.Log.Debug().Printf("[%s] Catalog url: %q", p.Name(), catalogURL)
+	p.config.Log.Debug().Printf("Catalog url: %q", catalogURL)
 	err := parser.Visit(catalogURL)
 	if err != nil {
 		return nil, err
 	}
 	return cat, nil
 }
+
+// IsCatalogQuery tells that the catalog and show pages can be cached with the provider's CacheTTL
+func (p *Gulli) IsCatalogQuery(r *http.Request) bool {
+	return r.URL.Host == "replay.gulli.fr" && !strings.HasPrefix(r.URL.Path, "/jwplayer/")
+}
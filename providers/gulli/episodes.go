@@ -5,16 +5,17 @@ import (
 	"path"
 
 	"github.com/gocolly/colly"
+	"github.com/simulot/aspiratv/providers"
 )
 
 func (p *Gulli) getFirstEpisodeID(ctx context.Context, entry ShowEntry) (string, error) {
 
-	parser := colly.NewCollector()
+	parser := providers.NewCollector(p.config)
 	playerURL := ""
 	parser.OnHTML("div.bloc.bloc_listing ul li:first-child a", func(e *colly.HTMLElement) {
 		playerURL = e.Attr("href")
 	})
-	p.config.Log.Debug().Printf("[%s] Episode URL: %q", p.Name(), entry.URL)
+	p.config.Log.Debug().Printf("Episode URL: %q", entry.URL)
 	err := parser.Visit(entry.URL)
 	if err != nil {
 		return "", err
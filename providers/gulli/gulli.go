@@ -2,6 +2,7 @@ package gulli
 
 import (
 	"context"
+	"fmt"
 	"strings"
 
 	"github.com/simulot/aspiratv/matcher"
@@ -42,6 +43,7 @@ func (p *Gulli) Configure(fns ...providers.ProviderConfigFn) {
 	for _, f := range fns {
 		c = f(c)
 	}
+	c.Log = c.Log.Subsystem(p.Name())
 	p.config = c
 }
 
@@ -56,7 +58,7 @@ func (p *Gulli) MediaList(ctx context.Context, mm []*matcher.MatchRequest) chan
 		defer close(shows)
 		cat, err := p.downloadCatalog(ctx)
 		if err != nil {
-			p.config.Log.Error().Printf("[%s] Can't get replay catalog: %q", p.Name(), err)
+			p.config.ReportError(fmt.Errorf("Can't get replay catalog: %w", err))
 			return
 		}
 
@@ -64,9 +66,13 @@ func (p *Gulli) MediaList(ctx context.Context, mm []*matcher.MatchRequest) chan
 			for _, m := range mm {
 				if strings.Contains(strings.ToLower(s.Title), m.Show) {
 					ID, err := p.getFirstEpisodeID(ctx, s)
+					if err != nil {
+						p.config.ReportError(fmt.Errorf("Can't get episodes of %q: %w", s.Title, err))
+						continue
+					}
 					showTitles, err := p.getPlayer(ctx, m, ID)
 					if err != nil {
-						p.config.Log.Error().Printf("[%s] Can't decode replay catalog: %q", p.Name(), err)
+						p.config.ReportError(fmt.Errorf("Can't decode replay catalog: %w", err))
 						return
 					}
 					for _, s := range showTitles {
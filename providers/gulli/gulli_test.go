@@ -0,0 +1,90 @@
+package gulli
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/simulot/aspiratv/matcher"
+	"github.com/simulot/aspiratv/media"
+	"github.com/simulot/aspiratv/providers/fixtures"
+)
+
+// newOffline returns a provider answered by recorded fixtures
+func newOffline(t *testing.T) *Gulli {
+	p, err := New()
+	if err == nil {
+		err = fixtures.Offline(p, "testdata/fixtures")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestMediaList(t *testing.T) {
+	tests := []struct {
+		name string
+		show string
+		want []string // IDs, titles, season and episode numbers
+	}{
+		{
+			name: "known show",
+			show: "lapins crétins",
+			want: []string{
+				"VOD68875246543000 Les Lapins Crétins S4E12 Lapins au zoo",
+				"VOD68875246542000 Les Lapins Crétins S4E11 Lapins en vacances",
+			},
+		},
+		{
+			name: "unknown show",
+			show: "tintin",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newOffline(t)
+			got := []string{}
+			for m := range p.MediaList(context.Background(), []*matcher.MatchRequest{{Show: tt.show, Provider: "gulli"}}) {
+				got = append(got, describe(m))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %q, want %q", got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGetMediaDetails(t *testing.T) {
+	p := newOffline(t)
+	var m *media.Media
+	for found := range p.MediaList(context.Background(), []*matcher.MatchRequest{{Show: "lapins crétins", Provider: "gulli"}}) {
+		if m == nil {
+			m = found
+		}
+	}
+	if m == nil {
+		t.Fatal("no media found")
+	}
+	err := p.GetMediaDetails(context.Background(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := m.Metadata.GetMediaInfo()
+	if want := "https://gulli-replay.example/VOD68875246543000/master.m3u8"; info.MediaURL != want {
+		t.Errorf("got media url %q, want %q", info.MediaURL, want)
+	}
+	if want := "Les lapins s'invitent au zoo."; info.Plot != want {
+		t.Errorf("got plot %q, want %q", info.Plot, want)
+	}
+}
+
+func describe(m *media.Media) string {
+	info := m.Metadata.GetMediaInfo()
+	return fmt.Sprintf("%s %s S%dE%d %s", m.ID, info.Showtitle, info.Season, info.Episode, info.Title)
+}
@@ -26,7 +26,7 @@ var reVars = regexp.MustCompile(
 
 func (p *Gulli) getPlayer(ctx context.Context, mr *matcher.MatchRequest, ID string) ([]*media.Media, error) {
 
-	p.config.Log.Debug().Printf("[%s] Player URL: %q", p.Name(), gullyPlayer+ID)
+	p.config.Log.Debug().Printf("Player URL: %q", gullyPlayer+ID)
 
 	client := providers.NewHTTPClient(p.config)
 	b, err := client.Get(ctx, gullyPlayer+ID, nil, nil)
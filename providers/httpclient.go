@@ -6,17 +6,29 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/simulot/aspiratv/metrics"
+	"github.com/simulot/aspiratv/mylog"
 )
 
+var httpRequests = metrics.NewCounter("aspiratv_http_requests_total", "Number of HTTP requests sent to providers, by status code.", "provider", "code")
+
 type HTTPClient struct {
-	c ProviderConfig
+	c   ProviderConfig
+	log *mylog.MyLog
 }
 
 func NewHTTPClient(c ProviderConfig) *HTTPClient {
+	log := c.Log.Subsystem("http")
+	if c.Name != "" {
+		log = log.With("provider", c.Name)
+	}
 	return &HTTPClient{
-		c: c,
+		c:   c,
+		log: log,
 	}
 }
 
@@ -37,52 +49,53 @@ func (c *HTTPClient) newRequest(ctx context.Context, url string, body io.Reader,
 
 func (c *HTTPClient) do(ctx context.Context, method string, r *http.Request) ([]byte, error) {
 	httpC := &http.Client{
-		Timeout: 10 * time.Second,
+		Timeout:   c.c.RequestTimeout(),
+		Transport: c.c.Transport,
+		Jar:       c.c.Jar,
 	}
 
-	if c.c.Log.IsDebug() {
+	if c.log.IsDebug() {
 		httpC.Timeout = 10 * time.Minute
 	}
 
-	if c.c.HitsLimiter != nil {
-		err := c.c.HitsLimiter.Wait(ctx)
-		if err != nil {
-			return nil, err
-		}
+	err := c.c.Wait(ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	r.Method = method
 	resp, err := httpC.Do(r)
 	if err != nil {
-		c.c.Log.Error().Printf("[%s] %s: %s", r.Method, r.URL.String(), err)
-		return nil, err
+		httpRequests.Inc(c.c.Name, "error")
+		c.log.Error().Printf("[%s] %s: %s", r.Method, r.URL.String(), err)
+		return nil, RequestError(ctx, c.c.Name, r.URL.String(), err)
 	}
+	httpRequests.Inc(c.c.Name, strconv.Itoa(resp.StatusCode))
 
 	defer resp.Body.Close()
 	buf, err := io.ReadAll(resp.Body)
 	if err != nil {
-		c.c.Log.Error().Printf("[%s] %s: (%s): %s", r.Method, r.URL.String(), resp.Status, err)
-		return nil, err
+		c.log.Error().Printf("[%s] %s: (%s): %s", r.Method, r.URL.String(), resp.Status, err)
+		return nil, RequestError(ctx, c.c.Name, r.URL.String(), err)
 	}
 
 	if resp.StatusCode >= 400 {
-		c.c.Log.Error().Printf("[%s] %s: (%s): %s", r.Method, r.URL.String(), resp.Status, string(buf))
-		return nil, err
-
+		c.log.Error().Printf("[%s] %s: (%s): %s", r.Method, r.URL.String(), resp.Status, string(buf))
+		return nil, StatusError(c.c.Name, r.URL.String(), resp)
 	}
 
-	if c.c.Log.IsDebug() {
-		c.c.Log.Trace().Printf("[%s] %s:%s Content-Type:%q", r.Method, r.URL.String(), resp.Status, resp.Header.Get("content-type"))
+	if c.log.IsDebug() {
+		c.log.Trace().Printf("[%s] %s:%s Content-Type:%q", r.Method, r.URL.String(), resp.Status, resp.Header.Get("content-type"))
 		if strings.Contains(resp.Header.Get("content-type"), "json") {
-			c.c.Log.Trace().Printf("[%s]\n%s", r.Method, string(buf))
+			c.log.Trace().Printf("[%s]\n%s", r.Method, string(buf))
 		} else {
 			f, err := os.CreateTemp("", "*.dump")
 			if err != nil {
-				c.c.Log.Fatal().Printf("Can't dump response to file: %w", err)
+				c.log.Fatal().Printf("Can't dump response to file: %s", err)
 				return nil, err
 			}
 			defer f.Close()
-			c.c.Log.Debug().Printf("Response dumped in %q", f.Name())
+			c.log.Debug().Printf("Response dumped in %q", f.Name())
 			_, err = f.Write(buf)
 		}
 	}
@@ -2,10 +2,15 @@ package providers
 
 import (
 	"context"
+	"net/http"
+	"time"
 
+	"github.com/gocolly/colly"
 	"github.com/simulot/aspiratv/matcher"
 	"github.com/simulot/aspiratv/media"
+	"github.com/simulot/aspiratv/metrics"
 	"github.com/simulot/aspiratv/mylog"
+	"github.com/simulot/aspiratv/network"
 	"golang.org/x/time/rate"
 )
 
@@ -17,6 +22,12 @@ type Provider interface {
 	GetMediaDetails(context.Context, *media.Media) error                  // Download more details when available
 }
 
+// CatalogProvider is implemented by providers telling which queries list their catalog
+// or search results. Responses of these queries are cached during the provider's CacheTTL.
+type CatalogProvider interface {
+	IsCatalogQuery(r *http.Request) bool
+}
+
 // register of imported providers
 var providers = map[string]Provider{}
 
@@ -32,14 +43,25 @@ func List() map[string]Provider {
 
 // Config carries the configuration to providers
 type ProviderConfig struct {
+	Name        string        // Provider's name, used for metrics
 	Log         *mylog.MyLog  // Logger
 	HitsLimiter *rate.Limiter // Limit the number of hits per second
 	// TODO ByteLimiter *rate.Limiter // Limit the number of bytes per second
-	UserAgent string // User agent to use for queries
+	UserAgent string            // User agent to use for queries
+	Transport http.RoundTripper // Transport used for queries, nil for the default one
+	Jar       http.CookieJar    // Cookies of queries, nil for none
+	Timeout   time.Duration     // Timeout of a query, network.DefaultTimeout when 0
 }
 
 type ProviderConfigFn func(c ProviderConfig) ProviderConfig
 
+func ProviderName(name string) ProviderConfigFn {
+	return func(c ProviderConfig) ProviderConfig {
+		c.Name = name
+		return c
+	}
+}
+
 func ProviderLog(l *mylog.MyLog) ProviderConfigFn {
 	return func(c ProviderConfig) ProviderConfig {
 		c.Log = l
@@ -60,3 +82,96 @@ func ProviderUserAgent(agent string) ProviderConfigFn {
 		return c
 	}
 }
+
+// ProviderTransport gives the transport used for provider's queries, to record or replay them
+func ProviderTransport(t http.RoundTripper) ProviderConfigFn {
+	return func(c ProviderConfig) ProviderConfig {
+		c.Transport = t
+		return c
+	}
+}
+
+// ProviderCookieJar gives the cookie jar shared by provider's queries
+func ProviderCookieJar(jar http.CookieJar) ProviderConfigFn {
+	return func(c ProviderConfig) ProviderConfig {
+		c.Jar = jar
+		return c
+	}
+}
+
+// ProviderTimeout gives the time allowed to a provider's query
+func ProviderTimeout(timeout time.Duration) ProviderConfigFn {
+	return func(c ProviderConfig) ProviderConfig {
+		c.Timeout = timeout
+		return c
+	}
+}
+
+// RequestTimeout returns the time allowed to a query
+func (c ProviderConfig) RequestTimeout() time.Duration {
+	if c.Timeout == 0 {
+		return network.DefaultTimeout * time.Second
+	}
+	return c.Timeout
+}
+
+// Collector is a colly collector returning provider errors
+type Collector struct {
+	*colly.Collector
+	name    string
+	lastErr error // Error of the last response
+}
+
+// NewCollector returns a colly collector using provider's transport, cookies, timeout and user agent
+func NewCollector(c ProviderConfig) *Collector {
+	col := &Collector{
+		Collector: colly.NewCollector(),
+		name:      c.Name,
+	}
+	if c.Transport != nil {
+		col.WithTransport(c.Transport)
+	}
+	if c.Jar != nil {
+		col.SetCookieJar(c.Jar)
+	}
+	col.SetRequestTimeout(c.RequestTimeout())
+	if c.UserAgent != "" {
+		col.UserAgent = c.UserAgent
+	}
+	col.OnError(func(r *colly.Response, err error) {
+		u := r.Request.URL.String()
+		if r.StatusCode >= 400 {
+			resp := &http.Response{StatusCode: r.StatusCode}
+			if r.Headers != nil {
+				resp.Header = *r.Headers
+			}
+			col.lastErr = StatusError(col.name, u, resp)
+			return
+		}
+		col.lastErr = NewError(ErrTransient, col.name, u, err)
+	})
+	return col
+}
+
+// Visit gets the page and calls collector's callbacks. The error is classified.
+func (col *Collector) Visit(u string) error {
+	col.lastErr = nil
+	err := col.Collector.Visit(u)
+	if err != nil && col.lastErr != nil {
+		return col.lastErr
+	}
+	return err
+}
+
+var rateLimiterWait = metrics.NewHistogram("aspiratv_ratelimiter_wait_seconds", "Time spent waiting for the provider's rate limiter.", nil, "provider")
+
+// Wait blocks until the hits limiter allows a new query
+func (c ProviderConfig) Wait(ctx context.Context) error {
+	if c.HitsLimiter == nil {
+		return ctx.Err()
+	}
+	start := time.Now()
+	err := c.HitsLimiter.Wait(ctx)
+	rateLimiterWait.Observe(time.Since(start).Seconds(), c.Name)
+	return err
+}
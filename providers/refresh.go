@@ -0,0 +1,92 @@
+package providers
+
+import (
+	"context"
+
+	"github.com/simulot/aspiratv/download"
+	"github.com/simulot/aspiratv/matcher"
+	"github.com/simulot/aspiratv/metadata/nfo"
+)
+
+// RefreshMetadata queries the provider again for the shows of the history, even when they are no longer
+// watched, gets details of medias found in the history, and writes metadata files and thumbnails of the show,
+// of the season and of the media next to the downloaded video. Existing files are kept, unless overwrite is true.
+// Requests of the watch list give the layout of their show.
+// It returns the number of refreshed medias. Medias no longer available on the provider can't be refreshed.
+func (r *Runner) RefreshMetadata(ctx context.Context, mr []*matcher.MatchRequest, overwrite bool) int {
+	if r.c.history == nil {
+		return 0
+	}
+	refreshed := 0
+	seen := map[string]bool{}
+	for m := range r.p.MediaList(ctx, r.historyRequests(mr)) {
+		if ctx.Err() != nil {
+			break
+		}
+		if seen[m.ID] {
+			continue
+		}
+		seen[m.ID] = true
+		r.setShowRootPath(m)
+		log := r.log.With("media", m.ID, "show", m.Metadata.GetMediaInfo().Showtitle)
+
+		e := r.c.history.Find(m.Metadata.GetMediaInfo())
+		if e == nil {
+			continue
+		}
+		// The video is where it has been downloaded or imported, or where it would be downloaded now
+		videoPath := e.Path
+		if ok, _ := fileExists(videoPath); !ok {
+			p, err := download.MediaPath(m.ShowRootPath, m.Match, m.Metadata.GetMediaInfo())
+			if ok, _ := fileExists(p); err != nil || !ok {
+				log.Info().Printf("Can't refresh metadata of %q: video not found", m.Metadata.GetMediaInfo().Title)
+				continue
+			}
+			videoPath = p
+		}
+
+		err := r.retry(ctx, func() error {
+			return r.p.GetMediaDetails(ctx, m)
+		})
+		if err != nil {
+			log.Error().Printf("Can't refresh metadata of %q: %s", m.Metadata.GetMediaInfo().Title, err)
+			continue
+		}
+
+		d := newDownloader(r, log)
+		d.info = m.Metadata.GetMediaInfo()
+		d.subject = r.subject(m)
+		d.mediaPath = videoPath
+		d.overwrite = overwrite
+		d.writeNFO(ctx, m)
+		log.Trace().Printf("Metadata of %q refreshed", videoPath)
+		refreshed++
+	}
+	return refreshed
+}
+
+// historyRequests returns a request per show of the history. The request of the watch list is used when
+// the show is watched, without its age limit so older medias are listed too.
+func (r *Runner) historyRequests(mr []*matcher.MatchRequest) []*matcher.MatchRequest {
+	watched := map[string]*matcher.MatchRequest{}
+	for _, m := range mr {
+		watched[nfo.Normalize(m.Show)] = m
+	}
+	requests := []*matcher.MatchRequest{}
+	shows := map[string]bool{}
+	for _, e := range r.c.history.Entries() {
+		show := nfo.Normalize(e.Show)
+		if show == "" || shows[show] {
+			continue
+		}
+		shows[show] = true
+		req := &matcher.MatchRequest{Provider: r.p.Name(), Show: e.Show}
+		if w, ok := watched[show]; ok {
+			c := *w
+			c.MaxAgedDays = 0
+			req = &c
+		}
+		requests = append(requests, req)
+	}
+	return requests
+}
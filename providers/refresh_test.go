@@ -0,0 +1,116 @@
+package providers
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/simulot/aspiratv/events"
+	"github.com/simulot/aspiratv/history"
+	"github.com/simulot/aspiratv/matcher"
+	"github.com/simulot/aspiratv/media"
+	"github.com/simulot/aspiratv/metadata/nfo"
+	"github.com/simulot/aspiratv/mylog"
+)
+
+func TestRefreshMetadata(t *testing.T) {
+	ctx := context.Background()
+	log, err := mylog.NewLog("ERROR", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		png.Encode(w, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	mr := &matcher.MatchRequest{Show: "Les Lapins Crétins", Destination: "Jeunesse", MaxAgedDays: 30}
+	newMedia := func() *media.Media {
+		return &media.Media{ID: "1234", Match: mr, Metadata: &nfo.EpisodeDetails{MediaInfo: nfo.MediaInfo{
+			Showtitle:  "Les Lapins Crétins",
+			Title:      "Lapin bricoleur",
+			Season:     1,
+			Episode:    2,
+			MediaType:  nfo.TypeSeries,
+			UniqueID:   []nfo.ID{{Type: "fake", ID: "1234"}},
+			TVShow:     &nfo.TVShow{Title: "Les Lapins Crétins"},
+			SeasonInfo: &nfo.Season{Seasonnumber: "1"},
+		}}}
+	}
+	p := &fakeProvider{
+		name: "fake",
+		details: func(m *media.Media) {
+			info := m.Metadata.GetMediaInfo()
+			info.Plot = "Les lapins réparent tout."
+			info.Thumb = []nfo.Thumb{{Aspect: "thumb", URL: srv.URL}}
+		},
+	}
+
+	h, err := history.Open(filepath.Join(dir, "history.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	show := filepath.Join(dir, "Les Lapins Crétins")
+	season := filepath.Join(show, "Season 01")
+	video := filepath.Join(season, "Les Lapins Crétins - s01e02 - Lapin bricoleur.mp4")
+	err = os.MkdirAll(season, 0777)
+	if err == nil {
+		err = ioutil.WriteFile(video, []byte("video"), 0666)
+	}
+	if err == nil {
+		err = (&nfo.EpisodeDetails{MediaInfo: nfo.MediaInfo{Title: "Lapin bricoleur"}}).WriteNFO(strings.TrimSuffix(video, ".mp4") + ".nfo")
+	}
+	if err == nil {
+		_, err = h.Add(history.NewEntry(newMedia().Metadata.GetMediaInfo(), video, history.SourceDownload))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRunner(ctx, &Settings{Destinations: map[string]string{"Jeunesse": dir}}, p, RunnerWithLogger(log), RunnerWithEvents(events.NewBus()), RunnerWithHistory(h))
+	defer r.WaitUntilCompletion(ctx)
+	readNFO := func() string {
+		b, err := ioutil.ReadFile(strings.TrimSuffix(video, ".mp4") + ".nfo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	p.medias = []*media.Media{newMedia()}
+	if n := r.RefreshMetadata(ctx, []*matcher.MatchRequest{mr}, false); n != 1 {
+		t.Fatalf("RefreshMetadata() = %d, want 1", n)
+	}
+	for _, f := range []string{filepath.Join(show, "tvshow.nfo"), filepath.Join(season, "season.nfo"), strings.TrimSuffix(video, ".mp4") + "_1.png"} {
+		if ok, _ := fileExists(f); !ok {
+			t.Errorf("%s not written", f)
+		}
+	}
+	if strings.Contains(readNFO(), "réparent") {
+		t.Errorf("Existing metadata file overwritten")
+	}
+
+	if len(p.requests) != 1 || p.requests[0].Destination != mr.Destination || p.requests[0].MaxAgedDays != 0 {
+		t.Errorf("Watched show not requested with its watch list request without age limit: %+v", p.requests)
+	}
+
+	// The show is no longer watched
+	p.medias = []*media.Media{newMedia()}
+	if n := r.RefreshMetadata(ctx, nil, true); n != 1 {
+		t.Fatalf("RefreshMetadata() = %d, want 1", n)
+	}
+	if !strings.Contains(readNFO(), "réparent") {
+		t.Errorf("Metadata file not overwritten")
+	}
+	if len(p.requests) != 1 || p.requests[0].Show != "Les Lapins Crétins" || p.requests[0].Provider != "fake" {
+		t.Errorf("Show of the history not requested: %+v", p.requests)
+	}
+}
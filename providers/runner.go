@@ -2,12 +2,18 @@ package providers
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
 	"sync"
+	"time"
 
 	"github.com/simulot/aspiratv/download"
+	"github.com/simulot/aspiratv/events"
+	"github.com/simulot/aspiratv/history"
+	"github.com/simulot/aspiratv/library"
 	"github.com/simulot/aspiratv/matcher"
 	"github.com/simulot/aspiratv/media"
 	"github.com/simulot/aspiratv/mylog"
@@ -21,61 +27,115 @@ func ErrString(err error) interface{} {
 	return err
 }
 
-type FeedBacker interface {
-	Stage(stage string) // Indicate current stage
-	Total(total int)    // Indicate the total number (could be bytes, percent )
-	Update(current int) // Indicate the current position
-	Done()              // Call when the task is done
-}
-
 type RunnerConfig struct {
 	log                *mylog.MyLog
+	bus                *events.Bus     // Where runner's events are published
+	pool               *workers.Worker // Pool shared by runners, nil to use runner's own pool
 	concurentDownloads int
+	client             *http.Client   // Client used for downloads
+	verification       bool           // Downloaded files are probed with ffprobe
+	history            *history.Store // Downloaded medias, nil to rely only on files
 }
 
 type RunnerConfigFn func(c RunnerConfig) RunnerConfig
 
 // Runner handle downloads for a provider
 type Runner struct {
-	p  Provider
-	s  *Settings
-	w  *workers.Worker
-	c  RunnerConfig
-	wg sync.WaitGroup
+	p   Provider
+	log *mylog.MyLog // Runner's logger, with provider field
+	s   *Settings
+	w   *workers.Worker
+	c   RunnerConfig
+	wg  sync.WaitGroup // Wait for the discovery and for submitted jobs
+
+	pending map[string]bool // Medias of previous runs, already submitted
+	parked  map[string]bool // Medias parked in the failed list, not downloaded again
+
+	libraryMu sync.Mutex
+	library   map[string][]library.Item // Videos already downloaded, by show path
+	renames   []rename                  // Medias found under another name, renamed by RenameMedias
+}
+
+// rename is a media found in the library under another name
+type rename struct {
+	m    *media.Media
+	item *library.Item // Video found in the library
+	path string        // New path of the video
 }
 
 // NewRunner return  a configured runner for the provider
 func NewRunner(ctx context.Context, s *Settings, p Provider, fns ...RunnerConfigFn) *Runner {
 	r := &Runner{
-		p: p,
-		s: s,
+		p:       p,
+		s:       s,
+		pending: map[string]bool{},
+		parked:  map[string]bool{},
+		library: map[string][]library.Item{},
 	}
 
 	c := RunnerConfig{
 		concurentDownloads: runtime.NumCPU(),
+		client:             http.DefaultClient,
 	}
 	for _, f := range fns {
 		c = f(c)
 	}
 	r.c = c
+	r.log = c.log.Subsystem("runner").With("provider", p.Name())
 
-	r.w = workers.New(ctx, c.concurentDownloads, r.c.log)
+	r.w = c.pool
+	if r.w == nil {
+		r.w = workers.New(ctx, c.concurentDownloads, r.c.log)
+	}
 	return r
 }
 
+// SubmitPending queues a media discovered during a previous run, unless it has been
+// downloaded since. It returns false when the media is already downloaded.
+// Pending medias must be submitted before calling GetNewMediasList, so they aren't discovered twice.
+func (r *Runner) SubmitPending(ctx context.Context, m *media.Media) bool {
+	if !r.AddPending(m) {
+		return false
+	}
+	r.SubmitDownload(ctx, m)
+	return true
+}
+
+// AddPending takes a media discovered during a previous run as a new media of the runner, unless it
+// has been downloaded since. It returns false when the media is already downloaded.
+// Like SubmitPending, it must be called before GetNewMediasList.
+func (r *Runner) AddPending(m *media.Media) bool {
+	r.pending[m.ID] = true
+	if !r.isNew(m) {
+		return false
+	}
+	r.c.bus.Publish(events.MediaDiscovered{Media: r.subject(m)})
+	return true
+}
+
+// Park tells that the media has been parked in the failed list by a previous run. It is skipped
+// when discovered, until it is put back in the pending list.
+// Parked medias must be given before calling GetNewMediasList.
+func (r *Runner) Park(id string) {
+	r.parked[id] = true
+}
+
 // GetNewMediasList pull the providers for medias available on its web site, check if
 // it isn't already downloaded before sent it back to the channel
 func (r *Runner) GetNewMediasList(ctx context.Context, mr []*matcher.MatchRequest) <-chan *media.Media {
-	r.c.log.Trace().Printf("[%s] Runner.GetAvailableMedias", r.p.Name())
+	r.log.Trace().Printf("Runner.GetAvailableMedias")
 	c := make(chan *media.Media)
 	r.wg.Add(1)
 	go func() {
 		defer func() {
 			close(c)
-			r.c.log.Trace().Printf("[%s] Exit Runner.GetAvailableMedias, %s", r.p.Name(), ErrString(ctx.Err()))
+			r.log.Trace().Printf("Exit Runner.GetAvailableMedias, %s", ErrString(ctx.Err()))
 			r.wg.Done()
 		}()
 		seen := map[string]bool{}
+		for id := range r.pending {
+			seen[id] = true
+		}
 
 		for m := range r.p.MediaList(ctx, mr) {
 			if _, ok := seen[m.ID]; ok {
@@ -83,25 +143,17 @@ func (r *Runner) GetNewMediasList(ctx context.Context, mr []*matcher.MatchReques
 				continue
 			}
 			seen[m.ID] = true
-
-			m.ShowRootPath = m.Match.ShowRootPath
-			if len(m.ShowRootPath) == 0 {
-				m.ShowRootPath = filepath.Join(r.s.Destinations[m.Match.Destination], download.PathNameCleaner(m.Metadata.GetMediaInfo().Showtitle))
-			}
-			showPath, err := download.MediaPath(m.ShowRootPath, m.Match, m.Metadata.GetMediaInfo())
-			if err != nil {
-				r.c.log.Error().Printf("[%s] %s", r.p.Name())
-				continue
-			}
-			exist, err := fileExists(showPath)
-			if err != nil {
-				r.c.log.Error().Printf("[%s] %s", r.p.Name())
+			if r.parked[m.ID] {
+				r.log.With("media", m.ID).Trace().Printf("Media parked in the failed list")
+				r.c.bus.Publish(events.MediaSkipped{Media: r.subject(m), Reason: "parked in the failed list"})
 				continue
 			}
-			if exist {
-				r.c.log.Trace().Printf("[%s] Media already downloaded %q", r.p.Name(), showPath)
+
+			r.setShowRootPath(m)
+			if !r.isNew(m) {
 				continue
 			}
+			r.c.bus.Publish(events.MediaDiscovered{Media: r.subject(m)})
 			select {
 			case <-ctx.Done():
 				return
@@ -113,26 +165,213 @@ func (r *Runner) GetNewMediasList(ctx context.Context, mr []*matcher.MatchReques
 	return c
 }
 
+// setShowRootPath sets the path of the media's show: the path given by the watch list, or
+// the show's directory in the destination
+func (r *Runner) setShowRootPath(m *media.Media) {
+	m.ShowRootPath = m.Match.ShowRootPath
+	if len(m.ShowRootPath) == 0 {
+		m.ShowRootPath = filepath.Join(r.s.Destinations[m.Match.Destination], download.PathNameCleaner(m.Metadata.GetMediaInfo().Showtitle))
+	}
+}
+
+// isNew checks that the media isn't already downloaded. Skipped medias are published on the bus.
+func (r *Runner) isNew(m *media.Media) bool {
+	showPath, err := download.MediaPath(m.ShowRootPath, m.Match, m.Metadata.GetMediaInfo())
+	if err != nil {
+		r.log.With("media", m.ID).Error().Printf("%s", err)
+		r.c.bus.Publish(events.MediaSkipped{Media: r.subject(m), Reason: err.Error()})
+		return false
+	}
+	exist, err := fileExists(showPath)
+	if err != nil {
+		r.log.With("media", m.ID).Error().Printf("%s", err)
+		r.c.bus.Publish(events.MediaSkipped{Media: r.subject(m), Reason: err.Error()})
+		return false
+	}
+	if exist {
+		r.log.With("media", m.ID).Trace().Printf("Media already downloaded %q", showPath)
+		r.c.bus.Publish(events.MediaSkipped{Media: r.subject(m), Reason: "already downloaded"})
+		return false
+	}
+	item, err := r.findSame(m, showPath)
+	if err != nil {
+		r.log.With("media", m.ID).Error().Printf("%s", err)
+		r.c.bus.Publish(events.MediaSkipped{Media: r.subject(m), Reason: err.Error()})
+		return false
+	}
+	if item != nil {
+		r.log.With("media", m.ID).Trace().Printf("Media already downloaded as %q", item.Path)
+		r.c.bus.Publish(events.MediaSkipped{Media: r.subject(m), Reason: "already downloaded as " + item.Path})
+		return false
+	}
+	if r.c.history != nil && !m.Match.Force {
+		if e := r.c.history.Find(m.Metadata.GetMediaInfo()); e != nil {
+			r.log.With("media", m.ID).Trace().Printf("Media already downloaded %q, found in history", e.Path)
+			r.c.bus.Publish(events.MediaSkipped{Media: r.subject(m), Reason: "already in history"})
+			return false
+		}
+	}
+	return true
+}
+
+// findSame looks in the show's directory for the media downloaded under another name, because
+// the provider has renumbered or retitled it. The video is noted to be renamed to the media path by
+// RenameMedias. It returns the video found, nil when the media isn't found.
+func (r *Runner) findSame(m *media.Media, mediaPath string) (*library.Item, error) {
+	r.libraryMu.Lock()
+	defer r.libraryMu.Unlock()
+	items, ok := r.library[m.ShowRootPath]
+	if !ok {
+		var err error
+		items, err = library.Scan([]library.Root{{Name: filepath.Base(m.ShowRootPath), Path: m.ShowRootPath}})
+		if err != nil {
+			return nil, err
+		}
+		r.library[m.ShowRootPath] = items
+	}
+	i := library.FindSame(items, m.Metadata.GetMediaInfo())
+	if i == nil {
+		return nil, nil
+	}
+	for _, rn := range r.renames {
+		if rn.item == i {
+			return i, nil
+		}
+	}
+	r.renames = append(r.renames, rename{m: m, item: i, path: mediaPath})
+	return i, nil
+}
+
+// RenameMedias renames the videos of medias found by the discovery under another name, with their
+// metadata file and thumbnails. The history entry of the media takes its new name. Renamed medias
+// are published on the bus. It must be called once the discovery is done, and returns the number of
+// renamed medias.
+func (r *Runner) RenameMedias() int {
+	r.libraryMu.Lock()
+	defer r.libraryMu.Unlock()
+	n := 0
+	for _, rn := range r.renames {
+		old := rn.item.Path
+		err := r.rename(rn)
+		if err != nil {
+			r.log.With("media", rn.m.ID).Error().Printf("%s", err)
+			continue
+		}
+		r.log.With("media", rn.m.ID).Info().Printf("Media %q renamed into %q", old, rn.item.Path)
+		subject := r.subject(rn.m)
+		subject.Path = rn.item.Path
+		r.c.bus.Publish(events.MediaRenamed{Media: subject, From: old})
+		n++
+	}
+	r.renames = nil
+	return n
+}
+
+// rename moves the files of the media, and updates its history entry. r.libraryMu must be held.
+func (r *Runner) rename(rn rename) error {
+	previous := *rn.item.Info
+	info := rn.m.Metadata.GetMediaInfo()
+	err := library.Rename(rn.item, rn.path, info)
+	if err != nil {
+		return err
+	}
+	if r.c.history == nil {
+		return nil
+	}
+	if e := r.c.history.Find(&previous); e != nil {
+		return r.c.history.Update(e, info, rn.item.Path)
+	}
+	return nil
+}
+
+// WaitUntilCompletion waits the end of the discovery and of the downloads submitted by the runner.
+// The runner's own pool is stopped, a shared pool is left running.
 func (r *Runner) WaitUntilCompletion(ctx context.Context) {
-	r.w.Stop(ctx)
 	r.wg.Wait()
-	r.c.log.Trace().Printf("[runner] [%s] Runner.WaitUntilCompletion done (%s)", r.p.Name(), ErrString(ctx.Err()))
+	if r.c.pool == nil {
+		r.w.Stop(ctx)
+	}
+	r.log.Trace().Printf("Runner.WaitUntilCompletion done (%s)", ErrString(ctx.Err()))
 }
 
-func (r *Runner) SubmitDownload(ctx context.Context, m *media.Media, fb FeedBacker, onDone func()) {
-	r.c.log.Trace().Printf("[runner] [%s] Runner.Enqueue download of %q", r.p.Name(), m.Metadata.GetMediaInfo().Title)
-	r.w.Submit(ctx, func(wid, jid int) {
-		r.c.log.Trace().Printf("[runner] [%s] Job (%d,%d) Started  %q", r.p.Name(), wid, jid, m.Metadata.GetMediaInfo().Title)
+// SubmitDownload queues the media for download. The progression is published on runner's event bus.
+// Medias of shows with a higher priority are downloaded first, then medias closest to their expiry.
+// A media not yet available is skipped, it is submitted again by the next run. An expired media fails at once.
+func (r *Runner) SubmitDownload(ctx context.Context, m *media.Media) {
+	log := r.log.With("media", m.ID, "show", m.Metadata.GetMediaInfo().Showtitle)
+	info := m.Metadata.GetMediaInfo()
+	now := time.Now()
+	if info.NotYetAvailable(now) {
+		log.Debug().Printf("%q isn't available before %s", info.Title, info.AvailableFrom.Format(time.RFC3339))
+		r.c.bus.Publish(events.MediaSkipped{Media: r.subject(m), Reason: "available from " + info.AvailableFrom.Format(time.RFC3339)})
+		return
+	}
+	if info.Expired(now) {
+		r.c.bus.Publish(events.DownloadFailed{Media: r.subject(m), Err: r.expired(log, m)})
+		return
+	}
+	log.Trace().Printf("Runner.Enqueue download of %q", m.Metadata.GetMediaInfo().Title)
+	r.wg.Add(1)
+	j := r.w.Submit(ctx, func(ctx context.Context, j *workers.Job, wid int) {
+		log := log.With("worker", wid, "job", j.ID)
+		log.Trace().Printf("Job started %q", m.Metadata.GetMediaInfo().Title)
 		defer func() {
-			if onDone != nil {
-				r.c.log.Trace().Printf("[runner] [%s] Job (%d,%d) Done function called for %s", r.p.Name(), wid, jid, m.Metadata.GetMediaInfo().Title)
-				onDone()
-			}
-			r.c.log.Trace().Printf("[runner] [%s] Job (%d,%d) is done  of %q (%s)", r.p.Name(), wid, jid, m.Metadata.GetMediaInfo().Title, ErrString(ctx.Err()))
+			log.Trace().Printf("Job is done of %q (%s)", m.Metadata.GetMediaInfo().Title, ErrString(ctx.Err()))
 		}()
-		newDownloader(r).download(ctx, m, fb)
+		newDownloader(r, log).download(ctx, m, j)
+
+	}, workers.WithGroup(r.p.Name()), workers.WithPriority(m.Match.Priority), workers.WithDeadline(info.AvailableUntil), workers.WithName(info.Showtitle+" / "+info.Title))
+	go func() {
+		<-j.Done()
+		r.wg.Done()
+	}()
+}
 
-	})
+// expired warns that a watched media has expired before it could be downloaded, and returns
+// the error of its download
+func (r *Runner) expired(log *mylog.MyLog, m *media.Media) error {
+	info := m.Metadata.GetMediaInfo()
+	until := info.AvailableUntil.Format(time.RFC3339)
+	log.Error().Printf("Warning: %q has expired on %s before it could be downloaded", info.Title, until)
+	return NewError(ErrUnavailable, r.p.Name(), info.PageURL, fmt.Errorf("expired on %s", until))
+}
+
+// subject returns the identification of the media for events
+func (r *Runner) subject(m *media.Media) events.Media {
+	info := m.Metadata.GetMediaInfo()
+	mediaPath, _ := download.MediaPath(m.ShowRootPath, m.Match, info)
+	return events.Media{
+		Provider: r.p.Name(),
+		ID:       m.ID,
+		Show:     info.Showtitle,
+		Title:    info.Title,
+		Path:     mediaPath,
+	}
+}
+
+// Retries of provider's queries
+const detailsMaxAttempts = 3
+
+var retryDelay = 5 * time.Second // Delay before the first retry, increased at each attempt
+
+// retry calls fn again while it returns retryable errors. The delay asked by the server is respected.
+func (r *Runner) retry(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !Retryable(err) || attempt >= detailsMaxAttempts {
+			return err
+		}
+		wait := retryAfter(err)
+		if wait == 0 {
+			wait = time.Duration(attempt) * retryDelay
+		}
+		r.log.Debug().Printf("Retry in %s after error: %s", wait, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
 }
 
 func RunnerWithLogger(log *mylog.MyLog) RunnerConfigFn {
@@ -142,6 +381,48 @@ func RunnerWithLogger(log *mylog.MyLog) RunnerConfigFn {
 	}
 }
 
+// RunnerWithEvents gives the bus where runner's events are published
+func RunnerWithEvents(bus *events.Bus) RunnerConfigFn {
+	return func(c RunnerConfig) RunnerConfig {
+		c.bus = bus
+		return c
+	}
+}
+
+// RunnerWithPool makes the runner submit downloads to a pool shared with other runners.
+// Downloads are grouped by provider.
+func RunnerWithPool(pool *workers.Worker) RunnerConfigFn {
+	return func(c RunnerConfig) RunnerConfig {
+		c.pool = pool
+		return c
+	}
+}
+
+// RunnerWithClient gives the HTTP client used to download medias and thumbnails
+func RunnerWithClient(client *http.Client) RunnerConfigFn {
+	return func(c RunnerConfig) RunnerConfig {
+		c.client = client
+		return c
+	}
+}
+
+// RunnerWithVerification probes downloaded files with ffprobe. Files having an unexpected duration,
+// missing streams or a lower resolution are removed, and their download fails with a transient error.
+func RunnerWithVerification(verification bool) RunnerConfigFn {
+	return func(c RunnerConfig) RunnerConfig {
+		c.verification = verification
+		return c
+	}
+}
+
+// RunnerWithHistory skips medias found in the history, and records downloaded medias
+func RunnerWithHistory(h *history.Store) RunnerConfigFn {
+	return func(c RunnerConfig) RunnerConfig {
+		c.history = h
+		return c
+	}
+}
+
 func RunnerWithConcurentLimit(limit int) RunnerConfigFn {
 	return func(c RunnerConfig) RunnerConfig {
 		c.concurentDownloads = limit
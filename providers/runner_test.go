@@ -0,0 +1,160 @@
+package providers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/simulot/aspiratv/events"
+	"github.com/simulot/aspiratv/history"
+	"github.com/simulot/aspiratv/matcher"
+	"github.com/simulot/aspiratv/media"
+	"github.com/simulot/aspiratv/metadata/nfo"
+	"github.com/simulot/aspiratv/mylog"
+	"github.com/simulot/aspiratv/queue"
+)
+
+func TestIsNewRenumbered(t *testing.T) {
+	ctx := context.Background()
+	log, err := mylog.NewLog("ERROR", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	mr := &matcher.MatchRequest{Show: "Les Lapins Crétins", Destination: "Jeunesse"}
+	info := func(episode int) nfo.MediaInfo {
+		return nfo.MediaInfo{
+			Showtitle: "Les Lapins Crétins",
+			Title:     "Lapin bricoleur",
+			Season:    1,
+			Episode:   episode,
+			MediaType: nfo.TypeSeries,
+			UniqueID:  []nfo.ID{{Type: "fake", ID: "1234"}},
+		}
+	}
+	season := filepath.Join(dir, "Les Lapins Crétins", "Season 01")
+	oldVideo := filepath.Join(season, "Les Lapins Crétins - s01e02 - Lapin bricoleur.mp4")
+	newVideo := filepath.Join(season, "Les Lapins Crétins - s01e05 - Lapin bricoleur.mp4")
+	old := info(2)
+	h, err := history.Open(filepath.Join(dir, "history.json"))
+	if err == nil {
+		err = os.MkdirAll(season, 0777)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(oldVideo, []byte("video"), 0666)
+	}
+	if err == nil {
+		err = (&nfo.EpisodeDetails{MediaInfo: old}).WriteNFO(strings.TrimSuffix(oldVideo, ".mp4") + ".nfo")
+	}
+	if err == nil {
+		_, err = h.Add(history.NewEntry(&old, oldVideo, history.SourceDownload))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus := events.NewBus()
+	renamed := []string{}
+	defer bus.Subscribe(func(e events.Event) {
+		if e, ok := e.(events.MediaRenamed); ok {
+			renamed = append(renamed, e.From+" -> "+e.Path)
+		}
+	})()
+	r := NewRunner(ctx, &Settings{Destinations: map[string]string{"Jeunesse": dir}}, &fakeProvider{name: "fake"}, RunnerWithLogger(log), RunnerWithEvents(bus), RunnerWithHistory(h))
+	defer r.WaitUntilCompletion(ctx)
+	m := &media.Media{ID: "1234", Match: mr, Metadata: &nfo.EpisodeDetails{MediaInfo: info(5)}}
+	r.setShowRootPath(m)
+
+	if r.isNew(m) {
+		t.Errorf("isNew() = true for a renumbered media")
+	}
+	if ok, _ := fileExists(oldVideo); !ok {
+		t.Errorf("Video renamed by isNew")
+	}
+
+	if n := r.RenameMedias(); n != 1 {
+		t.Errorf("RenameMedias() = %d, want 1", n)
+	}
+	if want := oldVideo + " -> " + newVideo; len(renamed) != 1 || renamed[0] != want {
+		t.Errorf("Renamed events %v, want %s", renamed, want)
+	}
+	for _, f := range []string{newVideo, strings.TrimSuffix(newVideo, ".mp4") + ".nfo"} {
+		if ok, _ := fileExists(f); !ok {
+			t.Errorf("%s not renamed", f)
+		}
+	}
+	h, err = history.Open(h.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	episode := info(5)
+	episode.UniqueID = nil
+	if e := h.Find(&episode); e == nil || e.Path != newVideo {
+		t.Errorf("History entry not updated: %+v", e)
+	}
+}
+
+func TestParkedMedia(t *testing.T) {
+	ctx := context.Background()
+	log, err := mylog.NewLog("ERROR", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := queue.Open(filepath.Join(t.TempDir(), "queue.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := &matcher.MatchRequest{Show: "Les Lapins Crétins", Destination: "Jeunesse"}
+	newMedia := func() *media.Media {
+		return &media.Media{ID: "1234", Match: mr, Metadata: &nfo.EpisodeDetails{MediaInfo: nfo.MediaInfo{
+			Showtitle: "Les Lapins Crétins",
+			Title:     "Lapin bricoleur",
+			Season:    1,
+			Episode:   2,
+			MediaType: nfo.TypeSeries,
+		}}}
+	}
+	// A permanent error parks the media at once, like the run command does
+	failure := &Error{Kind: ErrGeoBlocked}
+	maxAttempts := queue.DefaultMaxAttempts
+	if Permanent(failure) {
+		maxAttempts = 1
+	}
+	err = q.Add("fake", newMedia())
+	if err == nil {
+		err = q.Failed("fake", "1234", failure, maxAttempts)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each run discovers the media again, as the provider still lists it
+	for run := 1; run <= 2; run++ {
+		bus := events.NewBus()
+		skipped := []string{}
+		unsubscribe := bus.Subscribe(func(e events.Event) {
+			if e, ok := e.(events.MediaSkipped); ok {
+				skipped = append(skipped, e.Reason)
+			}
+		})
+		p := &fakeProvider{name: "fake", medias: []*media.Media{newMedia()}}
+		r := NewRunner(ctx, &Settings{Destinations: map[string]string{"Jeunesse": t.TempDir()}}, p, RunnerWithLogger(log), RunnerWithEvents(bus))
+		for _, i := range q.Items(queue.StatusFailed) {
+			r.Park(i.ID)
+		}
+		for m := range r.GetNewMediasList(ctx, []*matcher.MatchRequest{mr}) {
+			t.Errorf("Run %d: parked media %s discovered", run, m.ID)
+		}
+		r.WaitUntilCompletion(ctx)
+		unsubscribe()
+		if len(skipped) != 1 || skipped[0] != "parked in the failed list" {
+			t.Errorf("Run %d: skipped %v, want the parked media", run, skipped)
+		}
+	}
+	if items := q.Items(queue.StatusFailed); len(items) != 1 || items[0].Attempts != 1 {
+		t.Errorf("Failed list %+v, want the media with 1 attempt", items)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/simulot/aspiratv/matcher"
+	"github.com/simulot/aspiratv/network"
 )
 
 // Settings hold application global settings
@@ -14,13 +15,20 @@ type Settings struct {
 	Providers    map[string]ProviderSettings // Registered providers
 	Destinations map[string]string           // Mapping of destination path
 	WatchList    []*matcher.MatchRequest     // Slice of show matchers
+	Network      network.Config              // Proxy, timeouts, certificates and cookies
 	// TODO restore WriteNFO option
 	// WriteNFO     bool                        // True when NFO files to be written
 }
 
+// DefaultHitsRate is the number of hits per second allowed when the provider's setting is 0
+const DefaultHitsRate = 5
+
 type ProviderSettings struct { // TODO don't stutter!
 	Enabled  bool
 	HitsRate int // Number of get per second
+	MaxTasks int // Maximum concurrent downloads for the provider, 0 to use only the global limit
+	Proxy    string // Proxy used for the provider, the network's proxy when empty
+	CacheTTL int    // Seconds during which catalog and search pages are cached, 0 to follow response headers, -1 to disable the cache
 	Settings map[string]string
 }
 
@@ -60,6 +68,51 @@ func (s *Settings) CheckPath() error {
 	return nil
 }
 
+// Expand returns a copy of settings with expanded paths and defaults values
+// for registered providers. Unlike CheckPath, nothing is created on disk.
+func (s Settings) Expand() (Settings, error) {
+	e := Settings{
+		Providers:    map[string]ProviderSettings{},
+		Destinations: map[string]string{},
+		WatchList:    make([]*matcher.MatchRequest, 0, len(s.WatchList)),
+		Network:      s.Network,
+	}
+
+	for name := range List() {
+		e.Providers[name] = ProviderSettings{}
+	}
+	for name, ps := range s.Providers {
+		e.Providers[name] = ps
+	}
+	for name, ps := range e.Providers {
+		if ps.HitsRate == 0 {
+			ps.HitsRate = DefaultHitsRate
+		}
+		e.Providers[name] = ps
+	}
+
+	for k, v := range s.Destinations {
+		v, err := ExpandPath(v)
+		if err != nil {
+			return e, fmt.Errorf("Can't expand destination %q: %w", k, err)
+		}
+		e.Destinations[k] = v
+	}
+
+	for _, m := range s.WatchList {
+		m := *m
+		if m.ShowRootPath != "" {
+			p, err := ExpandPath(m.ShowRootPath)
+			if err != nil {
+				return e, fmt.Errorf("Can't expand show path for %q: %w", m.Show, err)
+			}
+			m.ShowRootPath = p
+		}
+		e.WatchList = append(e.WatchList, &m)
+	}
+	return e, nil
+}
+
 // ExpandPath variables contained in the path
 //  - ENV variables like $HOME
 //	- ~ for home dir
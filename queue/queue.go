@@ -0,0 +1,288 @@
+// Package queue keeps the list of medias waiting for a download on disk, so they
+// survive a restart. Failed downloads are retried until they reach the maximum number
+// of attempts, then they are parked in the failed list.
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/simulot/aspiratv/matcher"
+	"github.com/simulot/aspiratv/media"
+	"github.com/simulot/aspiratv/metadata/nfo"
+)
+
+// DefaultMaxAttempts is the number of attempts before a media is parked in the failed list
+const DefaultMaxAttempts = 3
+
+// Status of a queued media
+type Status string
+
+// Status values
+const (
+	StatusPending Status = "pending" // Waiting for a download or for a retry
+	StatusFailed  Status = "failed"  // Parked after too many attempts
+)
+
+// Item is a media in the queue
+type Item struct {
+	Provider     string
+	ID           string
+	Kind         string // episode or movie
+	Info         *nfo.MediaInfo
+	Match        *matcher.MatchRequest
+	ShowRootPath string
+	Status       Status
+	Attempts     int    // Number of failed attempts
+	LastError    string `json:",omitempty"`
+	Added        time.Time
+	Updated      time.Time
+}
+
+// Key identifies the media
+func (i *Item) Key() string {
+	return i.Provider + "/" + i.ID
+}
+
+// Media rebuilds the media to be downloaded
+func (i *Item) Media() (*media.Media, error) {
+	info, err := copyInfo(i.Info)
+	if err != nil {
+		return nil, err
+	}
+	m := &media.Media{
+		ID:           i.ID,
+		Match:        i.Match,
+		ShowRootPath: i.ShowRootPath,
+	}
+	if i.Kind == "movie" {
+		m.SetMetaData(&nfo.Movie{MediaInfo: *info})
+	} else {
+		m.SetMetaData(&nfo.EpisodeDetails{MediaInfo: *info})
+	}
+	return m, nil
+}
+
+// copyInfo returns a deep copy of metadata, as they are completed during the download
+func copyInfo(info *nfo.MediaInfo) (*nfo.MediaInfo, error) {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return nil, fmt.Errorf("Can't encode metadata: %w", err)
+	}
+	c := &nfo.MediaInfo{}
+	err = json.Unmarshal(b, c)
+	if err != nil {
+		return nil, fmt.Errorf("Can't decode metadata: %w", err)
+	}
+	return c, nil
+}
+
+// Store is the queue persisted in a JSON file. The file is read again when it has been
+// changed by another process, like the queue command editing the queue of a running daemon.
+type Store struct {
+	mu    sync.Mutex
+	path  string
+	items map[string]*Item
+	file  os.FileInfo // The file as last read or written, nil when missing
+}
+
+// Open reads the queue file. A missing file gives an empty queue.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:  path,
+		items: map[string]*Item{},
+	}
+	err := s.refresh()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// refresh reads the file again when it has changed since it was last read or written. s.mu must be held.
+func (s *Store) refresh() error {
+	st, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Can't read queue: %w", err)
+	}
+	if s.file != nil && os.SameFile(st, s.file) && st.ModTime().Equal(s.file.ModTime()) && st.Size() == s.file.Size() {
+		return nil
+	}
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("Can't read queue: %w", err)
+	}
+	items := []*Item{}
+	err = json.Unmarshal(b, &items)
+	if err != nil {
+		return fmt.Errorf("Can't decode queue %s: %w", s.path, err)
+	}
+	s.items = map[string]*Item{}
+	for _, i := range items {
+		s.items[i.Key()] = i
+	}
+	s.file = st
+	return nil
+}
+
+// Add records a new media as pending. A media already in the queue is left untouched.
+func (s *Store) Add(provider string, m *media.Media) error {
+	info, err := copyInfo(m.Metadata.GetMediaInfo())
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err = s.refresh()
+	if err != nil {
+		return err
+	}
+	i := &Item{
+		Provider:     provider,
+		ID:           m.ID,
+		Kind:         "episode",
+		Info:         info,
+		Match:        m.Match,
+		ShowRootPath: m.ShowRootPath,
+		Status:       StatusPending,
+		Added:        time.Now(),
+	}
+	if _, ok := m.Metadata.(*nfo.Movie); ok {
+		i.Kind = "movie"
+	}
+	i.Updated = i.Added
+	if _, ok := s.items[i.Key()]; ok {
+		return nil
+	}
+	s.items[i.Key()] = i
+	return s.save()
+}
+
+// Done removes a downloaded media from the queue
+func (s *Store) Done(provider, id string) error {
+	return s.Remove(provider, id)
+}
+
+// Remove the media from the queue
+func (s *Store) Remove(provider, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.refresh()
+	if err != nil {
+		return err
+	}
+	k := provider + "/" + id
+	if _, ok := s.items[k]; !ok {
+		return nil
+	}
+	delete(s.items, k)
+	return s.save()
+}
+
+// Failed counts a failed attempt. The media is parked in the failed list when
+// it reaches maxAttempts.
+func (s *Store) Failed(provider, id string, err error, maxAttempts int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rerr := s.refresh(); rerr != nil {
+		return rerr
+	}
+	i, ok := s.items[provider+"/"+id]
+	if !ok {
+		return nil
+	}
+	i.Attempts++
+	i.LastError = err.Error()
+	i.Updated = time.Now()
+	if i.Attempts >= maxAttempts {
+		i.Status = StatusFailed
+	}
+	return s.save()
+}
+
+// Retry puts a failed media back in the pending list
+func (s *Store) Retry(provider, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.refresh()
+	if err != nil {
+		return err
+	}
+	i, ok := s.items[provider+"/"+id]
+	if !ok {
+		return fmt.Errorf("Media %s/%s isn't in the queue", provider, id)
+	}
+	i.Status = StatusPending
+	i.Attempts = 0
+	i.Updated = time.Now()
+	return s.save()
+}
+
+// Items returns the medias having the status, all medias when status is empty,
+// sorted by provider and by date of addition. When the file can't be read again, the last known medias are returned.
+func (s *Store) Items(status Status) []Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+	items := []Item{}
+	for _, i := range s.items {
+		if status == "" || i.Status == status {
+			items = append(items, *i)
+		}
+	}
+	sort.Slice(items, func(a, b int) bool {
+		if items[a].Provider != items[b].Provider {
+			return items[a].Provider < items[b].Provider
+		}
+		return items[a].Added.Before(items[b].Added)
+	})
+	return items
+}
+
+// save writes the queue. The file is replaced only once the new content is completely written.
+// s.mu must be held.
+func (s *Store) save() error {
+	items := make([]*Item, 0, len(s.items))
+	for _, i := range s.items {
+		items = append(items, i)
+	}
+	sort.Slice(items, func(a, b int) bool {
+		return items[a].Key() < items[b].Key()
+	})
+	b, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Can't encode queue: %w", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(s.path), 0777)
+	if err != nil {
+		return fmt.Errorf("Can't write queue: %w", err)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(s.path), "."+filepath.Base(s.path)+"-")
+	if err != nil {
+		return fmt.Errorf("Can't write queue: %w", err)
+	}
+	_, err = f.Write(b)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("Can't write queue: %w", err)
+	}
+	s.file, _ = os.Stat(s.path)
+	return nil
+}
@@ -0,0 +1,127 @@
+package queue
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/simulot/aspiratv/matcher"
+	"github.com/simulot/aspiratv/media"
+	"github.com/simulot/aspiratv/metadata/nfo"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movie := &media.Media{
+		ID:           "m1",
+		Match:        &matcher.MatchRequest{Show: "Cinéma", Provider: "artetv", Priority: 2},
+		ShowRootPath: "/videos/Cinéma",
+	}
+	movie.SetMetaData(&nfo.Movie{MediaInfo: nfo.MediaInfo{Title: "Le film", Showtitle: "Cinéma"}})
+	episode := &media.Media{ID: "e1", Match: &matcher.MatchRequest{Show: "Tintin", Provider: "francetv"}}
+	episode.SetMetaData(&nfo.EpisodeDetails{MediaInfo: nfo.MediaInfo{Title: "Le lotus bleu", Showtitle: "Tintin", Season: 1, Episode: 3}})
+
+	for _, m := range []struct {
+		provider string
+		m        *media.Media
+	}{{"artetv", movie}, {"francetv", episode}} {
+		err = s.Add(m.provider, m.m)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		err = s.Failed("francetv", "e1", errors.New("network error"), 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The queue survives a restart
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := s.Items(StatusPending)
+	if len(pending) != 1 || pending[0].ID != "m1" {
+		t.Fatalf("expecting the movie pending, got %+v", pending)
+	}
+	m, err := pending[0].Media()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Metadata.(*nfo.Movie); !ok || m.Metadata.GetMediaInfo().Title != "Le film" || m.Match.Priority != 2 || m.ShowRootPath != "/videos/Cinéma" {
+		t.Errorf("unexpected media %+v, %+v", m, m.Metadata)
+	}
+
+	failed := s.Items(StatusFailed)
+	if len(failed) != 1 || failed[0].Attempts != 2 || failed[0].LastError != "network error" {
+		t.Fatalf("expecting the episode failed after 2 attempts, got %+v", failed)
+	}
+	err = s.Retry("francetv", "e1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Done("artetv", "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending = s.Items(StatusPending)
+	if len(pending) != 1 || pending[0].ID != "e1" || pending[0].Attempts != 0 {
+		t.Fatalf("expecting the episode pending, got %+v", pending)
+	}
+}
+
+func TestConcurrentEdit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	daemon, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"e1", "e2"} {
+		m := &media.Media{ID: id, Match: &matcher.MatchRequest{Show: "Tintin", Provider: "francetv"}}
+		m.SetMetaData(&nfo.EpisodeDetails{MediaInfo: nfo.MediaInfo{Title: id, Showtitle: "Tintin"}})
+		err = daemon.Add("francetv", m)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = daemon.Failed("francetv", "e1", errors.New("network error"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The queue command edits the file while the daemon is running
+	cli, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cli.Retry("francetv", "e1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending := daemon.Items(StatusPending); len(pending) != 2 {
+		t.Errorf("retried media not seen by the daemon, pending %+v", pending)
+	}
+	err = daemon.Done("francetv", "e2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending := s.Items(StatusPending); len(pending) != 1 || pending[0].ID != "e1" {
+		t.Errorf("expecting the retried media pending, got %+v", pending)
+	}
+}
@@ -2,10 +2,13 @@ package workers
 
 import (
 	"context"
+	"errors"
 	"runtime"
+	"sort"
 	"sync"
-	"sync/atomic"
+	"time"
 
+	"github.com/simulot/aspiratv/metrics"
 	"github.com/simulot/aspiratv/mylog"
 )
 
@@ -13,11 +16,24 @@ import (
 type WorkItem func()
 
 // Worker is a pool of workers.
+// Jobs are queued, and started by priority as soon as a worker is available. Jobs of
+// same priority are started by deadline, the closest first.
+// The number of jobs running at the same time is limited globally, and by group.
 type Worker struct {
-	stop   chan bool // Close this channel to stop all workers
-	tokens chan int
-	wg     sync.WaitGroup // To wait completion of all workers
-	logger *mylog.MyLog   // True to enable logs
+	mu      sync.Mutex
+	limit   int            // Maximum number of running jobs
+	limits  map[string]int // Maximum number of running jobs per group, 0 for no limit
+	running map[string]int // Number of running jobs per group
+	busy    int            // Number of running jobs
+	free    []int          // Identifiers of idle workers
+	inUse   map[int]bool   // Identifiers of busy workers
+	queue   []*Job         // Waiting jobs, sorted by priority and deadline
+	active  []*Job         // Running jobs
+	nextID  int            // Last job identifier
+	seq     int64          // Submission order
+	stopped bool
+	wg      sync.WaitGroup // To wait completion of all workers
+	logger  *mylog.MyLog   // True to enable logs
 }
 
 // New creates a new worker pool with NumCPU runing workers
@@ -26,54 +42,435 @@ func New(ctx context.Context, workers int, logger *mylog.MyLog) *Worker {
 		workers = runtime.NumCPU()
 	}
 	w := &Worker{
-		stop:   make(chan bool),
-		tokens: make(chan int, workers),
-		logger: logger,
+		limit:   workers,
+		limits:  map[string]int{},
+		running: map[string]int{},
+		inUse:   map[int]bool{},
+		logger:  logger.Subsystem("workers"),
 	}
 
-	w.logger.Debug().Printf("[WORKER] Starting %d workers", workers)
-	for i := 0; i < workers; i++ {
-		w.tokens <- i + 1
+	w.logger.Debug().Printf("Starting %d workers", workers)
+	for i := workers; i > 0; i-- {
+		w.free = append(w.free, i)
 	}
 
 	return w
 }
 
-// Stop stops all runing workers and wait them to finish and then leave the workerpool.
+// Resize changes the number of workers. When reduced, running jobs are completed,
+// but waiting jobs aren't started until the number of running jobs is below the new size.
+func (w *Worker) Resize(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.logger.Debug().Printf("Resizing from %d to %d workers", w.limit, workers)
+	// Identifiers are kept between 1 and the number of workers. Identifiers of busy workers above
+	// the new size are released when their job ends.
+	free := []int{}
+	idle := map[int]bool{}
+	for _, id := range w.free {
+		if id <= workers {
+			free = append(free, id)
+			idle[id] = true
+		}
+	}
+	for id := workers; id > 0; id-- {
+		if !idle[id] && !w.inUse[id] {
+			free = append([]int{id}, free...)
+		}
+	}
+	w.free = free
+	w.limit = workers
+	w.dispatch()
+}
+
+// Size returns the number of workers
+func (w *Worker) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.limit
+}
+
+// Jobs returns running jobs, then waiting jobs by priority
+func (w *Worker) Jobs() []*Job {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	jobs := make([]*Job, 0, len(w.active)+len(w.queue))
+	jobs = append(jobs, w.active...)
+	return append(jobs, w.queue...)
+}
+
+// Job returns the running or waiting job with the given identifier, nil when not found
+func (w *Worker) Job(id int) *Job {
+	for _, j := range w.Jobs() {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// SetGroupLimit limits the number of jobs of the group running at the same time. 0 removes the limit.
+func (w *Worker) SetGroupLimit(group string, limit int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.limits[group] = limit
+	w.dispatch()
+}
+
+// Stop discards waiting jobs, and waits the end of running ones.
 func (w *Worker) Stop(ctx context.Context) {
-	w.logger.Debug().Printf("[WORKER] Stoping...")
+	w.logger.Debug().Printf("Stoping...")
 
-	// Makes all goroutine ending
-	close(w.stop)
+	w.mu.Lock()
+	w.stopped = true
+	for _, j := range w.queue {
+		j.log.Debug().Printf("Stopped, job discarded")
+		w.discard(j)
+	}
+	w.queue = nil
+	// Paused jobs would never end
+	for _, j := range w.active {
+		if j.resumed != nil {
+			close(j.resumed)
+			j.resumed = nil
+		}
+	}
+	w.mu.Unlock()
 
 	// wait the end of all job
-	w.logger.Debug().Printf("[WORKER] Waiting for worker to end")
+	w.logger.Debug().Printf("Waiting for worker to end")
 	w.wg.Wait()
-	w.logger.Debug().Printf("[WORKER] Worker pool is ended")
-}
-
-var jobID = int64(0)
-
-// Submit a work item to the worker pool
-func (w *Worker) Submit(ctx context.Context, wi func(wid, jid int)) {
-	id := atomic.AddInt64(&jobID, 1)
-	w.logger.Debug().Printf("[WORKER] Job %d is waiting...", id)
-	select {
-	case <-w.stop:
-		w.logger.Debug().Printf("[WORKER] Stopped, job %d discared", id)
-		return
-	case <-ctx.Done():
-		w.logger.Debug().Printf("[WORKER] Cancled, job %d discared", id)
-		return
-	case wid := <-w.tokens:
-		w.wg.Add(1)
-		fn := func(wid, id int) {
-			w.logger.Debug().Printf("[WORKER %d] Job %d is started", wid, id)
-			wi(wid, id)
-			w.logger.Debug().Printf("[WORKER %d] Job %d is done", wid, id)
-			w.tokens <- wid
-			w.wg.Done()
+	w.logger.Debug().Printf("Worker pool is ended")
+}
+
+var (
+	queueDepth  = metrics.NewGauge("aspiratv_workers_queue_depth", "Number of jobs waiting for a worker.")
+	busyWorkers = metrics.NewGauge("aspiratv_workers_busy", "Number of workers running a job.")
+)
+
+// Status of a job
+type Status int
+
+// Status values
+const (
+	StatusWaiting   Status = iota // Waiting for a worker
+	StatusRunning                 // Running on a worker
+	StatusPaused                  // Paused by the user, waiting or running
+	StatusDone                    // Finished
+	StatusCancelled               // Cancelled before its end
+)
+
+var statusNames = map[Status]string{
+	StatusWaiting:   "waiting",
+	StatusRunning:   "running",
+	StatusPaused:    "paused",
+	StatusDone:      "done",
+	StatusCancelled: "cancelled",
+}
+
+func (s Status) String() string {
+	return statusNames[s]
+}
+
+// Job is a work item submitted to the pool. Its handle is used to follow and to control the job.
+type Job struct {
+	ID       int
+	Name     string    // Description of the job
+	Group    string    // Jobs of a group share the group's limit
+	Priority int       // Jobs with higher priority are started first
+	Deadline time.Time // Jobs of same priority with the closest deadline are started first, zero when none
+
+	w       *Worker
+	ctx     context.Context
+	cancel  context.CancelFunc
+	fn      func(ctx context.Context, j *Job, wid int)
+	seq     int64
+	log     *mylog.MyLog
+	done    chan struct{}
+	status   Status        // Status when not paused
+	resumed  chan struct{} // Not nil when paused, closed on resume
+	pausable bool          // The running job calls WaitResumed between its steps
+}
+
+// ErrNotPausable is returned when pausing a job that can't be paused
+var ErrNotPausable = errors.New("the job can't be paused")
+
+// Done returns a channel closed when the job is finished or discarded
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Status returns the current status of the job
+func (j *Job) Status() Status {
+	j.w.mu.Lock()
+	defer j.w.mu.Unlock()
+	if j.resumed != nil && (j.status == StatusWaiting || j.status == StatusRunning && j.pausable) {
+		return StatusPaused
+	}
+	return j.status
+}
+
+// Cancel discards a waiting job, or cancels the context of a running job
+func (j *Job) Cancel() {
+	j.w.mu.Lock()
+	defer j.w.mu.Unlock()
+	switch j.status {
+	case StatusWaiting:
+		j.log.Debug().Printf("Job cancelled while waiting")
+		j.w.remove(j)
+	case StatusRunning:
+		j.log.Debug().Printf("Job cancelled")
+		j.status = StatusCancelled
+		j.cancel()
+	}
+}
+
+// Pause keeps a waiting job in the queue, and suspends a running job at its next call to WaitResumed.
+// It returns ErrNotPausable for a running job that isn't pausable, and for a finished job.
+func (j *Job) Pause() error {
+	j.w.mu.Lock()
+	defer j.w.mu.Unlock()
+	if j.status != StatusWaiting && !(j.status == StatusRunning && j.pausable) {
+		return ErrNotPausable
+	}
+	if j.resumed == nil {
+		j.log.Debug().Printf("Job paused")
+		j.resumed = make(chan struct{})
+	}
+	return nil
+}
+
+// SetPausable is called by the running job to tell when it can be paused. A paused job
+// that becomes not pausable is resumed.
+func (j *Job) SetPausable(pausable bool) {
+	j.w.mu.Lock()
+	defer j.w.mu.Unlock()
+	j.pausable = pausable
+	if !pausable && j.resumed != nil && j.status == StatusRunning {
+		close(j.resumed)
+		j.resumed = nil
+	}
+}
+
+// Resume a paused job
+func (j *Job) Resume() {
+	j.w.mu.Lock()
+	defer j.w.mu.Unlock()
+	if j.resumed != nil {
+		j.log.Debug().Printf("Job resumed")
+		close(j.resumed)
+		j.resumed = nil
+		j.w.dispatch()
+	}
+}
+
+// WaitResumed blocks while the job is paused. Long running jobs call it between steps.
+func (j *Job) WaitResumed(ctx context.Context) error {
+	for {
+		j.w.mu.Lock()
+		resumed := j.resumed
+		j.w.mu.Unlock()
+		if resumed == nil {
+			return ctx.Err()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-resumed:
+		}
+	}
+}
+
+// JobOption sets job's properties at submission
+type JobOption func(j *Job)
+
+// WithGroup puts the job into a group
+func WithGroup(group string) JobOption {
+	return func(j *Job) {
+		j.Group = group
+	}
+}
+
+// WithName describes the job
+func WithName(name string) JobOption {
+	return func(j *Job) {
+		j.Name = name
+	}
+}
+
+// WithPriority gives the job's priority. The default is 0.
+func WithPriority(priority int) JobOption {
+	return func(j *Job) {
+		j.Priority = priority
+	}
+}
+
+// WithDeadline gives the time after which the job is useless. The default is no deadline.
+func WithDeadline(deadline time.Time) JobOption {
+	return func(j *Job) {
+		j.Deadline = deadline
+	}
+}
+
+// before tells if the job must be started before the other one
+func (j *Job) before(o *Job) bool {
+	if j.Priority != o.Priority {
+		return j.Priority > o.Priority
+	}
+	if j.Deadline.IsZero() {
+		return false
+	}
+	return o.Deadline.IsZero() || j.Deadline.Before(o.Deadline)
+}
+
+// Submit queues a work item. The job is discarded when the context is cancelled before
+// the job starts, or when the pool is stopped.
+// The work item gets a context cancelled by Job.Cancel, and the job's handle.
+func (w *Worker) Submit(ctx context.Context, wi func(ctx context.Context, j *Job, wid int), opts ...JobOption) *Job {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.nextID++
+	w.seq++
+	j := &Job{
+		ID:   w.nextID,
+		w:    w,
+		fn:   wi,
+		seq:  w.seq,
+		done: make(chan struct{}),
+	}
+	j.ctx, j.cancel = context.WithCancel(ctx)
+	for _, o := range opts {
+		o(j)
+	}
+	j.log = w.logger.With("job", j.ID)
+	if j.Group != "" {
+		j.log = j.log.With("group", j.Group)
+	}
+
+	if w.stopped {
+		j.log.Debug().Printf("Stopped, job discarded")
+		j.status = StatusCancelled
+		j.cancel()
+		close(j.done)
+		return j
+	}
+
+	j.log.Debug().Printf("Job is waiting...")
+	// Keep the queue sorted by priority, deadline, then by submission order
+	i := sort.Search(len(w.queue), func(i int) bool {
+		return j.before(w.queue[i])
+	})
+	w.queue = append(w.queue, nil)
+	copy(w.queue[i+1:], w.queue[i:])
+	w.queue[i] = j
+	queueDepth.Add(1)
+
+	// A waiting job leaves the queue as soon as its context is cancelled, even when paused
+	go func() {
+		<-j.ctx.Done()
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if j.status == StatusWaiting {
+			j.log.Debug().Printf("Cancelled, job discarded")
+			w.remove(j)
+		}
+	}()
+
+	w.dispatch()
+	return j
+}
+
+// dispatch starts waiting jobs while workers are available. w.mu must be held.
+func (w *Worker) dispatch() {
+	for w.busy < w.limit && len(w.free) > 0 {
+		i := 0
+		for ; i < len(w.queue); i++ {
+			j := w.queue[i]
+			if j.ctx.Err() != nil {
+				break
+			}
+			if l := w.limits[j.Group]; j.resumed == nil && (l == 0 || w.running[j.Group] < l) {
+				break
+			}
+		}
+		if i >= len(w.queue) {
+			return
+		}
+		j := w.queue[i]
+		w.queue = append(w.queue[:i], w.queue[i+1:]...)
+		if j.ctx.Err() != nil {
+			j.log.Debug().Printf("Cancelled, job discarded")
+			w.discard(j)
+			continue
+		}
+		queueDepth.Add(-1)
+		w.start(j)
+	}
+}
+
+// remove discards a waiting job from the queue. w.mu must be held.
+func (w *Worker) remove(j *Job) {
+	for i := range w.queue {
+		if w.queue[i] == j {
+			w.queue = append(w.queue[:i], w.queue[i+1:]...)
+			break
 		}
-		go fn(wid, int(id))
 	}
+	w.discard(j)
+}
+
+// discard a waiting job. w.mu must be held.
+func (w *Worker) discard(j *Job) {
+	queueDepth.Add(-1)
+	j.status = StatusCancelled
+	j.cancel()
+	close(j.done)
+}
+
+// start runs the job on a free worker. w.mu must be held.
+func (w *Worker) start(j *Job) {
+	wid := w.free[len(w.free)-1]
+	w.free = w.free[:len(w.free)-1]
+	w.inUse[wid] = true
+	w.busy++
+	w.running[j.Group]++
+	w.active = append(w.active, j)
+	j.status = StatusRunning
+	w.wg.Add(1)
+	busyWorkers.Add(1)
+
+	go func() {
+		log := j.log.With("worker", wid)
+		log.Debug().Printf("Job is started")
+		j.fn(j.ctx, j, wid)
+		log.Debug().Printf("Job is done")
+
+		w.mu.Lock()
+		w.busy--
+		w.running[j.Group]--
+		delete(w.inUse, wid)
+		if wid <= w.limit {
+			w.free = append(w.free, wid)
+		}
+		for i := range w.active {
+			if w.active[i] == j {
+				w.active = append(w.active[:i], w.active[i+1:]...)
+				break
+			}
+		}
+		if j.status == StatusRunning {
+			j.status = StatusDone
+		}
+		busyWorkers.Add(-1)
+		w.dispatch()
+		w.mu.Unlock()
+
+		j.cancel()
+		close(j.done)
+		w.wg.Done()
+	}()
 }
@@ -0,0 +1,243 @@
+package workers
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/simulot/aspiratv/mylog"
+)
+
+func quiet(t *testing.T) *mylog.MyLog {
+	l, err := mylog.NewLog("ERROR", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestPriorities(t *testing.T) {
+	ctx := context.Background()
+	w := New(ctx, 1, quiet(t))
+
+	// Keep the only worker busy while jobs are queued
+	release := make(chan struct{})
+	w.Submit(ctx, func(ctx context.Context, j *Job, wid int) { <-release })
+
+	mu := sync.Mutex{}
+	order := []string{}
+	job := func(name string) func(ctx context.Context, j *Job, wid int) {
+		return func(ctx context.Context, j *Job, wid int) {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}
+	}
+	jobs := []*Job{
+		w.Submit(ctx, job("low"), WithPriority(-1)),
+		w.Submit(ctx, job("normal 1")),
+		w.Submit(ctx, job("high"), WithPriority(10)),
+		w.Submit(ctx, job("normal 2")),
+		w.Submit(ctx, job("expires later"), WithDeadline(time.Now().Add(48*time.Hour))),
+		w.Submit(ctx, job("expires soon"), WithDeadline(time.Now().Add(time.Hour))),
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	discarded := w.Submit(cancelled, job("cancelled"), WithPriority(20))
+	cancel()
+
+	close(release)
+	<-discarded.Done()
+	for _, j := range jobs {
+		<-j.Done()
+	}
+	w.Stop(ctx)
+
+	want := []string{"high", "expires soon", "expires later", "normal 1", "normal 2", "low"}
+	if len(order) != len(want) {
+		t.Fatalf("got %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got %v, want %v", order, want)
+		}
+	}
+}
+
+func TestGroupLimit(t *testing.T) {
+	ctx := context.Background()
+	w := New(ctx, 4, quiet(t))
+	w.SetGroupLimit("francetv", 1)
+
+	mu := sync.Mutex{}
+	running, maxRunning := 0, 0
+	release := make(chan struct{})
+	jobs := []*Job{}
+	for i := 0; i < 3; i++ {
+		jobs = append(jobs, w.Submit(ctx, func(ctx context.Context, j *Job, wid int) {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			<-release
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}, WithGroup("francetv")))
+	}
+	// Other groups aren't blocked by the francetv limit
+	other := w.Submit(ctx, func(ctx context.Context, j *Job, wid int) {}, WithGroup("gulli"))
+	<-other.Done()
+
+	close(release)
+	for _, j := range jobs {
+		<-j.Done()
+	}
+	w.Stop(ctx)
+	if maxRunning != 1 {
+		t.Errorf("expecting at most 1 running job for the group, got %d", maxRunning)
+	}
+}
+
+func TestJobControl(t *testing.T) {
+	ctx := context.Background()
+	w := New(ctx, 1, quiet(t))
+
+	step := make(chan struct{})
+	ready := make(chan struct{})
+	steps := 0
+	running := w.Submit(ctx, func(ctx context.Context, j *Job, wid int) {
+		j.SetPausable(true)
+		close(ready)
+		for ctx.Err() == nil {
+			<-step
+			if j.WaitResumed(ctx) != nil {
+				return
+			}
+			steps++
+		}
+	})
+	waiting := w.Submit(ctx, func(ctx context.Context, j *Job, wid int) {
+		t.Error("cancelled job has been run")
+	})
+	if s := waiting.Status(); s != StatusWaiting {
+		t.Errorf("expecting waiting job, got %s", s)
+	}
+	waiting.Cancel()
+	<-waiting.Done()
+	if s := waiting.Status(); s != StatusCancelled {
+		t.Errorf("expecting cancelled job, got %s", s)
+	}
+
+	<-ready
+	if err := running.Pause(); err != nil {
+		t.Errorf("Pause() = %v", err)
+	}
+	if s := running.Status(); s != StatusPaused {
+		t.Errorf("expecting paused job, got %s", s)
+	}
+	step <- struct{}{}
+	running.Resume()
+	step <- struct{}{}
+	if s := running.Status(); s != StatusRunning {
+		t.Errorf("expecting running job, got %s", s)
+	}
+
+	// A second worker runs the next job while the first one is busy
+	w.Resize(2)
+	if n := w.Size(); n != 2 {
+		t.Errorf("expecting 2 workers, got %d", n)
+	}
+	other := w.Submit(ctx, func(ctx context.Context, j *Job, wid int) {})
+	<-other.Done()
+	if n := len(w.Jobs()); n != 1 {
+		t.Errorf("expecting 1 job, got %d", n)
+	}
+
+	running.Cancel()
+	close(step)
+	<-running.Done()
+	if s := running.Status(); s != StatusCancelled {
+		t.Errorf("expecting cancelled job, got %s", s)
+	}
+	if steps < 2 {
+		t.Errorf("expecting at least 2 steps, got %d", steps)
+	}
+	w.Stop(ctx)
+}
+
+func TestPauseNotPausable(t *testing.T) {
+	ctx := context.Background()
+	w := New(ctx, 1, quiet(t))
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	running := w.Submit(ctx, func(ctx context.Context, j *Job, wid int) {
+		close(started)
+		<-release
+	})
+	<-started
+	if err := running.Pause(); err != ErrNotPausable {
+		t.Errorf("Pause() = %v, want ErrNotPausable", err)
+	}
+	if s := running.Status(); s != StatusRunning {
+		t.Errorf("expecting running job, got %s", s)
+	}
+
+	// A paused waiting job leaves the queue when its context is cancelled, while the worker is busy
+	jobCtx, cancel := context.WithCancel(ctx)
+	waiting := w.Submit(jobCtx, func(ctx context.Context, j *Job, wid int) {
+		t.Error("cancelled job has been run")
+	})
+	if err := waiting.Pause(); err != nil {
+		t.Errorf("Pause() = %v", err)
+	}
+	cancel()
+	select {
+	case <-waiting.Done():
+	case <-time.After(time.Second):
+		t.Fatal("cancelled waiting job still queued")
+	}
+	if n := len(w.Jobs()); n != 1 {
+		t.Errorf("expecting 1 job, got %d", n)
+	}
+	close(release)
+	w.Stop(ctx)
+}
+
+func TestResizeIDs(t *testing.T) {
+	ctx := context.Background()
+	w := New(ctx, 4, quiet(t))
+
+	// A job keeps a worker busy while the pool shrinks and grows again
+	started := make(chan int)
+	release := make(chan struct{})
+	j := w.Submit(ctx, func(ctx context.Context, j *Job, wid int) {
+		started <- wid
+		<-release
+	})
+	busy := <-started
+	w.Resize(2)
+	w.Resize(1)
+	w.Resize(4)
+
+	w.mu.Lock()
+	ids := map[int]bool{busy: true}
+	for _, id := range w.free {
+		if ids[id] || id < 1 || id > 4 {
+			t.Errorf("worker %d is free twice, busy or out of range: free %v, busy %d", id, w.free, busy)
+		}
+		ids[id] = true
+	}
+	if len(ids) != 4 {
+		t.Errorf("expecting 4 worker identifiers, got free %v, busy %d", w.free, busy)
+	}
+	w.mu.Unlock()
+
+	close(release)
+	<-j.Done()
+	w.Stop(ctx)
+}